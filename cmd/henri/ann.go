@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/chriskillpack/henri"
+)
+
+// annIterations is the number of k-means rounds used to build an annIndex.
+const annIterations = 8
+
+// annIndex is an approximate nearest neighbour index over embeddings. It is an
+// inverted file: the embeddings are clustered around centroids, and a search
+// only scores the embeddings in the clusters nearest to the query.
+type annIndex struct {
+	centroids [][]float32 // unit length
+	lists     [][]*henri.Embedding
+	nprobe    int // clusters scored by a search
+}
+
+// newANNIndex clusters embeds into about the square root of their number of
+// lists. Searches score a quarter of the lists.
+func newANNIndex(embeds []*henri.Embedding) *annIndex {
+	nlist := max(1, int(math.Sqrt(float64(len(embeds)))))
+	ix := &annIndex{nprobe: max(1, nlist/4)}
+	if len(embeds) == 0 {
+		return ix
+	}
+
+	units := make([][]float32, len(embeds))
+	for i, emb := range embeds {
+		units[i] = unitVector(emb.Vector)
+	}
+
+	// Start from evenly spaced embeddings, so the index is deterministic
+	for i := range nlist {
+		ix.centroids = append(ix.centroids, slices.Clone(units[i*len(units)/nlist]))
+	}
+
+	assign := make([]int, len(units))
+	for range annIterations {
+		for i, u := range units {
+			assign[i] = ix.nearest(u, 1)[0]
+		}
+
+		sums := make([][]float32, nlist)
+		for i, u := range units {
+			c := assign[i]
+			if sums[c] == nil {
+				sums[c] = make([]float32, len(u))
+			}
+			for j, v := range u {
+				sums[c][j] += v
+			}
+		}
+		for c, sum := range sums {
+			if sum != nil {
+				ix.centroids[c] = unitVector(sum) // empty clusters keep their centroid
+			}
+		}
+	}
+
+	ix.lists = make([][]*henri.Embedding, nlist)
+	for i, emb := range embeds {
+		ix.lists[assign[i]] = append(ix.lists[assign[i]], emb)
+	}
+	return ix
+}
+
+// nearest returns the indexes of the n centroids most similar to the unit
+// vector u, most similar first.
+func (ix *annIndex) nearest(u []float32, n int) []int {
+	order := make([]int, len(ix.centroids))
+	scores := make([]float32, len(ix.centroids))
+	for i, c := range ix.centroids {
+		order[i] = i
+		if len(c) == len(u) {
+			scores[i] = dotp(u, c)
+		}
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(scores[b], scores[a]) })
+	return order[:min(n, len(order))]
+}
+
+// topK returns the k embeddings most similar to vec in the lists nearest to
+// it, scored the same way as an exact search.
+func (ix *annIndex) topK(vec []float32, k int) (*TopKTracker, error) {
+	topk := NewTopKTracker(k)
+	for _, c := range ix.nearest(unitVector(vec), ix.nprobe) {
+		for _, emb := range ix.lists[c] {
+			score, err := computeCosineSimilarity(vec, emb.Vector)
+			if err != nil {
+				return nil, err
+			}
+			topk.ProcessItem(emb, score)
+		}
+	}
+	return topk, nil
+}
+
+// unitVector returns v scaled to unit length, or a copy of v if it is zero.
+func unitVector(v []float32) []float32 {
+	u := slices.Clone(v)
+	norm := math.Sqrt(float64(dotp(v, v)))
+	if norm == 0 {
+		return u
+	}
+	for i := range u {
+		u[i] = float32(float64(u[i]) / norm)
+	}
+	return u
+}
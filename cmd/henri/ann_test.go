@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestANNIndex(t *testing.T) {
+	var embeds []*henri.Embedding
+	for i := range 100 {
+		angle := float64(i) * 2 * math.Pi / 100
+		embeds = append(embeds, &henri.Embedding{ImageId: i + 1, Vector: []float32{float32(math.Cos(angle)), float32(math.Sin(angle))}})
+	}
+	ix := newANNIndex(embeds)
+	if expected, actual := 10, len(ix.lists); expected != actual {
+		t.Errorf("Expected %d lists, got %d", expected, actual)
+	}
+
+	topk, err := ix.topK([]float32{1, 0}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := []int{1}, rankedImages(topk); !slices.Equal(expected, actual) {
+		t.Errorf("Expected nearest %v, got %v", expected, actual)
+	}
+}
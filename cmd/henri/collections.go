@@ -0,0 +1,304 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+var (
+	collection = flag.String("collection", "", "Collection to scan into, or comma separated collections to search")
+	prompt     = flag.String("prompt", "", "Prompt to describe a collection's images with")
+	modelName  = flag.String("model", "", "Model to describe images with instead of the describer's, describe then only describes images it hasn't")
+
+	includePatterns, excludePatterns patternsFlag
+)
+
+func init() {
+	flag.Var(&includePatterns, "include", "Gitignore style pattern of the images to scan, or to scan into a collection, may be repeated")
+	flag.Var(&excludePatterns, "exclude", "Gitignore style pattern of the images to skip, or to leave out of a collection, may be repeated")
+}
+
+// patternsFlag is a flag that can be repeated to build a list of gitignore
+// style patterns. An empty value clears the list.
+type patternsFlag []string
+
+func (pf *patternsFlag) String() string { return strings.Join(*pf, ",") }
+
+func (pf *patternsFlag) Set(s string) error {
+	if s == "" {
+		*pf = nil
+		return nil
+	}
+	if _, _, err := parsePattern(s); err != nil {
+		return err
+	}
+	*pf = append(*pf, s)
+	return nil
+}
+
+// collectionBackendURI returns the backend URI to describe the images in c
+// with, which adds c's prompt to its describer or defaultURI. It returns ""
+// if c has no describer settings of its own.
+func collectionBackendURI(c *henri.Collection, defaultURI string) (string, error) {
+	if c.Describer == "" && c.Prompt == "" {
+		return "", nil
+	}
+	uri := cmp.Or(c.Describer, defaultURI)
+	if uri == "" {
+		return "", fmt.Errorf("collection %q has a prompt but no describer", c.Name)
+	}
+	if c.Prompt == "" {
+		return uri, nil
+	}
+
+	uri, err := withURIParam(uri, "prompt", c.Prompt)
+	if err != nil {
+		return "", fmt.Errorf("collection %q - %w", c.Name, err)
+	}
+	return uri, nil
+}
+
+// withURIParam returns the backend URI uri with its name param set to value.
+func withURIParam(uri, name, value string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	params := u.Query()
+	params.Set(name, value)
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+// collectionDescribers picks the describer for each image, the one set up for
+// its collection or the default.
+type collectionDescribers struct {
+	def    describer.ImageDescriber
+	byColl map[int]describer.ImageDescriber
+}
+
+// newCollectionDescribers opens the describers of the collections with their
+// own backend or prompt.
+func newCollectionDescribers(ctx context.Context, h *henri.Henri) (*collectionDescribers, error) {
+	collections, err := h.DB.Collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cds := &collectionDescribers{def: h.Describer, byColl: map[int]describer.ImageDescriber{}}
+	for _, c := range collections {
+		uri, err := collectionBackendURI(c, h.DescribeURI)
+		if err != nil {
+			return nil, err
+		}
+		if uri == "" {
+			continue
+		}
+		if *modelName != "" {
+			if uri, err = withURIParam(uri, "model", *modelName); err != nil {
+				return nil, fmt.Errorf("collection %q - %w", c.Name, err)
+			}
+		}
+		d, err := h.OpenDescriber(uri)
+		if err != nil {
+			return nil, fmt.Errorf("collection %q - %w", c.Name, err)
+		}
+		if !d.IsHealthy() {
+			return nil, fmt.Errorf("%s server for collection %q is not responding", d.Name(), c.Name)
+		}
+		cds.byColl[c.Id] = d
+	}
+
+	return cds, nil
+}
+
+// forImage returns the describer for img.
+func (cds *collectionDescribers) forImage(img *henri.Image) describer.ImageDescriber {
+	if d, ok := cds.byColl[img.CollectionId]; ok {
+		return d
+	}
+	return cds.def
+}
+
+// imagesMissingDescriptions returns the images lacking a description in the
+// description set of their describer, the model and prompt they would be
+// described with. Images that could not be described are left out.
+func (cds *collectionDescribers) imagesMissingDescriptions(ctx context.Context, db *henri.DB) ([]*henri.Image, error) {
+	images, err := db.Images(ctx)
+	if err != nil {
+		return nil, err
+	}
+	described, err := db.ImageDescriptionSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []*henri.Image
+	for _, img := range images {
+		if !img.ProcessedAt.Valid && img.AttemptedAt.Valid {
+			continue
+		}
+		if !slices.Contains(described[img.Id], descriptionSet(cds.forImage(img))) {
+			missing = append(missing, img)
+		}
+	}
+	return missing, nil
+}
+
+// descriptionSet returns the set of the descriptions made by d.
+func descriptionSet(d describer.ImageDescriber) henri.DescriptionSet {
+	return henri.DescriptionSet{Model: d.Model(), PromptVersion: describer.PromptVersion(d.Prompt())}
+}
+
+// describeFn returns a work function describing each image with the describer
+// for its collection.
+func (cds *collectionDescribers) describeFn(db *henri.DB) imageWorkFn {
+	return func(ctx context.Context, img *henri.Image, progress func(describer.Progress)) error {
+		return describeImageFn(ctx, cds.forImage(img), img, db, progress)
+	}
+}
+
+// collectionIds returns the ids of the collections called names.
+func collectionIds(ctx context.Context, db *henri.DB, names []string) ([]int, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	collections, err := db.Collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(names))
+	for i, name := range names {
+		j := slices.IndexFunc(collections, func(c *henri.Collection) bool { return c.Name == name })
+		if j < 0 {
+			return nil, fmt.Errorf("no collection %q", name)
+		}
+		ids[i] = collections[j].Id
+	}
+	return ids, nil
+}
+
+// splitNames splits a comma separated list of collection names.
+func splitNames(s string) []string {
+	var names []string
+	for name := range strings.SplitSeq(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// applyCollectionFlags copies the collection settings given on the command
+// line to c.
+func applyCollectionFlags(c *henri.Collection) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "describer":
+			c.Describer = *describeWith
+		case "prompt":
+			c.Prompt = *prompt
+		case "include":
+			c.Include = includePatterns
+		case "exclude":
+			c.Exclude = excludePatterns
+		}
+	})
+}
+
+// validateCollection checks that the describer settings of c can be opened.
+func validateCollection(c *henri.Collection) error {
+	if c.Describer == "" {
+		// The prompt is checked against the default describer when
+		// describing
+		return nil
+	}
+	uri, err := collectionBackendURI(c, "")
+	if err != nil {
+		return err
+	}
+	_, _, err = describer.ParseConfig(uri)
+	return err
+}
+
+// collectionCommand runs henri collection <name>, and lists the collections.
+func collectionCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing collection name")
+	}
+	if err := configureCollection(ctx, args[0], h.DB); err != nil {
+		return err
+	}
+	return printCollections(ctx, os.Stdout, h.DB)
+}
+
+// configureCollection creates or updates the collection called name with the
+// settings given on the command line.
+func configureCollection(ctx context.Context, name string, db *henri.DB) error {
+	collections, err := db.Collections(ctx)
+	if err != nil {
+		return err
+	}
+	c := &henri.Collection{Name: name}
+	if i := slices.IndexFunc(collections, func(c *henri.Collection) bool { return c.Name == name }); i >= 0 {
+		c = collections[i]
+	}
+
+	applyCollectionFlags(c)
+	if err := validateCollection(c); err != nil {
+		return err
+	}
+
+	if c.Id == 0 {
+		created, err := db.EnsureCollection(ctx, name)
+		if err != nil {
+			return err
+		}
+		c.Id = created.Id
+	}
+	return db.UpdateCollection(ctx, c)
+}
+
+// printCollections writes the collections and the library roots in them to w.
+func printCollections(ctx context.Context, w io.Writer, db *henri.DB) error {
+	collections, err := db.Collections(ctx)
+	if err != nil {
+		return err
+	}
+	roots, err := db.Roots(ctx)
+	if err != nil {
+		return err
+	}
+	if len(collections) == 0 {
+		fmt.Fprintln(w, "No collections, create one with henri collection <name>")
+	}
+
+	for _, c := range collections {
+		fmt.Fprintf(w, "%s, %d images\n", c.Name, c.Images)
+		fmt.Fprintf(w, "  describer %s\n", cmp.Or(c.Describer, "(default)"))
+		fmt.Fprintf(w, "  prompt    %s\n", cmp.Or(c.Prompt, "(default)"))
+		if len(c.Include) > 0 {
+			fmt.Fprintf(w, "  include   %s\n", strings.Join(c.Include, " "))
+		}
+		if len(c.Exclude) > 0 {
+			fmt.Fprintf(w, "  exclude   %s\n", strings.Join(c.Exclude, " "))
+		}
+		for _, r := range roots {
+			if r.CollectionId == c.Id {
+				fmt.Fprintf(w, "  root      %s %s\n", r.Name, r.Path)
+			}
+		}
+	}
+	return nil
+}
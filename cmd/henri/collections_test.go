@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri/describer"
+)
+
+func TestCollections(t *testing.T) {
+	family, work := t.TempDir(), t.TempDir()
+	writeJPEG(t, filepath.Join(family, "a.jpg"), 16, 8, color.RGBA{255, 0, 0, 255})
+	writeJPEG(t, filepath.Join(work, "b.jpg"), 8, 16, color.RGBA{0, 255, 0, 255})
+	writeJPEG(t, filepath.Join(work, "b-thumb.jpg"), 4, 8, color.RGBA{0, 255, 0, 255})
+
+	h := newTestHenri(t, AppModePipeline)
+
+	// Work screenshots are described with their own prompt and skip
+	// thumbnails
+	c, err := h.DB.EnsureCollection(t.Context(), "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Prompt = "transcribe the text"
+	c.Exclude = []string{"*-thumb.jpg"}
+	if err := h.DB.UpdateCollection(t.Context(), c); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { *collection = "" }()
+	for _, lib := range []struct{ collection, path string }{{"family", family}, {"work", work}} {
+		*collection = lib.collection
+		if err := runPipeline(t.Context(), lib.path, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collections, err := h.DB.Collections(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, len(collections); expected != actual {
+		t.Fatalf("Expected %d collections, got %d", expected, actual)
+	}
+	for i, expected := range []int{1, 1} {
+		if actual := collections[i].Images; expected != actual {
+			t.Errorf("Expected %d images in %s, got %d", expected, collections[i].Name, actual)
+		}
+	}
+
+	images, err := h.DB.DescribedImages(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, len(images); expected != actual {
+		t.Fatalf("Expected %d described images, got %d", expected, actual)
+	}
+	if strings.HasPrefix(images[0].Description, c.Prompt) {
+		t.Errorf("Expected the default prompt for family images, got %q", images[0].Description)
+	}
+	if !strings.HasPrefix(images[1].Description, c.Prompt) {
+		t.Errorf("Expected the collection prompt for work images, got %q", images[1].Description)
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	tests := []struct {
+		query  string
+		status int
+		images []string
+	}{
+		{"", http.StatusOK, []string{"/image/1", "/image/2"}},
+		{"&collection=family", http.StatusOK, []string{"/image/1"}},
+		{"&collection=work", http.StatusOK, []string{"/image/2"}},
+		{"&collection=family&collection=work", http.StatusOK, []string{"/image/1", "/image/2"}},
+		{"&collection=film", http.StatusBadRequest, nil},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(ts.URL + "/search?q=image" + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%s: expected status %d, got %d", tc.query, expected, actual)
+			continue
+		}
+		if expected, actual := len(tc.images), strings.Count(string(body), `src="/image/`); expected != actual {
+			t.Errorf("%s: expected %d results, got %d", tc.query, expected, actual)
+		}
+		for _, img := range tc.images {
+			if !strings.Contains(string(body), `src="`+img+`"`) {
+				t.Errorf("%s: expected results to include %s", tc.query, img)
+			}
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `value="work"`) {
+		t.Errorf("Expected a collection selector, got %s", body)
+	}
+}
+
+func TestDescriptionSets(t *testing.T) {
+	h := indexTestLibrary(t, newTestLibrary(t, 2))
+
+	// Describe again with another model and prompt
+	d, err := h.OpenDescriber("fake://?model=better&prompt=tersely")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Describer = d
+	cds, err := newCollectionDescribers(t.Context(), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := cds.imagesMissingDescriptions(t.Context(), h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, len(missing); expected != actual {
+		t.Fatalf("Expected %d images missing descriptions, got %d", expected, actual)
+	}
+	for _, img := range missing {
+		if err := cds.describeFn(h.DB)(t.Context(), img, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if missing, err = cds.imagesMissingDescriptions(t.Context(), h.DB); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, len(missing); expected != actual {
+		t.Errorf("Expected %d images missing descriptions after describing, got %d", expected, actual)
+	}
+
+	// The current descriptions are kept
+	img, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "fake-64", img.Model; expected != actual {
+		t.Errorf("Expected the current description by %s, got %s", expected, actual)
+	}
+	if strings.HasPrefix(img.Description, "tersely") {
+		t.Errorf("Expected the current description to be kept, got %q", img.Description)
+	}
+
+	better := "better@" + describer.PromptVersion("tersely")
+	sets, err := h.DB.DescriptionSets(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ds := range sets {
+		names = append(names, fmt.Sprintf("%s %d", ds, ds.Images))
+	}
+	if expected, actual := []string{better + " 2", "fake-64 2"}, names; !slices.Equal(expected, actual) {
+		t.Errorf("Expected description sets %v, got %v", expected, actual)
+	}
+
+	// Embed the new descriptions
+	filter, err := imageFilter(t.Context(), h.DB, url.Values{"descriptions": {better}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := h.DB.DescriptionsMissingEmbeddings(t.Context(), h.Embedder.Model(), filter.Descriptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, len(images); expected != actual {
+		t.Fatalf("Expected %d descriptions missing embeddings, got %d", expected, actual)
+	}
+	for _, img := range images {
+		if err := calcEmbeddingFn(t.Context(), h.Embedder, img, h.DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if images, err = h.DB.DescriptionsMissingEmbeddings(t.Context(), h.Embedder.Model(), filter.Descriptions); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, len(images); expected != actual {
+		t.Errorf("Expected %d descriptions missing embeddings after embedding, got %d", expected, actual)
+	}
+
+	if _, err := imageFilter(t.Context(), h.DB, url.Values{"descriptions": {"llava"}}); err == nil {
+		t.Errorf("Expected an error for descriptions by a model with none")
+	}
+
+	// Search picks the descriptions
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	tests := []struct {
+		descriptions string
+		status       int
+		tersely      bool
+	}{
+		{"", http.StatusOK, false},
+		{better, http.StatusOK, true},
+		{"fake-64", http.StatusOK, false},
+		{"llava", http.StatusBadRequest, false},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(ts.URL + "/search?" + url.Values{"q": {"image"}, "descriptions": {tc.descriptions}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%q: expected status %d, got %d", tc.descriptions, expected, actual)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if expected, actual := 2, strings.Count(string(body), `href="/images/`); expected != actual {
+			t.Errorf("%q: expected %d results, got %d", tc.descriptions, expected, actual)
+		}
+		if expected, actual := tc.tersely, strings.Contains(string(body), "tersely"); expected != actual {
+			t.Errorf("%q: expected descriptions from the prompt %t, got %t", tc.descriptions, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+// Search queries are compound: `beach -people`, `dog AND snow`, `sunset^2 city`.
+// Runs of plain words are clauses embedded on their own, a quoted phrase is one
+// clause, ^w after a clause weighs it, - marks a clause images are penalized
+// for matching, AND joins clauses that images must all match, and + marks a
+// keyword descriptions must contain. A query of plain words is a single clause
+// and searches as it always has.
+
+// queryClause is a part of a search query that is embedded on its own.
+type queryClause struct {
+	Text   string
+	Weight float64
+
+	vec []float32 // set by queryScorer
+}
+
+// compoundQuery is a parsed search query.
+type compoundQuery struct {
+	Groups   [][]queryClause // clauses joined by AND, scored by their least similar
+	Negative []queryClause   // clauses to penalize similarity to
+	Keywords []string        // words or phrases descriptions must contain
+}
+
+// queryToken is a word or quoted phrase of a query, with its prefix and weight.
+type queryToken struct {
+	text   string
+	prefix byte // '-', '+' or 0
+	quoted bool
+	weight float64 // 0 unless given with ^
+}
+
+// parseQuery parses a search query. It is an error for a query to have no
+// clause to search for.
+func parseQuery(query string) (*compoundQuery, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		cq      compoundQuery
+		group   []queryClause
+		words   []string // of the open clause
+		weight  float64
+		joining bool // after AND
+	)
+	closeClause := func() {
+		if len(words) > 0 {
+			group = append(group, queryClause{Text: strings.Join(words, " "), Weight: cmp.Or(weight, 1)})
+			words, weight = nil, 0
+		}
+	}
+	closeGroup := func() {
+		closeClause()
+		if len(group) > 0 {
+			cq.Groups = append(cq.Groups, group)
+			group = nil
+		}
+	}
+
+	for _, tok := range tokens {
+		switch {
+		case tok.prefix == 0 && !tok.quoted && tok.text == "AND" && tok.weight == 0:
+			if joining || (len(words) == 0 && len(group) == 0) {
+				return nil, fmt.Errorf("AND needs a clause before it")
+			}
+			closeClause()
+			joining = true
+			continue
+		case tok.prefix != 0 && joining:
+			return nil, fmt.Errorf("AND can only join clauses to search for, not %c%s", tok.prefix, tok.text)
+		case tok.prefix == '-':
+			closeGroup()
+			cq.Negative = append(cq.Negative, queryClause{Text: tok.text, Weight: cmp.Or(tok.weight, 1)})
+			continue
+		case tok.prefix == '+':
+			if tok.weight != 0 {
+				return nil, fmt.Errorf("keyword +%s cannot be weighted", tok.text)
+			}
+			closeGroup()
+			cq.Keywords = append(cq.Keywords, tok.text)
+			continue
+		}
+
+		// A phrase, or a word after a closed clause, starts a new clause
+		// unless it is joined by AND
+		if (tok.quoted || len(words) == 0) && !joining {
+			closeGroup()
+		}
+		joining = false
+		words = append(words, tok.text)
+		if tok.quoted || tok.weight != 0 {
+			weight = tok.weight
+			closeClause()
+		}
+	}
+	if joining {
+		return nil, fmt.Errorf("AND needs a clause after it")
+	}
+	closeGroup()
+
+	if len(cq.Groups) == 0 {
+		return nil, fmt.Errorf("query %q has nothing to search for", query)
+	}
+	return &cq, nil
+}
+
+// tokenizeQuery splits query into words and quoted phrases.
+func tokenizeQuery(query string) ([]queryToken, error) {
+	// spaceAt returns whether the rune at i is a space, and its width
+	spaceAt := func(i int) (bool, int) {
+		r, n := utf8.DecodeRuneInString(query[i:])
+		return unicode.IsSpace(r), n
+	}
+	end := func(i int) int {
+		for i < len(query) {
+			space, n := spaceAt(i)
+			if space {
+				break
+			}
+			i += n
+		}
+		return i
+	}
+
+	var tokens []queryToken
+	for i := 0; i < len(query); {
+		if space, n := spaceAt(i); space {
+			i += n
+			continue
+		}
+
+		var tok queryToken
+		start := i
+		if c := query[i]; (c == '-' || c == '+') && end(i+1) > i+1 {
+			tok.prefix = c
+			i++
+		}
+
+		if query[i] == '"' {
+			n := strings.IndexByte(query[i+1:], '"')
+			if n < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", query[start:])
+			}
+			tok.text, tok.quoted = strings.TrimSpace(query[i+1:i+1+n]), true
+			i += n + 2
+
+			j := end(i)
+			if suffix := query[i:j]; suffix != "" {
+				w, ok, err := parseWeight(suffix)
+				if err != nil {
+					return nil, err
+				} else if !ok {
+					return nil, fmt.Errorf("unexpected %q after quote in %q", suffix, query[start:j])
+				}
+				tok.weight = w
+			}
+			i = j
+		} else {
+			j := end(i)
+			tok.text = query[i:j]
+			if k := strings.LastIndexByte(tok.text, '^'); k > 0 {
+				w, ok, err := parseWeight(tok.text[k:])
+				if err != nil {
+					return nil, err
+				} else if ok {
+					tok.text, tok.weight = tok.text[:k], w
+				}
+			}
+			i = j
+		}
+
+		if tok.text == "" {
+			return nil, fmt.Errorf("empty clause %q", query[start:i])
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+// parseWeight parses a weight of the form ^w. It returns false if s is not of
+// that form, and an error if w is not positive.
+func parseWeight(s string) (float64, bool, error) {
+	w, err := strconv.ParseFloat(strings.TrimPrefix(s, "^"), 64)
+	if !strings.HasPrefix(s, "^") || err != nil {
+		return 0, false, nil
+	}
+	if w <= 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+		return 0, false, fmt.Errorf("weight %s must be a positive number", s)
+	}
+	return w, true, nil
+}
+
+// simple returns whether cq is a single clause, searched as it always has been.
+func (cq *compoundQuery) simple() bool {
+	return len(cq.Groups) == 1 && len(cq.Groups[0]) == 1 && cq.Groups[0][0].Weight == 1 && len(cq.Negative) == 0
+}
+
+// scoreFn scores an embedding vector against a query.
+type scoreFn func(vec []float32) (float32, error)
+
+// cosineScore scores embedding vectors by their cosine similarity to queryvec.
+func cosineScore(queryvec []float32) scoreFn {
+	return func(vec []float32) (float32, error) {
+		return computeCosineSimilarity(queryvec, vec)
+	}
+}
+
+// queryScorer embeds the clauses of cq with d and returns the function
+// scoring embeddings against it. A single clause query is adjusted by the
+// feedback on query, and scored by cosine similarity. Otherwise an embedding
+// scores the weighted mean of its similarity to each group, the similarity to
+// the least similar clause of the group, less its weighted similarity to each
+// negative clause. A group weighs as much as its heaviest clause.
+func queryScorer(ctx context.Context, query string, cq *compoundQuery, d describer.TextEmbedder, db *henri.DB) (scoreFn, error) {
+	if cq.simple() {
+		queryvec, err := d.Embeddings(ctx, cq.Groups[0][0].Text)
+		if err != nil {
+			return nil, err
+		}
+		if queryvec, err = adjustQuery(ctx, query, d.Model(), queryvec, db); err != nil {
+			return nil, err
+		}
+		return cosineScore(queryvec), nil
+	}
+
+	embed := func(clauses []queryClause) error {
+		for i := range clauses {
+			vec, err := d.Embeddings(ctx, clauses[i].Text)
+			if err != nil {
+				return fmt.Errorf("clause %q - %w", clauses[i].Text, err)
+			}
+			clauses[i].vec = vec
+		}
+		return nil
+	}
+	for _, group := range cq.Groups {
+		if err := embed(group); err != nil {
+			return nil, err
+		}
+	}
+	if err := embed(cq.Negative); err != nil {
+		return nil, err
+	}
+
+	return func(vec []float32) (float32, error) {
+		var sum, weights float64
+		for _, group := range cq.Groups {
+			least, weight := math.Inf(1), 0.0
+			for _, c := range group {
+				sim, err := computeCosineSimilarity(c.vec, vec)
+				if err != nil {
+					return 0, err
+				}
+				least, weight = min(least, float64(sim)), max(weight, c.Weight)
+			}
+			sum += weight * least
+			weights += weight
+		}
+		score := sum / weights
+
+		for _, c := range cq.Negative {
+			sim, err := computeCosineSimilarity(c.vec, vec)
+			if err != nil {
+				return 0, err
+			}
+			score -= c.Weight * max(0, float64(sim))
+		}
+		return float32(score), nil
+	}, nil
+}
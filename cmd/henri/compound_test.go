@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	type cl = queryClause
+	tests := []struct {
+		query string
+		want  *compoundQuery
+		err   bool
+	}{
+		{"red car", &compoundQuery{Groups: [][]cl{{{Text: "red car", Weight: 1}}}}, false},
+		{"  red   car ", &compoundQuery{Groups: [][]cl{{{Text: "red car", Weight: 1}}}}, false},
+		{"beach -people", &compoundQuery{
+			Groups:   [][]cl{{{Text: "beach", Weight: 1}}},
+			Negative: []cl{{Text: "people", Weight: 1}},
+		}, false},
+		{"dog AND snow", &compoundQuery{Groups: [][]cl{{{Text: "dog", Weight: 1}, {Text: "snow", Weight: 1}}}}, false},
+		{"big dog AND snow -cat^0.5", &compoundQuery{
+			Groups:   [][]cl{{{Text: "big dog", Weight: 1}, {Text: "snow", Weight: 1}}},
+			Negative: []cl{{Text: "cat", Weight: 0.5}},
+		}, false},
+		{"sunset^2 city", &compoundQuery{Groups: [][]cl{{{Text: "sunset", Weight: 2}}, {{Text: "city", Weight: 1}}}}, false},
+		{`"red car"^1.5 "blue sky"`, &compoundQuery{Groups: [][]cl{{{Text: "red car", Weight: 1.5}}, {{Text: "blue sky", Weight: 1}}}}, false},
+		{`-"parked cars" +beach +"sand castle" sea`, &compoundQuery{
+			Groups:   [][]cl{{{Text: "sea", Weight: 1}}},
+			Negative: []cl{{Text: "parked cars", Weight: 1}},
+			Keywords: []string{"beach", "sand castle"},
+		}, false},
+		{"t-shirt - and c++ x^y", &compoundQuery{Groups: [][]cl{{{Text: "t-shirt - and c++ x^y", Weight: 1}}}}, false},
+		{"voilà plage", &compoundQuery{Groups: [][]cl{{{Text: "voilà plage", Weight: 1}}}}, false},
+		{"Рыба\u00a0хлеб -х +voilà", &compoundQuery{
+			Groups:   [][]cl{{{Text: "Рыба хлеб", Weight: 1}}},
+			Negative: []cl{{Text: "х", Weight: 1}},
+			Keywords: []string{"voilà"},
+		}, false},
+		{"-\u00a0plage", &compoundQuery{Groups: [][]cl{{{Text: "- plage", Weight: 1}}}}, false},
+		{"-people", nil, true},
+		{"+beach", nil, true},
+		{"", nil, true},
+		{"AND dog", nil, true},
+		{"dog AND", nil, true},
+		{"dog AND AND snow", nil, true},
+		{"dog AND -cat", nil, true},
+		{"dog^0", nil, true},
+		{"dog^-1", nil, true},
+		{"+beach^2 dog", nil, true},
+		{`"red car`, nil, true},
+		{`"red car"s`, nil, true},
+		{`"" dog`, nil, true},
+	}
+	for _, tc := range tests {
+		cq, err := parseQuery(tc.query)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.query, cq)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.want, cq) {
+			t.Errorf("%q: expected %+v, got %+v", tc.query, tc.want, cq)
+		}
+	}
+}
+
+func TestCompoundSearch(t *testing.T) {
+	library := t.TempDir()
+	for i := range 4 {
+		writeJPEG(t, filepath.Join(library, fmt.Sprintf("%d.jpg", i)), 8+i, 8, color.RGBA{uint8(60 * i), 0, 0, 255})
+	}
+
+	h := indexTestLibrary(t, library)
+
+	img, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emb, err := h.Embedder.Embeddings(t.Context(), img.Description)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Penalizing the description itself must lower the image's score, and
+	// requiring an unrelated clause as well must too
+	score := func(query string) float32 {
+		cq, err := parseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn, err := queryScorer(t.Context(), query, cq, h.Embedder, h.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := fn(emb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	plain := score(fmt.Sprintf("%q", img.Description))
+	if actual := score(fmt.Sprintf("%q -%q", img.Description, img.Description)); actual >= plain {
+		t.Errorf("Expected the negative clause to lower the score %v, got %v", plain, actual)
+	}
+	if actual := score(fmt.Sprintf("%q AND xylophone", img.Description)); actual >= plain {
+		t.Errorf("Expected the AND clause to lower the score %v, got %v", plain, actual)
+	}
+	if expected, actual := plain, score(fmt.Sprintf("%q^3", img.Description)); math.Abs(float64(expected-actual)) > 1e-6 {
+		t.Errorf("Expected a single weighted clause to score %v, got %v", expected, actual)
+	}
+
+	// Keywords only match the images whose descriptions contain them as
+	// whole words
+	word := strings.Fields(img.Description)[4]
+	images, err := h.DB.DescribedImages(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var matching int
+	for _, im := range images {
+		if slices.Contains(strings.Fields(strings.ToLower(im.Description)), strings.ToLower(word)) {
+			matching++
+		}
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	tests := []struct {
+		q       string
+		status  int
+		results int
+	}{
+		{"image -cat", http.StatusOK, 4},
+		{"image AND photo", http.StatusOK, 4},
+		{"image +" + strings.ToUpper(word), http.StatusOK, matching},
+		{"image +xylophone", http.StatusOK, 0},
+		{"image +" + word[:len(word)-1], http.StatusOK, 0},
+		{"image AND", http.StatusBadRequest, 0},
+		{`"image`, http.StatusBadRequest, 0},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(ts.URL + "/search?" + url.Values{"q": {tc.q}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%q: expected status %d, got %d", tc.q, expected, actual)
+			continue
+		}
+		if tc.status == http.StatusOK {
+			if expected, actual := tc.results, strings.Count(string(body), `class="searchresult"`); expected != actual {
+				t.Errorf("%q: expected %d results, got %d", tc.q, expected, actual)
+			}
+		}
+	}
+
+	// Keywords match words of any script
+	if err := h.DB.EditImageDescription(t.Context(), 2, "Voilà, une plage au soleil", "test", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPipeline(t.Context(), library, h); err != nil {
+		t.Fatal(err)
+	}
+	for q, expected := range map[string]int{"image +voilà": 1, "image +VOILÀ": 1, "image +voil": 0} {
+		resp, err := http.Get(ts.URL + "/search?" + url.Values{"q": {q}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if actual := strings.Count(string(body), `class="searchresult"`); expected != actual {
+			t.Errorf("%q: expected %d results, got %d", q, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var mmrLambda = flag.String("mmr", "1", "Weight from 0 to 1 of similarity to the query over difference between search results, below 1 diversifies them")
+
+const (
+	// mmrPool is how many times more candidates than results are
+	// diversified from.
+	mmrPool = 4
+
+	// diverseLambda is the lambda of the web UI's diverse results option,
+	// unless the server diversifies by default.
+	diverseLambda = "0.7"
+)
+
+// parseLambda parses the MMR lambda s, the weight given to similarity to the
+// query over difference from the results already chosen. 1 turns
+// diversification off.
+func parseLambda(s string) (float64, error) {
+	lambda, err := strconv.ParseFloat(s, 64)
+	if err != nil || lambda < 0 || lambda > 1 {
+		return 0, fmt.Errorf("invalid mmr %q, expected a number from 0 to 1", s)
+	}
+	return lambda, nil
+}
+
+// searchPool returns the number of candidates to search for to return n
+// results diversified with lambda.
+func searchPool(n int, lambda float64) int {
+	if lambda < 1 {
+		return n * mmrPool
+	}
+	return n
+}
+
+// diversify picks k of candidates, which are best first, by Maximal Marginal
+// Relevance. Each pick is the candidate with the highest lambda weighted
+// similarity to the query less its greatest similarity to those already
+// picked, so near duplicates of a pick fall behind different images. The
+// picks are returned in the order they were picked.
+func diversify(candidates []embedscore, k int, lambda float64) []embedscore {
+	if lambda >= 1 || len(candidates) <= 1 {
+		return candidates[:min(k, len(candidates))]
+	}
+
+	// The greatest similarity of each candidate to the picks so far, which
+	// starts at the least possible
+	maxSim := make([]float64, len(candidates))
+	for i := range maxSim {
+		maxSim[i] = -1
+	}
+	picked := make([]bool, len(candidates))
+
+	var picks []embedscore
+	for len(picks) < min(k, len(candidates)) {
+		best, bestScore := -1, math.Inf(-1)
+		for i, es := range candidates {
+			if picked[i] {
+				continue
+			}
+			score := lambda*float64(es.score) - (1-lambda)*maxSim[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		if best < 0 {
+			break // only NaN scores left
+		}
+		picked[best] = true
+		picks = append(picks, candidates[best])
+		for i, es := range candidates {
+			if picked[i] {
+				continue
+			}
+			if sim, err := computeCosineSimilarity(es.embed.Vector, candidates[best].embed.Vector); err == nil {
+				maxSim[i] = max(maxSim[i], float64(sim))
+			}
+		}
+	}
+	return picks
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestDiversify(t *testing.T) {
+	// A burst of three near identical photos, and two different ones
+	candidates := []embedscore{
+		{&henri.Embedding{ImageId: 1, Vector: []float32{1, 0, 0}}, 0.90},
+		{&henri.Embedding{ImageId: 2, Vector: []float32{1, 0.01, 0}}, 0.89},
+		{&henri.Embedding{ImageId: 3, Vector: []float32{1, 0, 0.01}}, 0.88},
+		{&henri.Embedding{ImageId: 4, Vector: []float32{0, 1, 0}}, 0.70},
+		{&henri.Embedding{ImageId: 5, Vector: []float32{0, 0, 1}}, 0.60},
+	}
+
+	tests := []struct {
+		k      int
+		lambda float64
+		want   []int
+	}{
+		{3, 1, []int{1, 2, 3}},
+		{3, 0.7, []int{1, 4, 5}},
+		{5, 0.7, []int{1, 4, 5, 2, 3}},
+		{3, 0.95, []int{1, 2, 3}},
+		{9, 1, []int{1, 2, 3, 4, 5}},
+	}
+	for _, tc := range tests {
+		var actual []int
+		for _, es := range diversify(candidates, tc.k, tc.lambda) {
+			actual = append(actual, es.embed.ImageId)
+		}
+		if !slices.Equal(tc.want, actual) {
+			t.Errorf("k %d lambda %v: expected %v, got %v", tc.k, tc.lambda, tc.want, actual)
+		}
+	}
+
+	for _, tc := range []struct {
+		s  string
+		ok bool
+	}{{"0", true}, {"0.7", true}, {"1", true}, {"1.5", false}, {"-0.1", false}, {"some", false}} {
+		if _, err := parseLambda(tc.s); (err == nil) != tc.ok {
+			t.Errorf("%q: expected valid %t, got %v", tc.s, tc.ok, err)
+		}
+	}
+}
+
+func TestSearchDiversity(t *testing.T) {
+	library := t.TempDir()
+	for i := range 3 {
+		writeJPEG(t, filepath.Join(library, fmt.Sprintf("%d.jpg", i)), 8+i, 8, color.RGBA{uint8(80 * i), 0, 0, 255})
+	}
+
+	h := indexTestLibrary(t, library)
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	tests := []struct {
+		mmr    string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"0.5", http.StatusOK},
+		{"0", http.StatusOK},
+		{"2", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(ts.URL + "/search?" + url.Values{"q": {"image"}, "mmr": {tc.mmr}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%q: expected status %d, got %d", tc.mmr, expected, actual)
+			continue
+		}
+		if tc.status == http.StatusOK {
+			if expected, actual := 3, strings.Count(string(body), `class="searchresult"`); expected != actual {
+				t.Errorf("%q: expected %d results, got %d", tc.mmr, expected, actual)
+			}
+		}
+	}
+}
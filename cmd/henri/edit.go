@@ -0,0 +1,97 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+var author = flag.String("author", "", "Name recorded with edited descriptions, default is $USER")
+
+// editCommand runs henri edit <id> <description>.
+func editCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing image id or description")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid image id %q", args[0])
+	}
+	if err := editDescription(ctx, id, args[1], editAuthor(), h.Embedder, h.DB); err != nil {
+		return err
+	}
+	fmt.Printf("Updated the description of image %d and embedded it with %s\n", id, h.Embedder.Model())
+	return nil
+}
+
+// historyCommand runs henri history <id>.
+func historyCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing image id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid image id %q", args[0])
+	}
+	return printHistory(ctx, os.Stdout, id, h.DB)
+}
+
+// editDescription replaces the description of the image id with one written
+// by author, and embeds it again with d. Embeddings by other models are
+// computed again by the next embeddings run.
+func editDescription(ctx context.Context, id int, description, author string, d describer.TextEmbedder, db *henri.DB) error {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return fmt.Errorf("empty description")
+	}
+	if err := db.EditImageDescription(ctx, id, description, author, time.Now()); err != nil {
+		return err
+	}
+
+	img, err := db.GetImage(ctx, id)
+	if err != nil {
+		return err
+	}
+	return calcEmbeddingFn(ctx, d, img, db)
+}
+
+// editAuthor is the author recorded with descriptions edited from the command
+// line.
+func editAuthor() string {
+	return cmp.Or(*author, os.Getenv("USER"), "unknown")
+}
+
+// printHistory writes the versions of the description of the image id to w,
+// newest first.
+func printHistory(ctx context.Context, w io.Writer, id int, db *henri.DB) error {
+	versions, err := db.DescriptionHistory(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		fmt.Fprintf(w, "Image %d has not been described\n", id)
+		return nil
+	}
+
+	for _, v := range versions {
+		kind := "model"
+		if v.Human {
+			kind = "human"
+		}
+		fmt.Fprintf(w, "%s  %s (%s)\n", v.CreatedAt.Local().Format(time.DateTime), v.Author, kind)
+		for _, para := range splitByNewline(v.Description) {
+			fmt.Fprintf(w, "    %s\n", para)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
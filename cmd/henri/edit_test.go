@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEditDescription(t *testing.T) {
+	h := indexTestLibrary(t, newTestLibrary(t, 2))
+	described, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	edited := "A return shipping label on a cardboard box."
+	tests := []struct {
+		path   string
+		form   url.Values
+		status int
+	}{
+		{"/images/1/description", url.Values{"description": {edited}, "author": {"sam"}}, http.StatusSeeOther},
+		{"/images/1/description", url.Values{"description": {"  "}}, http.StatusBadRequest},
+		{"/images/9/description", url.Values{"description": {edited}}, http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		resp, err := client.PostForm(ts.URL+tc.path, tc.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%s: expected status %d, got %d", tc.path, expected, actual)
+		}
+	}
+
+	img, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := edited, img.Description; expected != actual {
+		t.Errorf("Expected description %q, got %q", expected, actual)
+	}
+	if img.EditedAt.IsZero() {
+		t.Errorf("Expected the image to be marked as edited")
+	}
+	embeddings, err := h.DB.ImageEmbeddings(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 1, len(embeddings); expected != actual {
+		t.Fatalf("Expected %d embeddings, got %d", expected, actual)
+	}
+	if embeddings[0].ProcessedAt.Before(img.EditedAt) {
+		t.Errorf("Expected the image to be embedded again after the edit")
+	}
+
+	// Describing the image again leaves the edit alone
+	if err := describeImageFn(t.Context(), h.Describer, img, h.DB, nil); err != nil {
+		t.Fatal(err)
+	}
+	if img, err = h.DB.GetImage(t.Context(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := edited, img.Description; expected != actual {
+		t.Errorf("Expected description %q after describing, got %q", expected, actual)
+	}
+
+	history, err := h.DB.DescriptionHistory(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, len(history); expected != actual {
+		t.Fatalf("Expected %d versions, got %d", expected, actual)
+	}
+	versions := []struct {
+		description, author string
+		human               bool
+	}{
+		{edited, "sam", true},
+		{described.Description, "fake fake-64", false},
+	}
+	for i, v := range versions {
+		if expected, actual := v.description, history[i].Description; expected != actual {
+			t.Errorf("Version %d: expected description %q, got %q", i, expected, actual)
+		}
+		if expected, actual := v.author, history[i].Author; expected != actual {
+			t.Errorf("Version %d: expected author %q, got %q", i, expected, actual)
+		}
+		if expected, actual := v.human, history[i].Human; expected != actual {
+			t.Errorf("Version %d: expected human %t, got %t", i, expected, actual)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printHistory(t.Context(), &out, 1, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"sam (human)", "fake fake-64 (model)", edited} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in history %s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+var (
+	evalK = flag.Int("k", 10, "Number of results scored by eval")
+
+	evalEmbedders patternsFlag
+)
+
+func init() {
+	flag.Var(&evalEmbedders, "eval-embedder", "Backend URI of another embedding model to evaluate, may be repeated")
+}
+
+// evalCommand runs henri eval <eval_file>.
+func evalCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing eval file")
+	}
+
+	params, err := filterFlags()
+	if err != nil {
+		return err
+	}
+	filter, err := imageFilter(ctx, h.DB, params)
+	if err != nil {
+		return err
+	}
+
+	embedders := []describer.TextEmbedder{h.Embedder}
+	for _, uri := range evalEmbedders {
+		e, err := h.OpenEmbedder(uri)
+		if err != nil {
+			return err
+		}
+		embedders = append(embedders, e)
+	}
+
+	results, err := runEval(ctx, args[0], embedders, filter, *evalK, h.DB)
+	if err != nil {
+		return err
+	}
+	printEval(os.Stdout, results, *evalK)
+	return nil
+}
+
+// evalQuery is a golden query, a line of an eval file. The relevant images are
+// given by id, by patterns of their paths relative to the library root, or
+// both.
+type evalQuery struct {
+	Query    string   `json:"query"`
+	Relevant []int    `json:"relevant,omitempty"`
+	Paths    []string `json:"paths,omitempty"` // gitignore style patterns
+}
+
+// evalMetrics are the retrieval metrics of a search, averaged over the golden
+// queries.
+type evalMetrics struct {
+	Recall float64       // fraction of the relevant images in the top k
+	MRR    float64       // reciprocal rank of the first relevant image
+	NDCG   float64       // normalized discounted cumulative gain of the top k
+	Time   time.Duration // per query, excluding embedding the query
+}
+
+// evalResult is the evaluation of an embedding model.
+type evalResult struct {
+	Model    string
+	Exact    evalMetrics
+	ANN      evalMetrics
+	Overlap  float64 // fraction of the exact top k also found by ANN
+	Queries  int     // queries with relevant images, the others are skipped
+	Skipped  int
+	Embedded int // embeddings searched
+}
+
+// readEvalQueries reads the golden queries in the JSONL file at path.
+func readEvalQueries(path string) ([]evalQuery, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var queries []evalQuery
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var q evalQuery
+		if err := dec.Decode(&q); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("query %d - %w", len(queries)+1, err)
+		}
+		if strings.TrimSpace(q.Query) == "" {
+			return nil, fmt.Errorf("query %d has no query text", len(queries)+1)
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+// relevantImages returns the ids of the images relevant to each query, by
+// query. Paths are matched relative to the image's library root, or the whole
+// path for images outside one.
+func relevantImages(ctx context.Context, queries []evalQuery, db *henri.DB) ([]map[int]bool, error) {
+	images, err := db.Images(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	relevant := make([]map[int]bool, len(queries))
+	for i, q := range queries {
+		relevant[i] = map[int]bool{}
+		for _, id := range q.Relevant {
+			relevant[i][id] = true
+		}
+		if len(q.Paths) == 0 {
+			continue
+		}
+
+		patterns, err := parsePatterns(q.Paths)
+		if err != nil {
+			return nil, fmt.Errorf("query %q - %w", q.Query, err)
+		}
+		for _, img := range images {
+			rel := img.RelPath
+			if rel == "" {
+				rel = strings.TrimPrefix(filepath.ToSlash(img.Path), "/")
+			}
+			if matchPatterns(patterns, rel, false) {
+				relevant[i][img.Id] = true
+			}
+		}
+	}
+	return relevant, nil
+}
+
+// rankingMetrics returns the recall, reciprocal rank and nDCG of the ranked
+// image ids against the relevant ones, counting the top k.
+func rankingMetrics(ranked []int, relevant map[int]bool, k int) (recall, rr, ndcg float64) {
+	if len(relevant) == 0 {
+		return 0, 0, 0
+	}
+
+	var found int
+	var dcg, idcg float64
+	for i, id := range ranked[:min(k, len(ranked))] {
+		if !relevant[id] {
+			continue
+		}
+		found++
+		if rr == 0 {
+			rr = 1 / float64(i+1)
+		}
+		dcg += 1 / math.Log2(float64(i+2))
+	}
+	for i := range min(k, len(relevant)) {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	return float64(found) / float64(len(relevant)), rr, dcg / idcg
+}
+
+// rankedImages returns the image ids of the embeddings in topk, best first.
+func rankedImages(topk *TopKTracker) []int {
+	var ids []int
+	for _, es := range topk.GetTopK() {
+		ids = append(ids, es.embed.ImageId)
+	}
+	return ids
+}
+
+// runEval runs the golden queries in the file at path through the search with
+// each embedder, scoring the top k exact and ANN results. Only images matched
+// by filter are searched.
+func runEval(ctx context.Context, path string, embedders []describer.TextEmbedder, filter henri.ImageFilter, k int, db *henri.DB) ([]*evalResult, error) {
+	queries, err := readEvalQueries(path)
+	if err != nil {
+		return nil, err
+	}
+	relevant, err := relevantImages(ctx, queries, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*evalResult
+	for _, d := range embedders {
+		res := &evalResult{Model: d.Model()}
+
+		// The ANN index is built from every embedding of the model
+		var embeds []*henri.Embedding
+		batchCh, errCh := db.EmbeddingsForModel(ctx, d.Model(), 0, filter)
+		for batch := range batchCh {
+			embeds = append(embeds, batch.Embeds...)
+		}
+		if err := <-errCh; err != nil {
+			return nil, err
+		}
+		res.Embedded = len(embeds)
+		ix := newANNIndex(embeds)
+
+		var exactTime, annTime time.Duration
+		for i, q := range queries {
+			if len(relevant[i]) == 0 {
+				res.Skipped++
+				continue
+			}
+			res.Queries++
+
+			vec, err := d.Embeddings(ctx, q.Query)
+			if err != nil {
+				return nil, fmt.Errorf("query %q - %w", q.Query, err)
+			}
+
+			start := time.Now()
+			scorer, err := newBatchScorer(ctx, db, d.Model(), filter)
+			if err != nil {
+				return nil, err
+			}
+			topk, err := scorer.topK(vec, k)
+			if err != nil {
+				return nil, err
+			}
+			exactTime += time.Since(start)
+			exact := rankedImages(topk)
+
+			start = time.Now()
+			if topk, err = ix.topK(vec, k); err != nil {
+				return nil, err
+			}
+			annTime += time.Since(start)
+			approx := rankedImages(topk)
+
+			for _, m := range []struct {
+				ranked  []int
+				metrics *evalMetrics
+			}{{exact, &res.Exact}, {approx, &res.ANN}} {
+				recall, rr, ndcg := rankingMetrics(m.ranked, relevant[i], k)
+				m.metrics.Recall += recall
+				m.metrics.MRR += rr
+				m.metrics.NDCG += ndcg
+			}
+
+			exactSet := map[int]bool{}
+			for _, id := range exact {
+				exactSet[id] = true
+			}
+			overlap, _, _ := rankingMetrics(approx, exactSet, k)
+			res.Overlap += overlap
+		}
+
+		if n := res.Queries; n > 0 {
+			for _, m := range []*evalMetrics{&res.Exact, &res.ANN} {
+				m.Recall /= float64(n)
+				m.MRR /= float64(n)
+				m.NDCG /= float64(n)
+			}
+			res.Exact.Time = exactTime / time.Duration(n)
+			res.ANN.Time = annTime / time.Duration(n)
+			res.Overlap /= float64(n)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// printEval writes the results as a table to w.
+func printEval(w io.Writer, results []*evalResult, k int) {
+	if len(results) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Evaluated %d queries at k=%d", results[0].Queries, k)
+	if n := results[0].Skipped; n > 0 {
+		fmt.Fprintf(w, ", skipped %d with no relevant images", n)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "%-24s %-6s %9s %6s %8s %10s\n", "Model", "Search", fmt.Sprintf("Recall@%d", k), "MRR", fmt.Sprintf("nDCG@%d", k), "Time/query")
+	for _, res := range results {
+		for _, row := range []struct {
+			search  string
+			metrics evalMetrics
+		}{{"exact", res.Exact}, {"ann", res.ANN}} {
+			m := row.metrics
+			fmt.Fprintf(w, "%-24s %-6s %9.3f %6.3f %8.3f %10s", res.Model, row.search, m.Recall, m.MRR, m.NDCG, m.Time.Round(time.Microsecond))
+			if row.search == "ann" {
+				fmt.Fprintf(w, "  %.3f of exact, %d embeddings", res.Overlap, res.Embedded)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+func TestRankingMetrics(t *testing.T) {
+	tests := []struct {
+		ranked   []int
+		relevant []int
+		k        int
+		recall   float64
+		rr       float64
+		ndcg     float64
+	}{
+		{[]int{1, 2, 3}, []int{1}, 3, 1, 1, 1},
+		{[]int{2, 1, 3}, []int{1}, 3, 1, 0.5, 1 / math.Log2(3)},
+		{[]int{2, 3, 1}, []int{1}, 2, 0, 0, 0},
+		{[]int{1, 3, 2}, []int{1, 2}, 3, 1, 1, (1 + 0.5) / (1 + 1/math.Log2(3))},
+		{[]int{3, 4}, []int{1, 2}, 10, 0, 0, 0},
+		{[]int{1}, nil, 10, 0, 0, 0},
+	}
+	for _, tc := range tests {
+		relevant := map[int]bool{}
+		for _, id := range tc.relevant {
+			relevant[id] = true
+		}
+		recall, rr, ndcg := rankingMetrics(tc.ranked, relevant, tc.k)
+		if recall != tc.recall || rr != tc.rr || math.Abs(ndcg-tc.ndcg) > 1e-9 {
+			t.Errorf("%v of %v at %d: expected %.3f %.3f %.3f, got %.3f %.3f %.3f", tc.ranked, tc.relevant, tc.k, tc.recall, tc.rr, tc.ndcg, recall, rr, ndcg)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	h := indexTestLibrary(t, newTestLibrary(t, 3))
+	img, err := h.DB.GetImage(t.Context(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A query with the description of an image finds it first
+	path := filepath.Join(t.TempDir(), "eval.jsonl")
+	lines := []string{
+		fmt.Sprintf(`{"query": %q, "paths": ["b.jpg"]}`, img.Description),
+		fmt.Sprintf(`{"query": %q, "relevant": [2]}`, img.Description),
+		`{"query": "nothing", "paths": ["missing/*.jpg"]}`,
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := runEval(t.Context(), path, []describer.TextEmbedder{h.Embedder}, henri.ImageFilter{}, 2, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 1, len(results); expected != actual {
+		t.Fatalf("Expected %d results, got %d", expected, actual)
+	}
+	res := results[0]
+	if expected, actual := 2, res.Queries; expected != actual {
+		t.Errorf("Expected %d queries, got %d", expected, actual)
+	}
+	if expected, actual := 1, res.Skipped; expected != actual {
+		t.Errorf("Expected %d skipped queries, got %d", expected, actual)
+	}
+	if expected, actual := 3, res.Embedded; expected != actual {
+		t.Errorf("Expected %d embeddings, got %d", expected, actual)
+	}
+	for _, m := range []evalMetrics{res.Exact, res.ANN} {
+		if m.Recall != 1 || m.MRR != 1 || m.NDCG != 1 {
+			t.Errorf("Expected perfect metrics, got %+v", m)
+		}
+	}
+
+	var out bytes.Buffer
+	printEval(&out, results, 2)
+	if !strings.Contains(out.String(), "skipped 1") || !strings.Contains(out.String(), "fake-64") {
+		t.Errorf("Expected a report of the skipped query and the model, got %q", out.String())
+	}
+
+	if err := os.WriteFile(path, []byte(`{"relevant": [1]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runEval(t.Context(), path, []describer.TextEmbedder{h.Embedder}, henri.ImageFilter{}, 2, h.DB); err == nil {
+		t.Errorf("Expected an error for a query without text")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// exifField is an EXIF value formatted for display.
+type exifField struct {
+	Name  string
+	Value string
+}
+
+// EXIF and GPS tags read for the image page.
+const (
+	exifMake             = 0x010f
+	exifModel            = 0x0110
+	exifIFDPointer       = 0x8769
+	exifGPSPointer       = 0x8825
+	exifDateTimeOriginal = 0x9003
+	exifExposureTime     = 0x829a
+	exifFNumber          = 0x829d
+	exifISO              = 0x8827
+	exifFocalLength      = 0x920a
+	exifLensModel        = 0xa434
+
+	gpsLatitudeRef  = 1
+	gpsLatitude     = 2
+	gpsLongitudeRef = 3
+	gpsLongitude    = 4
+)
+
+// tiffTypeSizes is the size in bytes of each TIFF field type, by type.
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// tiffField is an IFD entry.
+type tiffField struct {
+	typ   uint16
+	count int
+	data  []byte
+}
+
+// tiffReader reads the IFDs of a TIFF structure.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifd returns the fields of the IFD at offset, keyed by tag. Fields with
+// values outside data are left out.
+func (tr *tiffReader) ifd(offset uint32) (map[uint16]tiffField, error) {
+	data := tr.data
+	if int64(offset)+2 > int64(len(data)) {
+		return nil, errors.New("IFD offset out of range")
+	}
+	n := int(tr.order.Uint16(data[offset:]))
+	entries := data[offset+2:]
+	if len(entries) < n*12 {
+		return nil, errors.New("IFD truncated")
+	}
+
+	fields := map[uint16]tiffField{}
+	for i := range n {
+		e := entries[i*12 : i*12+12]
+		f := tiffField{typ: tr.order.Uint16(e[2:]), count: int(tr.order.Uint32(e[4:]))}
+		size, ok := tiffTypeSizes[f.typ]
+		if !ok || f.count < 0 || f.count > len(data) {
+			continue
+		}
+		if size*f.count <= 4 {
+			f.data = e[8 : 8+size*f.count]
+		} else {
+			off := int64(tr.order.Uint32(e[8:]))
+			if off+int64(size*f.count) > int64(len(data)) {
+				continue
+			}
+			f.data = data[off : off+int64(size*f.count)]
+		}
+		fields[tr.order.Uint16(e)] = f
+	}
+	return fields, nil
+}
+
+// str returns f as text.
+func (f tiffField) str() string {
+	if f.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(f.data), "\x00"))
+}
+
+// uint returns the first value of f as an integer.
+func (f tiffField) uint(order binary.ByteOrder) (uint32, bool) {
+	switch {
+	case f.typ == 3 && len(f.data) >= 2:
+		return uint32(order.Uint16(f.data)), true
+	case f.typ == 4 && len(f.data) >= 4:
+		return order.Uint32(f.data), true
+	}
+	return 0, false
+}
+
+// rationals returns the values of f, which is a RATIONAL or SRATIONAL.
+func (f tiffField) rationals(order binary.ByteOrder) []float64 {
+	if f.typ != 5 && f.typ != 10 {
+		return nil
+	}
+	var vs []float64
+	for i := 0; i+8 <= len(f.data); i += 8 {
+		num, den := order.Uint32(f.data[i:]), order.Uint32(f.data[i+4:])
+		if den == 0 {
+			return nil
+		}
+		if f.typ == 10 {
+			vs = append(vs, float64(int32(num))/float64(int32(den)))
+		} else {
+			vs = append(vs, float64(num)/float64(den))
+		}
+	}
+	return vs
+}
+
+// parseExif returns the camera, exposure and location fields of the EXIF TIFF
+// structure data.
+func parseExif(data []byte) ([]exifField, error) {
+	if len(data) < 8 {
+		return nil, errors.New("EXIF truncated")
+	}
+	tr := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		tr.order = binary.LittleEndian
+	case "MM":
+		tr.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid EXIF byte order")
+	}
+
+	ifd0, err := tr.ifd(tr.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	sub := func(tag uint16) map[uint16]tiffField {
+		if off, ok := ifd0[tag].uint(tr.order); ok {
+			if fields, err := tr.ifd(off); err == nil {
+				return fields
+			}
+		}
+		return nil
+	}
+	exif, gps := sub(exifIFDPointer), sub(exifGPSPointer)
+
+	var fields []exifField
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, exifField{name, value})
+		}
+	}
+
+	add("Camera", strings.TrimSpace(ifd0[exifMake].str()+" "+ifd0[exifModel].str()))
+	add("Lens", exif[exifLensModel].str())
+	add("Taken", exif[exifDateTimeOriginal].str())
+	if v := exif[exifExposureTime].rationals(tr.order); len(v) > 0 && v[0] > 0 {
+		if v[0] < 1 {
+			add("Exposure", fmt.Sprintf("1/%.0f s", 1/v[0]))
+		} else {
+			add("Exposure", fmt.Sprintf("%g s", v[0]))
+		}
+	}
+	if v := exif[exifFNumber].rationals(tr.order); len(v) > 0 {
+		add("Aperture", fmt.Sprintf("f/%.1f", v[0]))
+	}
+	if v, ok := exif[exifISO].uint(tr.order); ok {
+		add("ISO", fmt.Sprint(v))
+	}
+	if v := exif[exifFocalLength].rationals(tr.order); len(v) > 0 {
+		add("Focal length", fmt.Sprintf("%g mm", math.Round(v[0]*10)/10))
+	}
+
+	lat, latOk := gpsCoordinate(gps[gpsLatitude].rationals(tr.order), gps[gpsLatitudeRef].str(), "S")
+	long, longOk := gpsCoordinate(gps[gpsLongitude].rationals(tr.order), gps[gpsLongitudeRef].str(), "W")
+	if latOk && longOk {
+		add("Location", fmt.Sprintf("%.5f, %.5f", lat, long))
+	}
+
+	return fields, nil
+}
+
+// gpsCoordinate returns the degrees, minutes and seconds in dms as decimal
+// degrees, negated if ref is neg.
+func gpsCoordinate(dms []float64, ref, neg string) (float64, bool) {
+	if len(dms) != 3 {
+		return 0, false
+	}
+	deg := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref == neg {
+		deg = -deg
+	}
+	return deg, true
+}
+
+// readExif returns the EXIF fields of the JPEG at path, none if it has no EXIF.
+func readExif(path string) ([]exifField, error) {
+	jm, err := readJPEGMetadata(path)
+	if err != nil || jm.exif == nil {
+		return nil, err
+	}
+	return parseExif(jm.exif)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// buildExif returns a little endian TIFF structure with the fields in ifd0
+// and an EXIF IFD with the fields in exif.
+func buildExif(ifd0, exif []tiffEntry) []byte {
+	le := binary.LittleEndian
+	size := func(n int) int { return 2 + 12*n + 4 }
+	exifOff := 8 + size(len(ifd0)+1)
+	dataOff := exifOff + size(len(exif))
+	ifd0 = append(ifd0, tiffEntry{0x8769, 4, 1, le.AppendUint32(nil, uint32(exifOff))})
+
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	var data []byte
+	for _, entries := range [][]tiffEntry{ifd0, exif} {
+		out = le.AppendUint16(out, uint16(len(entries)))
+		for _, e := range entries {
+			out = le.AppendUint16(out, e.tag)
+			out = le.AppendUint16(out, e.typ)
+			out = le.AppendUint32(out, e.count)
+			if len(e.value) <= 4 {
+				out = append(out, e.value...)
+				out = append(out, make([]byte, 4-len(e.value))...)
+			} else {
+				out = le.AppendUint32(out, uint32(dataOff+len(data)))
+				data = append(data, e.value...)
+			}
+		}
+		out = le.AppendUint32(out, 0)
+	}
+	return append(out, data...)
+}
+
+func TestImagePage(t *testing.T) {
+	library := t.TempDir()
+	writeJPEG(t, filepath.Join(library, "a.jpg"), 16, 8, color.RGBA{255, 0, 0, 255})
+	writeJPEG(t, filepath.Join(library, "b.jpg"), 8, 16, color.RGBA{0, 255, 0, 255})
+	writeJPEG(t, filepath.Join(library, "c.jpg"), 8, 8, color.RGBA{0, 0, 255, 255})
+
+	le := binary.LittleEndian
+	rational := func(num, den uint32) []byte { return le.AppendUint32(le.AppendUint32(nil, num), den) }
+	exif := buildExif(
+		[]tiffEntry{
+			{exifMake, 2, 6, []byte("Canon\x00")},
+			{exifModel, 2, 7, []byte("EOS R5\x00")},
+		},
+		[]tiffEntry{
+			{exifExposureTime, 5, 1, rational(1, 250)},
+			{exifFNumber, 5, 1, rational(28, 10)},
+			{exifISO, 3, 1, le.AppendUint16(nil, 200)},
+			{exifFocalLength, 5, 1, rational(50, 1)},
+		})
+	addJPEGSegments(t, filepath.Join(library, "a.jpg"), []byte{0xe1}, [][]byte{[]byte(jpegExifPrefix + string(exif))})
+
+	h := newTestHenri(t, AppModePipeline)
+	if err := runPipeline(t.Context(), library, h); err != nil {
+		t.Fatal(err)
+	}
+	img, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		want   []string
+	}{
+		{"/images/1", http.StatusOK, []string{
+			`src="/image/1"`,
+			splitByNewline(img.Description)[0],
+			filepath.Join(library, "a.jpg"),
+			"16 x 8",
+			"fake fake-64",
+			"Canon EOS R5", "1/250 s", "f/2.8", "200", "50 mm",
+			"<td class=\"text-right pr-4\">fake-64</td>",
+			`href="/images/2"`, `href="/images/3"`,
+		}},
+		{"/images/4", http.StatusNotFound, nil},
+		{"/images/a", http.StatusNotFound, nil},
+		{"/search?q=image", http.StatusOK, []string{`href="/images/1"`, `href="/images/2"`, `href="/images/3"`}},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(ts.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%s: expected status %d, got %d", tc.path, expected, actual)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("%s: expected %q in %s", tc.path, want, body)
+			}
+		}
+		if strings.Contains(tc.path, "/images/1") && strings.Contains(string(body), `href="/images/1"`) {
+			t.Errorf("%s: expected the image not to be similar to itself", tc.path)
+		}
+	}
+}
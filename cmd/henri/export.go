@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+// exportRecord is a line of an export file. Records are matched to images on
+// import by the hash of the image file, so the library can be moved or
+// reorganised between export and import.
+type exportRecord struct {
+	Hash        string            `json:"hash"`
+	Path        string            `json:"path"` // relative to the library root
+	Description string            `json:"description"`
+	Model       string            `json:"model"` // henri.EditedModel if a person wrote it
+	Describer   string            `json:"describer"`
+	Author      string            `json:"author,omitempty"` // of an edited description
+	ProcessedAt time.Time         `json:"processed_at"`
+	DescribeMs  int64             `json:"describe_ms,omitempty"`
+	Embeddings  []exportEmbedding `json:"embeddings,omitempty"`
+}
+
+type exportEmbedding struct {
+	Model       string    `json:"model"`
+	ProcessedAt time.Time `json:"processed_at"`
+	Vector      []float32 `json:"vector"`
+}
+
+// hashFile returns the hex SHA-256 hash of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hashImages computes and stores the content hash of images scanned before
+// hashes were recorded. Missing files are skipped.
+func hashImages(ctx context.Context, db *henri.DB) error {
+	images, err := db.ImagesWithoutHash(ctx)
+	if err != nil {
+		return err
+	}
+	if len(images) > 0 {
+		// Not stdout, it may be the export file
+		fmt.Fprintf(os.Stderr, "Hashing %d images\n", len(images))
+	}
+
+	for _, img := range images {
+		if ctx.Err() != nil || lameduck {
+			return errors.New("interrupted while hashing images")
+		}
+
+		hash, err := hashFile(img.Path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if err := db.SetImageHash(ctx, img.Id, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commonDir returns the deepest directory containing all of paths.
+func commonDir(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+
+	dir := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for dir != filepath.Dir(dir) && !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// createExportFile creates the file at path, or returns stdout if path is "-".
+// Files ending in .gz are compressed. Close the returned writer when done.
+func createExportFile(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") {
+		return &gzipWriter{gzip.NewWriter(f), f}, nil
+	}
+	return f, nil
+}
+
+// openExportFile opens the file at path, or returns stdin if path is "-".
+// Files ending in .gz are decompressed.
+func openExportFile(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &gzipReader{zr, f}, nil
+	}
+	return f, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// gzipWriter and gzipReader close the gzip stream and then the file
+// underneath it.
+type gzipWriter struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g *gzipWriter) Close() error { return errors.Join(g.Writer.Close(), g.f.Close()) }
+
+type gzipReader struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipReader) Close() error { return errors.Join(g.Reader.Close(), g.f.Close()) }
+
+// exportCommand runs henri export <file>.
+func exportCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing export file")
+	}
+	return runExport(ctx, args[0], h.DB)
+}
+
+// runExport writes a record for every described image to the file at path.
+// Only an image's current description and its embeddings are exported, not
+// its descriptions in other sets or their history. An edited description is
+// exported with its author and the time it was edited.
+func runExport(ctx context.Context, path string, db *henri.DB) error {
+	if err := hashImages(ctx, db); err != nil {
+		return err
+	}
+
+	images, err := db.DescribedImages(ctx)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, len(images))
+	for i, img := range images {
+		paths[i] = img.Path
+	}
+	root := commonDir(paths)
+
+	out, err := createExportFile(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+
+	var n, skipped int
+	for _, img := range images {
+		if img.Hash == "" {
+			// The file is missing, without a hash the record could
+			// never be imported
+			skipped++
+			continue
+		}
+
+		rec := exportRecord{
+			Hash:        img.Hash,
+			Path:        img.Path,
+			Description: img.Description,
+			Model:       img.Model,
+			Describer:   img.Describer,
+			ProcessedAt: img.ProcessedAt.Time,
+			DescribeMs:  img.DescribeTime.Milliseconds(),
+		}
+		history, err := db.DescriptionHistory(ctx, img.Id)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if len(history) > 0 && history[0].Human {
+			rec.Model, rec.Describer, rec.Author = henri.EditedModel, "", history[0].Author
+			rec.ProcessedAt, rec.DescribeMs = history[0].CreatedAt, 0
+		}
+		if img.RelPath != "" {
+			rec.Path = img.RelPath
+		} else if rel, err := filepath.Rel(root, img.Path); err == nil {
+			rec.Path = filepath.ToSlash(rel)
+		}
+
+		embeds, err := db.ImageEmbeddings(ctx, img.Id)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		for _, emb := range embeds {
+			rec.Embeddings = append(rec.Embeddings, exportEmbedding{
+				Model:       emb.Model,
+				ProcessedAt: emb.ProcessedAt,
+				Vector:      emb.Vector,
+			})
+		}
+
+		if err := enc.Encode(rec); err != nil {
+			out.Close()
+			return err
+		}
+		n++
+	}
+
+	if err := errors.Join(bw.Flush(), out.Close()); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d images, skipped %d missing files\n", n, skipped)
+	return nil
+}
+
+// importCommand runs henri import <file>.
+func importCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing import file")
+	}
+	res, err := runImport(ctx, args[0], h.DB)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Read %d records, %d matched no image. Imported %d descriptions and %d embeddings\n",
+		res.Records, res.Unmatched, res.Descriptions, res.Embeddings)
+	return nil
+}
+
+// importResult counts what runImport did.
+type importResult struct {
+	Records      int // records read
+	Unmatched    int // records that matched no image
+	Descriptions int // descriptions imported
+	Embeddings   int // embeddings imported
+}
+
+// runImport reads the export file at path, adding descriptions and embeddings
+// to images with the same content hash. Images that already have a different
+// description are left untouched. Images must have been scanned first.
+func runImport(ctx context.Context, path string, db *henri.DB) (*importResult, error) {
+	if err := hashImages(ctx, db); err != nil {
+		return nil, err
+	}
+	byHash, err := db.ImageIdsByHash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := openExportFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	res := &importResult{}
+	dec := json.NewDecoder(bufio.NewReader(in))
+	for {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return res, fmt.Errorf("record %d - %w", res.Records+1, err)
+		}
+		res.Records++
+
+		ids, ok := byHash[rec.Hash]
+		if !ok || rec.Description == "" {
+			res.Unmatched++
+			continue
+		}
+		for _, id := range ids {
+			if err := importRecord(ctx, db, id, &rec, res); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func importRecord(ctx context.Context, db *henri.DB, id int, rec *exportRecord, res *importResult) error {
+	img, err := db.GetImage(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch img.Description {
+	case "":
+		if rec.Model == henri.EditedModel {
+			// An edited description stays protected from being described
+			// again
+			if err := db.EditImageDescription(ctx, id, rec.Description, rec.Author, rec.ProcessedAt); err != nil {
+				return err
+			}
+			if img, err = db.GetImage(ctx, id); err != nil {
+				return err
+			}
+		} else {
+			img.Description = rec.Description
+			img.ProcessedAt.Time, img.ProcessedAt.Valid = rec.ProcessedAt, true
+			img.DescribeTime = time.Duration(rec.DescribeMs) * time.Millisecond
+			if err := db.UpdateImage(ctx, img, rec.Model, rec.Describer); err != nil {
+				return err
+			}
+		}
+		res.Descriptions++
+	case rec.Description:
+	default:
+		// The embeddings are of a different description
+		return nil
+	}
+
+	for _, emb := range rec.Embeddings {
+		if _, err := db.CreateEmbedding(ctx, emb.Vector, emb.Model, img, emb.ProcessedAt); err != nil {
+			return err
+		}
+		res.Embeddings++
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestExportImport(t *testing.T) {
+	library := t.TempDir()
+	writeJPEG(t, filepath.Join(library, "a.jpg"), 16, 8, color.RGBA{255, 0, 0, 255})
+	if err := os.Mkdir(filepath.Join(library, "2024"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeJPEG(t, filepath.Join(library, "2024", "b.jpg"), 8, 16, color.RGBA{0, 255, 0, 255})
+
+	h := indexTestLibrary(t, library)
+	const edited = "A red rectangle, edited"
+	if err := editDescription(t.Context(), 1, edited, "ana", h.Embedder, h.DB); err != nil {
+		t.Fatal(err)
+	}
+
+	exportPath := filepath.Join(t.TempDir(), "export.jsonl.gz")
+	if err := runExport(t.Context(), exportPath, h.DB); err != nil {
+		t.Fatalf("Unexpected export error %s", err)
+	}
+
+	in, err := openExportFile(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	dec := json.NewDecoder(in)
+	for dec.More() {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, rec.Path)
+		if expected, actual := 1, len(rec.Embeddings); expected != actual {
+			t.Errorf("Expected %d embeddings for %s, got %d", expected, rec.Path, actual)
+		}
+	}
+	in.Close()
+	if expected, actual := "2024/b.jpg a.jpg", strings.Join(paths, " "); expected != actual {
+		t.Errorf("Expected relative paths %q, got %q", expected, actual)
+	}
+
+	// The library moves and is reorganised on another machine, which also
+	// has a new photo
+	moved := t.TempDir()
+	writeJPEG(t, filepath.Join(moved, "red.jpg"), 16, 8, color.RGBA{255, 0, 0, 255})
+	writeJPEG(t, filepath.Join(moved, "green.jpg"), 8, 16, color.RGBA{0, 255, 0, 255})
+	writeJPEG(t, filepath.Join(moved, "blue.jpg"), 8, 8, color.RGBA{0, 0, 255, 255})
+
+	h2 := newTestHenri(t, AppModeImport)
+	if _, err := findAndInsertImageFiles(t.Context(), moved, h2.DB); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := runImport(t.Context(), exportPath, h2.DB)
+	if err != nil {
+		t.Fatalf("Unexpected import error %s", err)
+	}
+	if expected, actual := (importResult{Records: 2, Descriptions: 2, Embeddings: 2}), *res; expected != actual {
+		t.Errorf("Expected import result %+v, got %+v", expected, actual)
+	}
+
+	images, err := h2.DB.ImagesToDescribe(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || filepath.Base(images[0].Path) != "blue.jpg" {
+		t.Errorf("Expected only blue.jpg to need describing, got %d images", len(images))
+	}
+
+	// The edited description is still an edit by its author
+	described, err := h2.DB.DescribedImages(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(described, func(img *henri.Image) bool { return img.Description == edited })
+	if i < 0 {
+		t.Fatalf("Expected an image with the edited description")
+	}
+	img, err := h2.DB.GetImage(t.Context(), described[i].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.EditedAt.IsZero() {
+		t.Error("Expected the imported description to be edited")
+	}
+	history, err := h2.DB.DescriptionHistory(t.Context(), img.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || !history[0].Human || history[0].Author != "ana" {
+		t.Errorf("Expected one version by ana, got %+v", history)
+	}
+	if embeds, err := h2.DB.ImageEmbeddings(t.Context(), img.Id); err != nil || len(embeds) != 1 {
+		t.Errorf("Expected the edited description's embedding, got %d, %v", len(embeds), err)
+	}
+
+	// Importing again changes nothing but refreshes the embeddings
+	res, err = runImport(t.Context(), exportPath, h2.DB)
+	if err != nil {
+		t.Fatalf("Unexpected import error %s", err)
+	}
+	if expected, actual := (importResult{Records: 2, Embeddings: 2}), *res; expected != actual {
+		t.Errorf("Expected import result %+v, got %+v", expected, actual)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/chriskillpack/henri"
+)
+
+// Weights of the query, and of the mean of the relevant and irrelevant
+// results, in a query adjusted by feedback.
+const (
+	rocchioAlpha = 1.0
+	rocchioBeta  = 0.75
+	rocchioGamma = 0.15
+)
+
+// normalQuery is the form of query that feedback and relevance scores are
+// recorded against, so searches that differ only in case and spacing share
+// them.
+func normalQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// adjustQuery moves the query vector vec towards the embeddings of the images
+// marked as relevant to query searched with model, and away from those marked
+// irrelevant. Without feedback it returns vec.
+func adjustQuery(ctx context.Context, query, model string, vec []float32, db *henri.DB) ([]float32, error) {
+	feedback, err := db.QueryFeedback(ctx, normalQuery(query), model)
+	if err != nil || len(feedback) == 0 {
+		return vec, err
+	}
+
+	var relevant, irrelevant [][]float32
+	for _, fb := range feedback {
+		embeds, err := db.ImageEmbeddings(ctx, fb.ImageId)
+		if err != nil {
+			return nil, err
+		}
+		for _, emb := range embeds {
+			if emb.Model != model || len(emb.Vector) != len(vec) {
+				continue
+			}
+			if fb.Relevant {
+				relevant = append(relevant, unitVector(emb.Vector))
+			} else {
+				irrelevant = append(irrelevant, unitVector(emb.Vector))
+			}
+		}
+	}
+
+	adjusted := unitVector(vec)
+	for i := range adjusted {
+		adjusted[i] *= rocchioAlpha
+	}
+	for _, group := range []struct {
+		vecs   [][]float32
+		weight float64
+	}{{relevant, rocchioBeta}, {irrelevant, -rocchioGamma}} {
+		for _, v := range group.vecs {
+			for i := range adjusted {
+				adjusted[i] += float32(group.weight / float64(len(group.vecs)) * float64(v[i]))
+			}
+		}
+	}
+	return adjusted, nil
+}
+
+// feedbackCommand runs henri feedback <export_file>.
+func feedbackCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing export file")
+	}
+	return exportFeedback(ctx, args[0], h.DB)
+}
+
+// exportFeedback writes the feedback to the file at path as eval queries, one
+// for each query with an image marked relevant. The latest judgement of an
+// image for a query counts, whatever model it was searched with.
+func exportFeedback(ctx context.Context, path string, db *henri.DB) error {
+	feedback, err := db.AllFeedback(ctx)
+	if err != nil {
+		return err
+	}
+
+	var queries []string
+	relevant := map[string]map[int]bool{}
+	for _, fb := range feedback {
+		if relevant[fb.Query] == nil {
+			queries = append(queries, fb.Query)
+			relevant[fb.Query] = map[int]bool{}
+		}
+		relevant[fb.Query][fb.ImageId] = fb.Relevant
+	}
+
+	out, err := createExportFile(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+
+	var n int
+	for _, query := range queries {
+		q := evalQuery{Query: query}
+		for _, fb := range feedback {
+			if fb.Query == query && relevant[query][fb.ImageId] && !slices.Contains(q.Relevant, fb.ImageId) {
+				q.Relevant = append(q.Relevant, fb.ImageId)
+			}
+		}
+		if len(q.Relevant) == 0 {
+			continue
+		}
+		if err := enc.Encode(q); err != nil {
+			out.Close()
+			return err
+		}
+		n++
+	}
+
+	if err := errors.Join(bw.Flush(), out.Close()); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d queries from %d judgements\n", n, len(feedback))
+	return nil
+}
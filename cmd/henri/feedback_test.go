@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFeedback(t *testing.T) {
+	h := indexTestLibrary(t, newTestLibrary(t, 3))
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	query := "A photo"
+	tests := []struct {
+		form   url.Values
+		status int
+	}{
+		{url.Values{"q": {query}, "image": {"3"}, "vote": {"up"}}, http.StatusNoContent},
+		{url.Values{"q": {"a  PHOTO "}, "image": {"1"}, "vote": {"down"}}, http.StatusNoContent},
+		{url.Values{"q": {query}, "image": {"2"}, "vote": {"down"}}, http.StatusNoContent},
+		{url.Values{"q": {query}, "image": {"2"}, "vote": {""}}, http.StatusNoContent},
+		{url.Values{"q": {query}, "image": {"2"}, "vote": {"sideways"}}, http.StatusBadRequest},
+		{url.Values{"q": {" "}, "image": {"2"}, "vote": {"up"}}, http.StatusBadRequest},
+		{url.Values{"q": {query}, "image": {"9"}, "vote": {"up"}}, http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		resp, err := http.PostForm(ts.URL+"/feedback", tc.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%v: expected status %d, got %d", tc.form, expected, actual)
+		}
+	}
+
+	feedback, err := h.DB.QueryFeedback(t.Context(), "a photo", h.Embedder.Model())
+	if err != nil {
+		t.Fatal(err)
+	}
+	votes := map[int]bool{}
+	for _, fb := range feedback {
+		votes[fb.ImageId] = fb.Relevant
+	}
+	if expected, actual := (map[int]bool{1: false, 3: true}), votes; !maps.Equal(expected, actual) {
+		t.Errorf("Expected votes %v, got %v", expected, actual)
+	}
+
+	// The query moves towards the image voted up
+	img, err := h.DB.GetImage(t.Context(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embeds, err := h.DB.ImageEmbeddings(t.Context(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vec, err := h.Embedder.Embeddings(t.Context(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adjusted, err := adjustQuery(t.Context(), query, h.Embedder.Model(), vec, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := computeCosineSimilarity(vec, embeds[0].Vector)
+	after, _ := computeCosineSimilarity(adjusted, embeds[0].Vector)
+	if after <= before {
+		t.Errorf("Expected feedback to raise the score of %s from %.3f, got %.3f", img.Path, before, after)
+	}
+
+	resp, err := http.Get(ts.URL + "/search?" + url.Values{"q": {query}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if expected, actual := 2, strings.Count(string(body), `text-orange-600" value=`); expected != actual {
+		t.Errorf("Expected %d votes shown, got %d", expected, actual)
+	}
+	if !strings.Contains(string(body), `data-image="3" data-vote="up"`) {
+		t.Errorf("Expected the vote up on image 3 in %s", body)
+	}
+
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	if err := exportFeedback(t.Context(), path, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	queries, err := readEvalQueries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := []evalQuery{{Query: "a photo", Relevant: []int{3}}}, queries; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected eval queries %+v, got %+v", expected, actual)
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+// Kinds of job
+const (
+	jobScan     = "scan"     // scan Arg, a library path, for new images
+	jobDescribe = "describe" // describe images lacking a description
+	jobEmbed    = "embed"    // embed descriptions lacking an embedding
+	jobReembed  = "reembed"  // recompute the embeddings of all descriptions
+)
+
+var errNoSuchJob = errors.New("no such job")
+
+// jobView is the JSON representation of a job, sent to subscribers whenever a
+// job changes.
+type jobView struct {
+	Id         int        `json:"id"`
+	Kind       string     `json:"kind"`
+	Arg        string     `json:"arg,omitempty"`
+	State      string     `json:"state"`
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	Errors     int        `json:"errors"`
+	Message    string     `json:"message,omitempty"`
+	Current    string     `json:"current,omitempty"` // the image being processed
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func newJobView(job *henri.Job, current string) *jobView {
+	jv := &jobView{
+		Id:        job.Id,
+		Kind:      job.Kind,
+		Arg:       job.Arg,
+		State:     job.State,
+		Total:     job.Total,
+		Done:      job.Done,
+		Errors:    job.Errors,
+		Message:   job.Message,
+		Current:   current,
+		CreatedAt: job.CreatedAt,
+	}
+	if job.StartedAt.Valid {
+		jv.StartedAt = &job.StartedAt.Time
+	}
+	if job.FinishedAt.Valid {
+		jv.FinishedAt = &job.FinishedAt.Time
+	}
+	return jv
+}
+
+// jobRunner runs the queued jobs in the jobs table one at a time. Only one runs
+// at a time because they all compete for the LLM server and the DB.
+type jobRunner struct {
+	h      *henri.Henri
+	logger *log.Logger
+
+	wake chan struct{} // signalled when a job is queued
+
+	mu      sync.Mutex
+	current *runningJob
+	subs    map[chan *jobView]struct{}
+}
+
+// runningJob is the state of the job being run.
+type runningJob struct {
+	job       *henri.Job
+	image     string // description of the image being processed
+	cancel    context.CancelFunc
+	cancelled bool          // cancelled by request, not by shutdown
+	resume    chan struct{} // non-nil while paused, closed to resume
+}
+
+func newJobRunner(h *henri.Henri, logger *log.Logger) *jobRunner {
+	return &jobRunner{
+		h:      h,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+		subs:   make(map[chan *jobView]struct{}),
+	}
+}
+
+// run runs queued jobs until ctx is cancelled. Jobs interrupted by a previous
+// shutdown are run again first.
+func (jr *jobRunner) run(ctx context.Context) error {
+	n, err := jr.h.DB.RequeueJobs(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		jr.logger.Printf("Requeued %d interrupted jobs\n", n)
+	}
+
+	for {
+		job, err := jr.h.DB.NextQueuedJob(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-jr.wake:
+				continue
+			}
+		}
+
+		jr.runJob(ctx, job)
+		if ctx.Err() != nil || lameduck {
+			return nil
+		}
+	}
+}
+
+// enqueue creates a new job and wakes the runner.
+func (jr *jobRunner) enqueue(ctx context.Context, kind, arg string) (*henri.Job, error) {
+	switch kind {
+	case jobScan:
+		if arg == "" {
+			return nil, errors.New("missing library path to scan")
+		}
+		path, err := libraryPath(ctx, arg, jr.h.DB)
+		if err != nil {
+			return nil, err
+		}
+		arg = path
+	case jobDescribe:
+		if jr.h.Describer == nil {
+			return nil, errors.New("no describer configured, start the server with a backend that can describe images")
+		}
+	case jobEmbed, jobReembed:
+	default:
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+
+	job, err := jr.h.DB.CreateJob(ctx, kind, arg, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	jr.publish(newJobView(job, ""))
+
+	select {
+	case jr.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// libraryPath returns the absolute path of the library root at path. Jobs
+// only scan libraries already scanned from the command line, so that clients
+// can't have the server walk any directory.
+func libraryPath(ctx context.Context, path string, db *henri.DB) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	roots, err := db.Roots(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !slices.ContainsFunc(roots, func(root *henri.Root) bool { return root.Path == abs }) {
+		return "", fmt.Errorf("%s is not a library root, scan it with henri scan first", path)
+	}
+	return abs, nil
+}
+
+// pause pauses the running job with the given id once the current image is
+// finished.
+func (jr *jobRunner) pause(id int) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	rj := jr.current
+	if rj == nil || rj.job.Id != id {
+		return errNoSuchJob
+	}
+	if rj.resume == nil {
+		rj.resume = make(chan struct{})
+		rj.job.State = henri.JobPaused
+		jr.publishLocked(newJobView(rj.job, rj.image))
+	}
+	return nil
+}
+
+// resume resumes a paused job.
+func (jr *jobRunner) resume(id int) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	rj := jr.current
+	if rj == nil || rj.job.Id != id {
+		return errNoSuchJob
+	}
+	if rj.resume != nil {
+		close(rj.resume)
+		rj.resume = nil
+		rj.job.State = henri.JobRunning
+		jr.publishLocked(newJobView(rj.job, rj.image))
+	}
+	return nil
+}
+
+// cancel cancels a running, paused or queued job.
+func (jr *jobRunner) cancel(ctx context.Context, id int) error {
+	jr.mu.Lock()
+	if rj := jr.current; rj != nil && rj.job.Id == id {
+		rj.cancelled = true
+		rj.cancel()
+		jr.mu.Unlock()
+		return nil
+	}
+	jr.mu.Unlock()
+
+	job, err := jr.h.DB.GetJob(ctx, id)
+	if err != nil {
+		return errNoSuchJob
+	}
+	if job.State != henri.JobQueued {
+		return fmt.Errorf("job %d is %s", id, job.State)
+	}
+	job.State = henri.JobCancelled
+	job.FinishedAt.Time, job.FinishedAt.Valid = time.Now(), true
+	if err := jr.h.DB.UpdateJob(ctx, job); err != nil {
+		return err
+	}
+	jr.publish(newJobView(job, ""))
+	return nil
+}
+
+// jobs returns the most recent jobs, with live progress for the running job.
+func (jr *jobRunner) jobs(ctx context.Context, limit int) ([]*jobView, error) {
+	jobs, err := jr.h.DB.Jobs(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	views := make([]*jobView, len(jobs))
+	for i, job := range jobs {
+		if rj := jr.current; rj != nil && rj.job.Id == job.Id {
+			views[i] = newJobView(rj.job, rj.image)
+		} else {
+			views[i] = newJobView(job, "")
+		}
+	}
+	return views, nil
+}
+
+// subscribe returns a channel that receives an event whenever a job changes.
+// Events are dropped if the subscriber falls behind. Call unsubscribe when
+// done.
+func (jr *jobRunner) subscribe() chan *jobView {
+	ch := make(chan *jobView, 32)
+
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.subs[ch] = struct{}{}
+	return ch
+}
+
+func (jr *jobRunner) unsubscribe(ch chan *jobView) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	delete(jr.subs, ch)
+}
+
+func (jr *jobRunner) publish(jv *jobView) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.publishLocked(jv)
+}
+
+func (jr *jobRunner) publishLocked(jv *jobView) {
+	for ch := range jr.subs {
+		select {
+		case ch <- jv:
+		default:
+		}
+	}
+}
+
+// runJob runs job to completion, cancellation or failure. If ctx is cancelled
+// the job is left in the running state, to be requeued when the server
+// restarts.
+func (jr *jobRunner) runJob(ctx context.Context, job *henri.Job) {
+	jctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rj := &runningJob{job: job, cancel: cancel}
+	job.State = henri.JobRunning
+	job.StartedAt.Time, job.StartedAt.Valid = time.Now(), true
+	job.Done, job.Errors, job.Message = 0, 0, ""
+	if err := jr.h.DB.UpdateJob(ctx, job); err != nil {
+		jr.logger.Printf("Job %d update error - %s\n", job.Id, err)
+	}
+
+	jr.mu.Lock()
+	jr.current = rj
+	jr.publishLocked(newJobView(job, ""))
+	jr.mu.Unlock()
+
+	jr.logger.Printf("Job %d %s started\n", job.Id, job.Kind)
+	err := jr.execute(jctx, rj)
+
+	// The final state is written without holding the lock, so subscribers
+	// and listings aren't held up by the DB. Until then the job is still
+	// current, and listed with its final state.
+	jr.mu.Lock()
+	switch {
+	case rj.cancelled:
+		job.State = henri.JobCancelled
+	case ctx.Err() != nil, lameduck:
+		// Shutting down
+		jr.current = nil
+		jr.mu.Unlock()
+		jr.logger.Printf("Job %d interrupted\n", job.Id)
+		return
+	case err != nil:
+		job.State = henri.JobFailed
+		job.Message = err.Error()
+	default:
+		job.State = henri.JobDone
+	}
+	job.FinishedAt.Time, job.FinishedAt.Valid = time.Now(), true
+	final := *job
+	jr.mu.Unlock()
+
+	if err := jr.h.DB.UpdateJob(ctx, &final); err != nil {
+		jr.logger.Printf("Job %d update error - %s\n", final.Id, err)
+	}
+	jr.logger.Printf("Job %d %s %s %s\n", final.Id, final.Kind, final.State, final.Message)
+
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.current = nil
+	jr.publishLocked(newJobView(&final, ""))
+}
+
+// execute does the work of rj's job.
+func (jr *jobRunner) execute(ctx context.Context, rj *runningJob) error {
+	var (
+		images []*henri.Image
+		workFn imageWorkFn
+		err    error
+	)
+
+	h := jr.h
+	switch rj.job.Kind {
+	case jobScan:
+		n, err := findAndInsertImageFiles(ctx, rj.job.Arg, h.DB)
+		if err != nil {
+			return err
+		}
+		rj.job.Message = fmt.Sprintf("Added %d new images", n)
+		return nil
+	case jobDescribe:
+		if h.Describer == nil {
+			return errors.New("no describer configured")
+		}
+		var cds *collectionDescribers
+		if cds, err = newCollectionDescribers(ctx, h); err != nil {
+			return err
+		}
+		images, err = h.DB.ImagesToDescribe(ctx)
+		workFn = cds.describeFn(h.DB)
+	case jobEmbed, jobReembed:
+		if rj.job.Kind == jobEmbed {
+			images, err = h.DB.DescribedImagesMissingEmbeddings(ctx, h.Embedder.Model())
+		} else {
+			images, err = h.DB.DescribedImages(ctx)
+		}
+		workFn = func(ctx context.Context, img *henri.Image, _ func(describer.Progress)) error {
+			return calcEmbeddingFn(ctx, h.Embedder, img, h.DB)
+		}
+	default:
+		return fmt.Errorf("unknown job kind %q", rj.job.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	jr.mu.Lock()
+	rj.job.Total = len(images)
+	jr.mu.Unlock()
+
+	// Paused jobs wait before starting on the next image
+	pausableFn := func(ctx context.Context, img *henri.Image, progress func(describer.Progress)) error {
+		jr.mu.Lock()
+		resume := rj.resume
+		jr.mu.Unlock()
+		if resume != nil {
+			select {
+			case <-resume:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return workFn(ctx, img, progress)
+	}
+
+	if err := processImages(ctx, images, pausableFn, &jobReporter{jr: jr, rj: rj}, nil); err != nil {
+		return err
+	}
+	if rj.job.Kind == jobEmbed || rj.job.Kind == jobReembed {
+		if _, _, err := refreshSavedSearches(ctx, h.Embedder, h.DB); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobReporter records the progress of a job and publishes it to subscribers.
+type jobReporter struct {
+	jr *jobRunner
+	rj *runningJob
+
+	lastPublish time.Time
+}
+
+func (rep *jobReporter) start(i, n int, img *henri.Image) {
+	_, fname := filepath.Split(img.Path)
+
+	rep.jr.mu.Lock()
+	defer rep.jr.mu.Unlock()
+	rep.rj.image = fmt.Sprintf("<%d: %s>", img.Id, fname)
+	rep.jr.publishLocked(newJobView(rep.rj.job, rep.rj.image))
+}
+
+func (rep *jobReporter) tokens(p describer.Progress) {
+	if p.Done || time.Since(rep.lastPublish) < 250*time.Millisecond {
+		return
+	}
+	rep.lastPublish = time.Now()
+
+	rep.jr.mu.Lock()
+	defer rep.jr.mu.Unlock()
+	current := fmt.Sprintf("%s %d tokens, %.1f tok/s", rep.rj.image, p.Tokens, p.TokensPerSecond())
+	rep.jr.publishLocked(newJobView(rep.rj.job, current))
+}
+
+func (rep *jobReporter) finish(img *henri.Image, elapsed time.Duration, err error) {
+	rep.jr.mu.Lock()
+	job := rep.rj.job
+	if errors.Is(err, context.Canceled) {
+		// The job was cancelled or paused, not the image's fault
+	} else if err != nil {
+		job.Errors++
+		rep.jr.logger.Printf("Job %d image %d error - %s\n", job.Id, img.Id, err)
+	} else {
+		job.Done++
+	}
+	rep.jr.publishLocked(newJobView(job, rep.rj.image))
+	snapshot := *job
+	rep.jr.mu.Unlock()
+
+	// Record progress so the admin page is accurate after a restart
+	if err := rep.jr.h.DB.UpdateJob(context.Background(), &snapshot); err != nil {
+		rep.jr.logger.Printf("Job %d update error - %s\n", job.Id, err)
+	}
+}
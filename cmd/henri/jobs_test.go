@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestServerJobs(t *testing.T) {
+	library := newTestLibrary(t, 2)
+	h := newTestHenri(t, AppModeServer)
+
+	srv := NewServer(h, "0")
+	ts := httptest.NewServer(srv.serveHandler())
+	defer ts.Close()
+
+	// Subscribe before starting any jobs so no events are missed
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/jobs/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, actual := "text/event-stream", resp.Header.Get("Content-Type"); expected != actual {
+		t.Fatalf("Expected content type %q, got %q", expected, actual)
+	}
+	events := bufio.NewScanner(resp.Body)
+
+	startJob := func(kind, path string) int {
+		t.Helper()
+
+		resp, err := http.PostForm(ts.URL+"/jobs", url.Values{"kind": {kind}, "path": {path}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if expected, actual := http.StatusCreated, resp.StatusCode; expected != actual {
+			t.Fatalf("Expected status %d, got %d", expected, actual)
+		}
+		var jv jobView
+		if err := json.NewDecoder(resp.Body).Decode(&jv); err != nil {
+			t.Fatal(err)
+		}
+		return jv.Id
+	}
+
+	// Only library roots can be scanned
+	resp, err = http.PostForm(ts.URL+"/jobs", url.Values{"kind": {jobScan}, "path": {t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+		t.Fatalf("Expected status %d scanning a directory that isn't a root, got %d", expected, actual)
+	}
+	if _, err := h.DB.EnsureRoot(t.Context(), filepath.Base(library), library); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel a job before the runner starts
+	id := startJob(jobScan, library)
+	resp, err = http.Post(fmt.Sprintf("%s/jobs/%d/cancel", ts.URL, id), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, actual := http.StatusNoContent, resp.StatusCode; expected != actual {
+		t.Fatalf("Expected status %d, got %d", expected, actual)
+	}
+
+	for _, kind := range []string{jobScan, jobDescribe, jobEmbed, jobReembed} {
+		startJob(kind, library)
+	}
+
+	go srv.RunJobs(ctx)
+
+	// Events are streamed as the jobs run
+	for events.Scan() {
+		if strings.HasPrefix(events.Text(), "data: ") {
+			break
+		}
+	}
+	if err := events.Err(); err != nil {
+		t.Fatalf("Unexpected event stream error %s", err)
+	}
+
+	// Wait for the jobs to finish
+	final := map[int]*jobView{}
+	for len(final) < 5 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for jobs")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		resp, err := http.Get(ts.URL + "/jobs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var jobs []*jobView
+		err = json.NewDecoder(resp.Body).Decode(&jobs)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, jv := range jobs {
+			if jv.FinishedAt != nil {
+				final[jv.Id] = jv
+			}
+		}
+	}
+
+	tests := []struct {
+		kind  string
+		state string
+		done  int
+	}{
+		{jobScan, henri.JobCancelled, 0},
+		{jobScan, henri.JobDone, 0},
+		{jobDescribe, henri.JobDone, 2},
+		{jobEmbed, henri.JobDone, 2},
+		{jobReembed, henri.JobDone, 2},
+	}
+	for i, tc := range tests {
+		jv := final[id+i]
+		if jv == nil {
+			t.Fatalf("Missing final event for job %d", id+i)
+		}
+		if expected, actual := tc.kind, jv.Kind; expected != actual {
+			t.Errorf("Expected job %d kind %q, got %q", jv.Id, expected, actual)
+		}
+		if expected, actual := tc.state, jv.State; expected != actual {
+			t.Errorf("Expected job %d state %q, got %q (%s)", jv.Id, expected, actual, jv.Message)
+		}
+		if expected, actual := tc.done, jv.Done; expected != actual {
+			t.Errorf("Expected job %d to process %d images, got %d", jv.Id, expected, actual)
+		}
+	}
+
+	ids, err := h.DB.EmbeddingIdsForModel(t.Context(), h.Embedder.Model(), henri.ImageFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, len(ids); expected != actual {
+		t.Errorf("Expected %d embeddings, got %d", expected, actual)
+	}
+
+	resp, err = http.Post(ts.URL+"/jobs/999/pause", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, actual := http.StatusNotFound, resp.StatusCode; expected != actual {
+		t.Errorf("Expected status %d, got %d", expected, actual)
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	_ "image/jpeg"
-	_ "image/png" // see imageDimensions()
+	_ "image/png" // see imageInfo()
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -29,6 +35,23 @@ const (
 	AppModeEmbeddings
 	AppModeQuery
 	AppModeServer
+	AppModePipeline
+	AppModeWatch
+	AppModeStatus
+	AppModeExport
+	AppModeImport
+	AppModeRelocate
+	AppModeCollection
+	AppModeCollections
+	AppModePhotos
+	AppModeWriteback
+	AppModeEdit
+	AppModeHistory
+	AppModeEval
+	AppModeFeedback
+	AppModeSave
+	AppModeSaved
+	AppModeMembers
 )
 
 type modeArgInfo struct {
@@ -42,93 +65,255 @@ var (
 	llamaSeed    = flag.Int("seed", 385480504, "Random seed to llama")
 	ollamaServer = flag.String("ollama", "", "Address of running ollama server, typically http://localhost:11434")
 	openAI       = flag.Bool("openai", false, "Use OpenAI (only embedding and search)")
+	describeWith = flag.String("describer", "", "Backend URI to describe images with, e.g. ollama://localhost:11434?model=llava")
+	embedWith    = flag.String("embedder", "", "Backend URI to compute embeddings with, e.g. openai://")
 	count        = flag.Int("count", -1, "Number of items to process, defaul is no limit")
 
 	modeArgs = map[string]modeArgInfo{
-		"scan":       {AppModeScan, 1},
-		"sc":         {AppModeScan, 1},
-		"describe":   {AppModeDescribe, 0},
-		"d":          {AppModeDescribe, 0},
-		"embeddings": {AppModeEmbeddings, 0},
-		"e":          {AppModeEmbeddings, 0},
-		"query":      {AppModeQuery, 1},
-		"q":          {AppModeQuery, 1},
-		"server":     {AppModeServer, 0},
-		"s":          {AppModeServer, 0},
+		"scan":        {AppModeScan, 1},
+		"sc":          {AppModeScan, 1},
+		"describe":    {AppModeDescribe, 0},
+		"d":           {AppModeDescribe, 0},
+		"embeddings":  {AppModeEmbeddings, 0},
+		"e":           {AppModeEmbeddings, 0},
+		"query":       {AppModeQuery, 1},
+		"q":           {AppModeQuery, 1},
+		"server":      {AppModeServer, 0},
+		"s":           {AppModeServer, 0},
+		"pipeline":    {AppModePipeline, 1},
+		"p":           {AppModePipeline, 1},
+		"watch":       {AppModeWatch, 1},
+		"w":           {AppModeWatch, 1},
+		"status":      {AppModeStatus, 0},
+		"st":          {AppModeStatus, 0},
+		"export":      {AppModeExport, 1},
+		"import":      {AppModeImport, 1},
+		"relocate":    {AppModeRelocate, 2},
+		"collection":  {AppModeCollection, 1},
+		"co":          {AppModeCollection, 1},
+		"collections": {AppModeCollections, 0},
+		"photos":      {AppModePhotos, 1},
+		"writeback":   {AppModeWriteback, 0},
+		"edit":        {AppModeEdit, 2},
+		"history":     {AppModeHistory, 1},
+		"eval":        {AppModeEval, 1},
+		"feedback":    {AppModeFeedback, 1},
+		"save":        {AppModeSave, 2},
+		"saved":       {AppModeSaved, 0},
+		"members":     {AppModeMembers, 1},
 	}
 
 	lameduck bool
 )
 
+const (
+	maxRetries   = 3 // attempts after the first for transient backend errors
+	retryBackoff = 2 * time.Second
+)
+
 // Walk the filesystem from root finding all supported image files.
 func findAndInsertImageFiles(ctx context.Context, root string, db *henri.DB) (int, error) {
+	return scanImageFiles(ctx, root, db, nil)
+}
+
+// scanImageFiles walks library inserting image files into the DB, with paths
+// relative to its library root. Files are chosen by the root's scan rules,
+// see newScanRules. If seen is not nil then absolute paths in it are skipped
+// without being read, new paths are added to it, and images whose dimensions
+// cannot be read are skipped rather than ending the walk. They may still be
+// being copied into the library and will be tried again on the next scan.
+func scanImageFiles(ctx context.Context, library string, db *henri.DB, seen map[string]bool) (int, error) {
+	root, coll, err := libraryRoot(ctx, library, db)
+	if err != nil {
+		return 0, err
+	}
+	rules, err := newScanRules(root, coll)
+	if err != nil {
+		return 0, err
+	}
+
+	candidates, err := walkLibrary(ctx, root.Path, rules, seen)
+	if err != nil {
+		return 0, err
+	}
+
+	return insertImageFiles(ctx, root, rules.pick(candidates), rules.minSize, db, seen)
+}
+
+// insertImageFiles reads the dimensions and hash of each candidate and inserts
+// them into the DB in root, skipping images smaller than minSize. The caption,
+// keywords and people recorded in each file or its XMP sidecar are stored
+// with it, updating those of images already in the DB. seen is treated as it
+// is by scanImageFiles.
+func insertImageFiles(ctx context.Context, root *henri.Root, candidates []scanCandidate, minSize int, db *henri.DB, seen map[string]bool) (int, error) {
 	var (
 		results []henri.ImagePath
 		nn      int
+		metas   = map[string]*fileMetadata{}
 	)
 
-	err := filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
+	for _, c := range candidates {
+		if ctx.Err() != nil || lameduck {
+			break
 		}
 
-		select {
-		case <-ctx.Done():
-			return filepath.SkipAll
-		default:
-			if lameduck {
-				return filepath.SkipAll
-			}
+		result := henri.ImagePath{
+			RootId:       root.Id,
+			CollectionId: root.CollectionId,
+			Path:         c.rel,
+			Modtime:      c.modtime,
 		}
 
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".jpg" || ext == ".jpeg" {
-			result := henri.ImagePath{
-				Path:    path,
-				Modtime: info.ModTime(),
+		// Retrieve the image dimensions and content hash
+		w, h, hash, err := imageInfo(c.path)
+		if err != nil {
+			if seen != nil {
+				log.Printf("Skipping %s - %s", c.path, err)
+				continue
 			}
+			return nn, fmt.Errorf("error reading image dimensions of %s - %s", c.path, err)
+		}
+		if seen != nil {
+			seen[c.path] = true
+		}
+		if w < minSize || h < minSize {
+			continue
+		}
+		result.Width = w
+		result.Height = h
+		result.Hash = hash
 
-			// Retrieve the image dimensions
-			var (
-				w, h int
-			)
-			w, h, err = imageDimensions(path)
+		if m, err := readFileMetadata(c.path); err != nil {
+			log.Printf("Skipping metadata of %s - %s", c.path, err)
+		} else {
+			metas[c.rel] = m
+		}
+
+		results = append(results, result)
+		if len(results) == 200 {
+			// Write this batch to the DB
+			n, err := db.InsertImagePaths(ctx, results, len(results))
 			if err != nil {
-				return fmt.Errorf("error reading image dimensions of %s - %s", path, err)
-			}
-			result.Width = w
-			result.Height = h
-
-			results = append(results, result)
-			if len(results) == 200 {
-				// Write this batch to the DB
-				n, err := db.InsertImagePaths(ctx, results, len(results))
-				if err != nil {
-					return err
-				}
-				nn += n
-				results = results[:0]
+				return nn, err
 			}
+			nn += n
+			results = results[:0]
 		}
-
-		return nil
-	})
+	}
 
 	if len(results) > 0 {
 		n, err := db.InsertImagePaths(ctx, results, len(results))
 		if err != nil {
-			return 0, err
+			return nn, err
 		}
 		nn += n
 	}
 
-	return nn, err
+	if len(metas) == 0 {
+		return nn, nil
+	}
+	ids, err := db.ImageIdsByPath(ctx, root.Id)
+	if err != nil {
+		return nn, err
+	}
+	for rel, m := range metas {
+		if err := applyFileMetadata(ctx, db, ids[rel], m); err != nil {
+			return nn, err
+		}
+	}
+
+	return nn, nil
 }
 
-// Retrieve the dimensions of the images
+// walkLibrary walks the library root at dir returning the JPEG files wanted by
+// rules. Files in seen are returned marked as seen. Symbolic links are only
+// followed if rules allow it, and each directory is only walked once.
+func walkLibrary(ctx context.Context, dir string, rules *scanRules, seen map[string]bool) ([]scanCandidate, error) {
+	var (
+		candidates []scanCandidate
+		visited    = map[string]bool{}
+		walk       func(top string) error
+	)
+
+	// The real path is walked, as WalkDir doesn't follow a symbolic link
+	// at the top, and its files reported under the path of the link
+	walk = func(top string) error {
+		real, err := filepath.EvalSymlinks(top)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+
+		return filepath.WalkDir(real, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil || lameduck {
+				return filepath.SkipAll
+			}
+
+			sub, err := filepath.Rel(real, path)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(top, sub)
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				if !rules.follow {
+					return nil
+				}
+				if info, err = os.Stat(path); err != nil {
+					log.Printf("Skipping %s - %s", path, err)
+					return nil
+				}
+				if info.IsDir() {
+					if rules.skipDir(rel) {
+						return nil
+					}
+					return walk(path)
+				}
+			}
+
+			if info.IsDir() {
+				if sub != "." && rules.skipDir(rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			ext := strings.ToLower(filepath.Ext(path))
+			if (ext == ".jpg" || ext == ".jpeg") && rules.wantFile(rel) {
+				candidates = append(candidates, scanCandidate{
+					path:    path,
+					rel:     rel,
+					modtime: info.ModTime(),
+					size:    info.Size(),
+					seen:    seen[path],
+				})
+			}
+			return nil
+		})
+	}
+
+	return candidates, walk(dir)
+}
+
+// Retrieve the dimensions and the hex SHA-256 hash of the images
 // Most of the time this will be JPEGs but in my photo library I found at least two PNGs that had a JPEG extension.
 // Those should still be included.
-func imageDimensions(imgPath string) (w int, h int, err error) {
+func imageInfo(imgPath string) (w int, h int, hash string, err error) {
 	var f *os.File
 
 	f, err = os.Open(imgPath)
@@ -137,20 +322,95 @@ func imageDimensions(imgPath string) (w int, h int, err error) {
 	}
 	defer f.Close()
 
+	// The file is hashed as it is decoded, then the remainder after the
+	// end of the image data
+	hasher := sha256.New()
+	r := io.TeeReader(f, hasher)
+
 	var img image.Image
-	img, _, err = image.Decode(f)
+	img, _, err = image.Decode(r)
 	if err != nil {
 		return
 	}
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return
+	}
 
 	bounds := img.Bounds()
 	w = bounds.Max.X
 	h = bounds.Max.Y
+	hash = hex.EncodeToString(hasher.Sum(nil))
 
 	return
 }
 
-func describeImageFn(ctx context.Context, d describer.Describer, img *henri.Image, db *henri.DB) error {
+// imageSize returns the dimensions of the image at imgPath, reading only its
+// header.
+func imageSize(imgPath string) (w int, h int, err error) {
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// imageReporter is told about the progress of processImages.
+type imageReporter interface {
+	// start is called before img, the i'th of n images, is processed.
+	start(i, n int, img *henri.Image)
+
+	// tokens is called as tokens of a streamed description arrive.
+	tokens(p describer.Progress)
+
+	// finish is called once img has been processed, err is nil on success.
+	finish(img *henri.Image, elapsed time.Duration, err error)
+}
+
+// termReporter prints a line per image to the terminal, with live token counts
+// while an image is described.
+type termReporter struct {
+	prefix    string // the "Processing ..." line
+	last      describer.Progress
+	lastPrint time.Time
+}
+
+func (tr *termReporter) start(i, n int, img *henri.Image) {
+	_, fname := filepath.Split(img.Path)
+	tr.prefix = fmt.Sprintf("Processing %d/%d <%d: %s> ", i, n, img.Id, fname)
+	tr.last = describer.Progress{}
+	fmt.Print(tr.prefix)
+}
+
+func (tr *termReporter) tokens(p describer.Progress) {
+	tr.last = p
+	if p.Done || time.Since(tr.lastPrint) < 250*time.Millisecond {
+		return
+	}
+	fmt.Printf("\r%s%d tokens, %.1f tok/s ", tr.prefix, p.Tokens, p.TokensPerSecond())
+	tr.lastPrint = time.Now()
+}
+
+func (tr *termReporter) finish(img *henri.Image, elapsed time.Duration, err error) {
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+	fmt.Printf("\r%sokay, %d secs", tr.prefix, int(elapsed.Seconds()))
+	if p := tr.last; p.Tokens > 0 {
+		fmt.Printf(", %d tokens (%.1f tok/s)", p.Tokens, p.TokensPerSecond())
+	}
+	fmt.Println()
+}
+
+// describeImageFn describes img with d. If d streams its descriptions then
+// progress, if not nil, is called as tokens arrive.
+func describeImageFn(ctx context.Context, d describer.ImageDescriber, img *henri.Image, db *henri.DB, progress func(describer.Progress)) error {
 	now := time.Now()
 
 	imgdata, err := os.ReadFile(img.Path)
@@ -168,21 +428,32 @@ func describeImageFn(ctx context.Context, d describer.Describer, img *henri.Imag
 		return err
 	}
 
-	img.Description, err = d.DescribeImage(ctx, imgdata)
+	if sd, ok := d.(describer.StreamingDescriber); ok && progress != nil {
+		img.Description, err = sd.DescribeImageStream(ctx, imgdata, progress)
+	} else {
+		img.Description, err = d.DescribeImage(ctx, imgdata)
+	}
 	if err != nil {
-		db.UpdateImageAttempted(ctx, img.Id, d.Model(), d.Name(), now) // ignore error, already in an error state
+		// Leave the image to be described later if the failure was not
+		// caused by the image.
+		if !describer.IsRetryable(err) && !errors.Is(err, describer.ErrModelNotFound) && !errors.Is(err, context.Canceled) {
+			db.UpdateImageAttempted(ctx, img.Id, d.Model(), d.Name(), now) // ignore error, already in an error state
+		}
 		return err
 	} else {
 		img.ProcessedAt.Time = now
 		img.ProcessedAt.Valid = true // TODO - this feels error prone, is there a better way?
-		db.UpdateImage(ctx, img, d.Model(), d.Name())
+		img.DescribeTime = time.Since(now)
+		return db.AddDescription(ctx, img, descriptionSet(d), d.Name())
 	}
-
-	return nil
 }
 
-func calcEmbeddingFn(ctx context.Context, d describer.Describer, img *henri.Image, db *henri.DB) error {
-	vector, err := d.Embeddings(ctx, img.Description)
+func calcEmbeddingFn(ctx context.Context, d describer.TextEmbedder, img *henri.Image, db *henri.DB) error {
+	text, err := embeddingText(ctx, img, db)
+	if err != nil {
+		return err
+	}
+	vector, err := d.Embeddings(ctx, text)
 	if err != nil {
 		return err
 	}
@@ -194,57 +465,185 @@ func calcEmbeddingFn(ctx context.Context, d describer.Describer, img *henri.Imag
 	return nil
 }
 
-func run(ctx context.Context, mode AppMode, h *henri.Henri) error {
-	if mode == AppModeDescribe && h.Name() == "openai" {
-		return fmt.Errorf("for privacy reasons OpenAI cannot be used for describing")
+// embeddingText returns the text embedded for img, its description followed
+// by the caption and keywords from its file so they can be searched too.
+func embeddingText(ctx context.Context, img *henri.Image, db *henri.DB) (string, error) {
+	labels, err := db.ImageLabels(ctx, img.Id)
+	if err != nil {
+		return "", err
+	}
+	var keywords []string
+	for _, l := range labels {
+		if l.Kind == henri.LabelKeyword {
+			keywords = append(keywords, l.Name)
+		}
 	}
 
+	text := img.Description
+	if img.Caption != "" {
+		text += "\n" + img.Caption
+	}
+	if len(keywords) > 0 {
+		text += "\nKeywords: " + strings.Join(keywords, ", ")
+	}
+	return text, nil
+}
+
+// withRetries calls fn, repeating it with exponential backoff while it returns
+// a transient backend error.
+func withRetries(ctx context.Context, fn func() error) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !describer.IsRetryable(err) || attempt == maxRetries {
+			return err
+		}
+
+		fmt.Printf("%s, retrying in %s ", err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// run runs mode with its arguments args, those before any flags.
+func run(ctx context.Context, mode AppMode, args []string, h *henri.Henri) error {
 	defer h.DB.Close()
 
-	if mode == AppModeScan {
-		if len(os.Args) < 2 {
+	// Modes that only use the DB
+	switch mode {
+	case AppModeScan:
+		if len(args) < 1 {
 			return fmt.Errorf("missing library path to scan")
 		}
-		imagecount, err := findAndInsertImageFiles(ctx, os.Args[2], h.DB)
+		imagecount, err := findAndInsertImageFiles(ctx, args[0], h.DB)
 		if err != nil {
 			return err
 		}
 		fmt.Printf("Added %d new images\n", imagecount)
 		return nil
+	case AppModePhotos:
+		return photosCommand(ctx, args, h)
+	case AppModeWriteback:
+		return writebackCommand(ctx, h)
+	case AppModeExport:
+		return exportCommand(ctx, args, h)
+	case AppModeFeedback:
+		return feedbackCommand(ctx, args, h)
+	case AppModeImport:
+		return importCommand(ctx, args, h)
+	case AppModeRelocate:
+		return relocateCommand(ctx, args, h)
+	case AppModeCollection:
+		return collectionCommand(ctx, args, h)
+	case AppModeCollections:
+		return printCollections(ctx, os.Stdout, h.DB)
+	case AppModeHistory:
+		return historyCommand(ctx, args, h)
+	case AppModeStatus:
+		return statusCommand(ctx, h)
+	case AppModeSaved:
+		return savedCommand(ctx, h)
+	case AppModeMembers:
+		return membersCommand(ctx, args, h)
 	}
 
 	// All functionality from this point on requires the LLM server. Check if
 	// it is healthy.
-	if !h.Describer.IsHealthy() {
-		return fmt.Errorf("server is not responding")
+	for _, b := range []describer.Backend{h.Describer, h.Embedder, h.Reranker} {
+		if b != nil && !b.IsHealthy() {
+			return fmt.Errorf("%s server is not responding", b.Name())
+		}
 	}
 
-	if mode == AppModeQuery {
-		if len(os.Args) < 2 {
+	switch mode {
+	case AppModePipeline:
+		return pipelineCommand(ctx, args, h)
+	case AppModeEdit:
+		return editCommand(ctx, args, h)
+	case AppModeWatch:
+		return watchCommand(ctx, args, h)
+	case AppModeEval:
+		return evalCommand(ctx, args, h)
+	case AppModeQuery:
+		if len(args) < 1 {
 			return fmt.Errorf("missing query string")
 		}
 
+		params, err := filterFlags()
+		if err != nil {
+			return err
+		}
+		filter, err := imageFilter(ctx, h.DB, params)
+		if err != nil {
+			return err
+		}
+
+		lambda, err := parseLambda(*mmrLambda)
+		if err != nil {
+			return err
+		}
+
+		cq, err := parseQuery(args[0])
+		if err != nil {
+			return err
+		}
+		filter.Keywords = append(filter.Keywords, cq.Keywords...)
+
 		// Issue query
-		if err := runQuery(os.Args[2], h.Describer, h.DB); err != nil {
+		if err := runQuery(args[0], cq, filter, lambda, h.Embedder, h.Reranker, h.DB); err != nil {
 			return err
 		}
 
 		return nil
+	case AppModeSave:
+		return saveCommand(ctx, args, h)
 	}
 
 	var (
-		images []*henri.Image
-		workFn func(context.Context, describer.Describer, *henri.Image, *henri.DB) error
-		err    error
+		images  []*henri.Image
+		workFn  imageWorkFn
+		backend describer.Backend
+		err     error
 	)
 
 	switch mode {
 	case AppModeDescribe:
-		images, err = h.DB.ImagesToDescribe(ctx)
-		workFn = describeImageFn
+		var cds *collectionDescribers
+		if cds, err = newCollectionDescribers(ctx, h); err != nil {
+			return err
+		}
+		backend = h.Describer
+		if *modelName != "" {
+			images, err = cds.imagesMissingDescriptions(ctx, h.DB)
+		} else {
+			images, err = h.DB.ImagesToDescribe(ctx)
+		}
+		workFn = cds.describeFn(h.DB)
 	case AppModeEmbeddings:
-		images, err = h.DB.DescribedImagesMissingEmbeddings(ctx, h.Describer.Model())
-		workFn = calcEmbeddingFn
+		backend = h.Embedder
+		// Embed a description set given by --filter descriptions=
+		var (
+			params url.Values
+			filter henri.ImageFilter
+		)
+		if params, err = filterFlags(); err != nil {
+			return err
+		}
+		if filter, err = imageFilter(ctx, h.DB, params); err != nil {
+			return err
+		}
+		if set := filter.Descriptions; set.Model != "" {
+			images, err = h.DB.DescriptionsMissingEmbeddings(ctx, h.Embedder.Model(), set)
+		} else {
+			images, err = h.DB.DescribedImagesMissingEmbeddings(ctx, h.Embedder.Model())
+		}
+		workFn = func(ctx context.Context, img *henri.Image, _ func(describer.Progress)) error {
+			return calcEmbeddingFn(ctx, h.Embedder, img, h.DB)
+		}
 	}
 	if err != nil {
 		return err
@@ -255,15 +654,34 @@ func run(ctx context.Context, mode AppMode, h *henri.Henri) error {
 	}
 	fmt.Printf("%d images to process\n", len(images))
 	if len(images) > 0 {
-		fmt.Printf("Using describer %s model %s\n", h.Describer.Name(), h.Describer.Model())
+		fmt.Printf("Using describer %s model %s\n", backend.Name(), backend.Model())
+	}
+
+	if err := processImages(ctx, images, workFn, &termReporter{}, nil); err != nil {
+		return err
 	}
+	if mode == AppModeEmbeddings && len(images) > 0 {
+		return updateSavedSearches(ctx, h.Embedder, h.DB)
+	}
+	return nil
+}
+
+// imageWorkFn processes a single image. Streaming work reports its progress
+// through progress.
+type imageWorkFn func(ctx context.Context, img *henri.Image, progress func(describer.Progress)) error
+
+// processImages calls workFn on each image in turn, telling rep about each.
+// It stops early on lameduck, context cancellation, a missing model or too
+// many errors. If done is not nil it is called with each image that was
+// processed successfully.
+func processImages(ctx context.Context, images []*henri.Image, workFn imageWorkFn, rep imageReporter, done func(*henri.Image)) error {
+	var err error
 
 	errcnt := 0
 out:
 	for i := 0; i < len(images) && !lameduck; i++ {
 		if errcnt >= 5 {
-			fmt.Println("Too many errors, exiting")
-			return err
+			return fmt.Errorf("too many errors - %w", err)
 		}
 
 		select {
@@ -273,18 +691,25 @@ out:
 		}
 
 		img := images[i]
-		_, fname := filepath.Split(img.Path)
-
-		fmt.Printf("Processing %d/%d <%d: %s> ", i, len(images), img.Id, fname)
+		rep.start(i, len(images), img)
 		now := time.Now()
 
-		if err = workFn(ctx, h.Describer, img, h.DB); err != nil {
+		err = withRetries(ctx, func() error { return workFn(ctx, img, rep.tokens) })
+		rep.finish(img, time.Since(now), err)
+		if err != nil {
+			switch {
+			case errors.Is(err, describer.ErrModelNotFound):
+				return err
+			case errors.Is(err, describer.ErrContextLength), errors.Is(err, describer.ErrBadResponse):
+				// The backend can't handle this image, skip it
+				continue
+			}
 			errcnt++
-			fmt.Println()
 			continue
 		}
-		end := time.Now()
-		fmt.Printf("okay, %d secs\n", int(end.Sub(now).Seconds()))
+		if done != nil {
+			done(img)
+		}
 	}
 
 	return nil
@@ -305,18 +730,119 @@ func sighandler(ch chan os.Signal, cancel context.CancelFunc) {
 	}
 }
 
+// legacyBackendURI converts the original per-backend flags into a backend URI.
+// Only one of them may be set.
+func legacyBackendURI() (string, error) {
+	var uris []string
+	if *openAI {
+		uris = append(uris, "openai://")
+	}
+	for name, addr := range map[string]string{"llama": *llamaServer, "ollama": *ollamaServer} {
+		if addr == "" {
+			continue
+		}
+		// Addresses without a scheme, such as localhost:11434, are http
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s server address - %w", name, err)
+		}
+		params := url.Values{}
+		if u.Scheme == "https" {
+			params.Set("tls", "true")
+		}
+		if name == "llama" {
+			params.Set("seed", fmt.Sprint(*llamaSeed))
+		}
+		uris = append(uris, (&url.URL{Scheme: name, Host: u.Host, Path: u.Path, RawQuery: params.Encode()}).String())
+	}
+
+	switch len(uris) {
+	case 0:
+		return "", nil
+	case 1:
+		return uris[0], nil
+	default:
+		return "", errors.New("multiple backends selected, only one allowed")
+	}
+}
+
+// needsDescriber returns whether mode describes images.
+func needsDescriber(mode AppMode) bool {
+	switch mode {
+	case AppModeDescribe, AppModePipeline, AppModeWatch:
+		return true
+	case AppModeServer:
+		// For the watcher
+		return *watchLibrary != ""
+	}
+	return false
+}
+
+// wantsDescriber returns whether mode describes images if the backend can.
+func wantsDescriber(mode AppMode) bool {
+	// For describe jobs started from the admin page
+	return mode == AppModeServer
+}
+
+// needsEmbedder returns whether mode computes embeddings.
+func needsEmbedder(mode AppMode) bool {
+	switch mode {
+	case AppModeScan, AppModeDescribe, AppModeStatus, AppModeExport, AppModeImport, AppModeRelocate,
+		AppModeCollection, AppModeCollections, AppModePhotos, AppModeWriteback, AppModeHistory, AppModeFeedback,
+		AppModeSaved, AppModeMembers:
+		return false
+	}
+	return true
+}
+
+// wantsEmbedder returns whether mode computes embeddings if a backend is
+// configured for them.
+func wantsEmbedder(mode AppMode) bool {
+	// Saved searches are listed as last evaluated without an embedder
+	return mode == AppModeSaved || mode == AppModeMembers
+}
+
 func printUsageAndExit() {
 	w := flag.CommandLine.Output()
 	fmt.Fprintln(w, "Usage:")
 	fmt.Fprintln(w, "  henri scan, sc <library_path>    Recursively scan library_path for JPEG files")
-	fmt.Fprintln(w, "  henri describe, d                Generate textual descriptions for images")
+	fmt.Fprintln(w, "  henri describe, d                Generate textual descriptions for images, or add descriptions by --model")
 	fmt.Fprintln(w, "  henri embeddings, e              Generate embeddings from image descriptions")
 	fmt.Fprintln(w, "  henri query, q <query>           Search embeddings using the query")
 	fmt.Fprintln(w, "  henri server, s                  Start a web server on port 8080, override with PORT env var")
+	fmt.Fprintln(w, "  henri pipeline, p <library_path> Scan, describe and embed in one long running command")
+	fmt.Fprintln(w, "  henri watch, w <library_path>    Poll library_path, describing and embedding new images")
+	fmt.Fprintln(w, "  henri status, st                 Report how much of the library is described and embedded")
+	fmt.Fprintln(w, "  henri export <file>              Export current descriptions and their embeddings as JSONL, - for stdout")
+	fmt.Fprintln(w, "  henri import <file>              Import an export into the scanned images with the same content")
+	fmt.Fprintln(w, "  henri relocate <root> <path>     Move a library root to a new path")
+	fmt.Fprintln(w, "  henri collection, co <name>      Create or change a collection with --describer, --prompt, --include and --exclude")
+	fmt.Fprintln(w, "  henri collections                List the collections")
+	fmt.Fprintln(w, "  henri photos <library_path>      Scan an Apple Photos library with its albums, people, favourites and dates")
+	fmt.Fprintln(w, "  henri writeback                  Write descriptions, keywords and people to XMP sidecars")
+	fmt.Fprintln(w, "  henri edit <id> <description>    Replace an image's description and embed it again, see --author")
+	fmt.Fprintln(w, "  henri history <id>               List the versions of an image's description")
+	fmt.Fprintln(w, "  henri eval <file>                Score search against golden queries, see --k and --eval-embedder")
+	fmt.Fprintln(w, "  henri feedback <file>            Export the feedback on search results as eval queries, - for stdout")
+	fmt.Fprintln(w, "  henri save <name> <query>        Save a search as a smart album with --threshold and --filter, \"\" deletes it")
+	fmt.Fprintln(w, "  henri saved                      List the saved searches")
+	fmt.Fprintln(w, "  henri members <name>             Print the paths of the images in a saved search, best first")
 	fmt.Fprintln(w)
 	fmt.Fprintln(w, "Flags:")
 
 	flag.CommandLine.PrintDefaults()
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Backends:")
+	for _, reg := range describer.Registered() {
+		fmt.Fprintf(w, "  %-8s %s\n", reg.Name, reg.Description)
+		for _, p := range reg.Params {
+			fmt.Fprintf(w, "           ?%s=%s  %s\n", p.Name, p.Default, p.Description)
+		}
+	}
 	os.Exit(0)
 }
 
@@ -337,21 +863,39 @@ func main() {
 		flag.Usage()
 	}
 
-	// Parse command line args after the mode
-	if err := flag.CommandLine.Parse(os.Args[2+modeinfo.addArgs:]); err != nil {
+	// Parse command line args after the mode and its arguments
+	args := os.Args[2:min(len(os.Args), 2+modeinfo.addArgs)]
+	if err := flag.CommandLine.Parse(os.Args[2+len(args):]); err != nil {
 		log.Fatal(err)
 	}
 
+	legacyURI, err := legacyBackendURI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	describeURI, embedURI := cmp.Or(*describeWith, legacyURI), cmp.Or(*embedWith, legacyURI)
+	if *modelName != "" && cmp.Or(describeURI, embedURI) != "" {
+		// The model only replaces the describer's, not the embedder's
+		embedURI = cmp.Or(embedURI, describeURI)
+		if describeURI, err = withURIParam(cmp.Or(describeURI, embedURI), "model", *modelName); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	hio := henri.InitOptions{
-		DbPath:                *dbPath,
-		LlamaServer:           *llamaServer,
-		LlamaSeed:             *llamaSeed,
-		OllamaServer:          *ollamaServer,
-		OpenAI:                *openAI,
-		NoSpecifiedBackendsOK: modeinfo.mode == AppModeScan,
-		HttpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		DbPath:        *dbPath,
+		Describe:      describeURI,
+		Embed:         embedURI,
+		Rerank:        *rerankWith,
+		NeedDescriber: needsDescriber(modeinfo.mode),
+		NeedEmbedder:  needsEmbedder(modeinfo.mode),
+		WantDescriber: wantsDescriber(modeinfo.mode),
+		WantEmbedder:  wantsEmbedder(modeinfo.mode),
+		// No total timeout, a large model can take minutes to describe an
+		// image. The backends give up on requests that stop making progress,
+		// or that take too long for those that don't stream.
+		HttpClient: &http.Client{},
 	}
 	sigch := make(chan os.Signal, 2)
 	signal.Notify(sigch, os.Interrupt)
@@ -369,7 +913,16 @@ func main() {
 		if port == "" {
 			port = "8080"
 		}
-		srv := NewServer(h.Describer, h.DB, port)
+		srv := NewServer(h, port)
+		go func() {
+			if err := srv.RunJobs(ctx); err != nil {
+				log.Printf("Job runner stopped - %s", err)
+			}
+		}()
+
+		if *watchLibrary != "" {
+			go newWatcher(*watchLibrary, h, *pollInterval).run(ctx)
+		}
 
 		go func() {
 			if err := srv.Start(); err != nil {
@@ -394,7 +947,7 @@ func main() {
 		os.Exit(0)
 	}
 
-	if err := run(ctx, modeinfo.mode, h); err != nil {
+	if err := run(ctx, modeinfo.mode, args, h); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+// writeJPEG writes a solid color JPEG of the given size to path.
+func writeJPEG(t *testing.T, path string, w, h int, c color.Color) {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, c)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func initHenri(t *testing.T, dbPath string, mode AppMode) *henri.Henri {
+	t.Helper()
+
+	h, err := henri.Init(t.Context(), henri.InitOptions{
+		DbPath:        dbPath,
+		Describe:      "fake://",
+		NeedDescriber: needsDescriber(mode),
+		NeedEmbedder:  needsEmbedder(mode),
+		WantDescriber: wantsDescriber(mode),
+		WantEmbedder:  wantsEmbedder(mode),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// newTestHenri returns a Henri for mode with a new database and the fake
+// backend. The database is closed when the test ends.
+func newTestHenri(t *testing.T, mode AppMode) *henri.Henri {
+	t.Helper()
+
+	h := initHenri(t, filepath.Join(t.TempDir(), "henri.db"), mode)
+	t.Cleanup(h.DB.Close)
+	return h
+}
+
+// testColors are the colors of the images of newTestLibrary.
+var testColors = []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255}}
+
+// newTestLibrary writes n small JPEGs of different sizes and colors, a.jpg,
+// b.jpg and so on, to a new library directory and returns its path.
+func newTestLibrary(t *testing.T, n int) string {
+	t.Helper()
+
+	library := t.TempDir()
+	for i := range n {
+		writeJPEG(t, filepath.Join(library, fmt.Sprintf("%c.jpg", 'a'+i)), 8+i, 8, testColors[i%len(testColors)])
+	}
+	return library
+}
+
+// indexTestLibrary scans, describes and embeds library with a new Henri in
+// pipeline mode, and returns the Henri.
+func indexTestLibrary(t *testing.T, library string) *henri.Henri {
+	t.Helper()
+
+	h := newTestHenri(t, AppModePipeline)
+	if err := runPipeline(t.Context(), library, h); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// TestPipelineOffline runs scan, describe, embeddings, query and search
+// against the fake backend.
+func TestPipelineOffline(t *testing.T) {
+	library := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "henri.db")
+
+	if err := os.Mkdir(filepath.Join(library, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeJPEG(t, filepath.Join(library, "a.jpg"), 16, 8, color.RGBA{255, 0, 0, 255})
+	writeJPEG(t, filepath.Join(library, "sub", "b.JPEG"), 8, 16, color.RGBA{0, 255, 0, 255})
+	writeJPEG(t, filepath.Join(library, "c.jpeg"), 8, 8, color.RGBA{0, 0, 255, 255})
+	if err := os.WriteFile(filepath.Join(library, "notes.txt"), []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := initHenri(t, dbPath, AppModeScan)
+	n, err := findAndInsertImageFiles(t.Context(), library, h.DB)
+	h.DB.Close()
+	if err != nil {
+		t.Fatalf("Unexpected scan error %s", err)
+	}
+	if expected, actual := 3, n; expected != actual {
+		t.Fatalf("Expected %d images added, got %d", expected, actual)
+	}
+
+	for _, mode := range []AppMode{AppModeDescribe, AppModeEmbeddings} {
+		if err := run(t.Context(), mode, nil, initHenri(t, dbPath, mode)); err != nil {
+			t.Fatalf("Unexpected run error %s", err)
+		}
+	}
+
+	h = initHenri(t, dbPath, AppModeQuery)
+	defer h.DB.Close()
+
+	images, err := h.DB.ImagesToDescribe(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 0 {
+		t.Errorf("Expected all images to be described, %d remaining", len(images))
+	}
+	images, err = h.DB.DescribedImagesMissingEmbeddings(t.Context(), h.Embedder.Model())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 0 {
+		t.Errorf("Expected all images to have embeddings, %d remaining", len(images))
+	}
+
+	// Search for the first image using its own description, it should be
+	// the top result.
+	img, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cq, err := parseQuery(img.Description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runQuery(img.Description, cq, henri.ImageFilter{}, 1, h.Embedder, nil, h.DB); err != nil {
+		t.Fatalf("Unexpected query error %s", err)
+	}
+
+	srv := NewServer(h, "0")
+	ts := httptest.NewServer(srv.serveHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/search?q=" + strings.ReplaceAll(img.Description, " ", "+"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+		t.Fatalf("Expected status %d, got %d", expected, actual)
+	}
+	if !strings.Contains(string(body), `src="/image/1"`) {
+		t.Errorf("Expected search results to include image 1, got %s", body)
+	}
+
+	resp, err = http.Get(ts.URL + "/image/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, actual := "image/jpeg", resp.Header.Get("Content-Type"); expected != actual {
+		t.Errorf("Expected content type %q, got %q", expected, actual)
+	}
+}
+
+func TestLegacyBackendURI(t *testing.T) {
+	defer func() { *ollamaServer, *llamaServer = "", "" }()
+
+	tests := []struct {
+		ollama, llama string
+		want          string
+		addr          string
+	}{
+		{ollama: "http://localhost:11434", want: "ollama://localhost:11434", addr: "http://localhost:11434"},
+		{ollama: "localhost:11434", want: "ollama://localhost:11434", addr: "http://localhost:11434"},
+		{ollama: "https://proxy/ollama", want: "ollama://proxy/ollama?tls=true", addr: "https://proxy/ollama"},
+		{llama: "localhost:8080/llama/", want: "llama://localhost:8080/llama/?seed=385480504", addr: "http://localhost:8080/llama"},
+	}
+	for _, tc := range tests {
+		*ollamaServer, *llamaServer = tc.ollama, tc.llama
+		uri, err := legacyBackendURI()
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.want, err)
+			continue
+		}
+		if expected, actual := tc.want, uri; expected != actual {
+			t.Errorf("Expected URI %q, got %q", expected, actual)
+		}
+		_, cfg, err := describer.ParseConfig(uri)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", uri, err)
+			continue
+		}
+		if expected, actual := tc.addr, cfg.ServerAddr(); expected != actual {
+			t.Errorf("Expected server address %q, got %q", expected, actual)
+		}
+	}
+}
+
+func TestInitDescriber(t *testing.T) {
+	defer func() { *ollamaServer = "" }()
+	*ollamaServer = "http://localhost:11434"
+	legacy, err := legacyBackendURI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server describes images if its backend can, other modes that
+	// describe need one that can
+	tests := []struct {
+		mode AppMode
+		uri  string
+		want bool
+		err  bool
+	}{
+		{AppModeServer, legacy, true, false},
+		{AppModeServer, "fake://", true, false},
+		{AppModeServer, "openai://", false, false},
+		{AppModeServer, "", false, false},
+		{AppModeDescribe, "fake://", true, false},
+		{AppModeDescribe, "openai://", false, true},
+		{AppModeDescribe, "", false, true},
+		{AppModeQuery, legacy, false, false},
+	}
+	for _, tc := range tests {
+		h, err := henri.Init(t.Context(), henri.InitOptions{
+			DbPath:        filepath.Join(t.TempDir(), "henri.db"),
+			Describe:      tc.uri,
+			NeedDescriber: needsDescriber(tc.mode),
+			WantDescriber: wantsDescriber(tc.mode),
+		})
+		if tc.err {
+			if err == nil {
+				t.Errorf("Mode %d with %q: expected an error", tc.mode, tc.uri)
+				h.DB.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Mode %d with %q: unexpected error %s", tc.mode, tc.uri, err)
+			continue
+		}
+		if expected, actual := tc.want, h.Describer != nil; expected != actual {
+			t.Errorf("Mode %d with %q: expected a describer %t, got %t", tc.mode, tc.uri, expected, actual)
+		}
+		h.DB.Close()
+	}
+}
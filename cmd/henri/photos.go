@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+// Photos.sqlite is a Core Data store. Its table and join column names vary
+// between versions of Photos, so they are looked up rather than assumed.
+const (
+	photosDBPath     = "database/Photos.sqlite"
+	photosAlbumTable = "ZGENERICALBUM"
+	photosUserAlbum  = 2 // ZKIND of the albums made by the user
+)
+
+// coreDataEpoch is the zero time of Core Data timestamps.
+var coreDataEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// photosAsset is a photo in an Apple Photos library.
+type photosAsset struct {
+	pk        int
+	uuid      string
+	directory string
+	filename  string // of the original
+	takenAt   time.Time
+	favorite  bool
+
+	rendition *scanCandidate // the file scanned, nil if there is no JPEG
+	albums    []string
+	people    []string
+}
+
+// photosResult counts what importPhotosLibrary did.
+type photosResult struct {
+	Assets  int // photos in the library
+	Missing int // photos without a JPEG rendition
+	Added   int // new images
+	Albums  int // albums with a scanned photo
+	People  int // people in a scanned photo
+}
+
+// photosCommand runs henri photos <library_path>.
+func photosCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing Photos library path")
+	}
+	res, err := importPhotosLibrary(ctx, args[0], h.DB)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Read %d photos, %d have no JPEG rendition. Added %d new images in %d albums with %d people\n",
+		res.Assets, res.Missing, res.Added, res.Albums, res.People)
+	return nil
+}
+
+// importPhotosLibrary scans the Apple Photos library at library, reading its
+// Photos.sqlite database to find the best rendition of each photo. The albums,
+// favourites, capture dates and people of each photo are stored with it.
+// Hidden and deleted photos are skipped.
+func importPhotosLibrary(ctx context.Context, library string, db *henri.DB) (*photosResult, error) {
+	root, _, err := libraryRoot(ctx, library, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read only, Photos may have the database open
+	pdb, err := sql.Open("sqlite", "file:"+filepath.Join(root.Path, photosDBPath)+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer pdb.Close()
+
+	assets, err := readPhotosAssets(ctx, pdb)
+	if err != nil {
+		return nil, fmt.Errorf("reading photos - %w", err)
+	}
+	byPk := make(map[int]*photosAsset, len(assets))
+	for _, a := range assets {
+		byPk[a.pk] = a
+	}
+	if err := readPhotosAlbums(ctx, pdb, byPk); err != nil {
+		return nil, fmt.Errorf("reading albums - %w", err)
+	}
+	if err := readPhotosPeople(ctx, pdb, byPk); err != nil {
+		return nil, fmt.Errorf("reading people - %w", err)
+	}
+
+	res := &photosResult{Assets: len(assets)}
+	var candidates []scanCandidate
+	for _, a := range assets {
+		if a.rendition = photosRendition(root.Path, a); a.rendition == nil {
+			res.Missing++
+			continue
+		}
+		candidates = append(candidates, *a.rendition)
+	}
+
+	if res.Added, err = insertImageFiles(ctx, root, candidates, *minSize, db, nil); err != nil {
+		return res, err
+	}
+	ids, err := db.ImageIdsByPath(ctx, root.Id)
+	if err != nil {
+		return res, err
+	}
+
+	albums, people := map[string]bool{}, map[string]bool{}
+	for _, a := range assets {
+		if ctx.Err() != nil || lameduck {
+			return res, errors.New("interrupted while importing photos")
+		}
+		if a.rendition == nil {
+			continue
+		}
+		id, ok := ids[a.rendition.rel]
+		if !ok {
+			// Smaller than --min-size
+			continue
+		}
+
+		if err := db.SetImageMetadata(ctx, id, a.takenAt, a.favorite); err != nil {
+			return res, err
+		}
+		if err := db.SetImageLabels(ctx, id, henri.LabelAlbum, a.albums); err != nil {
+			return res, err
+		}
+		if err := db.SetImageLabels(ctx, id, henri.LabelPerson, a.people); err != nil {
+			return res, err
+		}
+		for _, name := range a.albums {
+			albums[name] = true
+		}
+		for _, name := range a.people {
+			people[name] = true
+		}
+	}
+	res.Albums, res.People = len(albums), len(people)
+
+	return res, nil
+}
+
+// photosTableColumns returns the columns of table, or none if it doesn't exist.
+func photosTableColumns(ctx context.Context, pdb *sql.DB, table string) ([]string, error) {
+	rows, err := pdb.QueryContext(ctx, `SELECT name FROM pragma_table_info($1)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// readPhotosAssets returns the photos that are neither hidden nor deleted.
+func readPhotosAssets(ctx context.Context, pdb *sql.DB) ([]*photosAsset, error) {
+	// Renamed from ZGENERICASSET in Photos 7
+	table := "ZASSET"
+	if columns, err := photosTableColumns(ctx, pdb, table); err != nil {
+		return nil, err
+	} else if len(columns) == 0 {
+		table = "ZGENERICASSET"
+	}
+
+	rows, err := pdb.QueryContext(ctx, `
+		SELECT Z_PK, ZUUID, ZDIRECTORY, ZFILENAME, ZDATECREATED, ZFAVORITE
+		FROM `+table+`
+		WHERE ZKIND=0 AND ZTRASHEDSTATE=0 AND ZHIDDEN=0
+		ORDER BY Z_PK`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []*photosAsset
+	for rows.Next() {
+		a := &photosAsset{}
+		var (
+			created  sql.NullFloat64
+			favorite sql.NullInt64
+		)
+		if err := rows.Scan(&a.pk, &a.uuid, &a.directory, &a.filename, &created, &favorite); err != nil {
+			return nil, err
+		}
+		if created.Valid {
+			a.takenAt = coreDataEpoch.Add(time.Duration(created.Float64 * float64(time.Second)))
+		}
+		a.favorite = favorite.Int64 != 0
+		assets = append(assets, a)
+	}
+	return assets, rows.Err()
+}
+
+var (
+	albumsColumn = regexp.MustCompile(`^Z_\d+ALBUMS$`)
+	assetsColumn = regexp.MustCompile(`^Z_\d+ASSETS$`)
+)
+
+// readPhotosAlbums adds the titles of the user's albums to the assets in them.
+func readPhotosAlbums(ctx context.Context, pdb *sql.DB, assets map[int]*photosAsset) error {
+	// Album membership is in a join table like Z_28ASSETS with columns like
+	// Z_28ALBUMS and Z_3ASSETS, numbered by entity
+	rows, err := pdb.QueryContext(ctx, `
+		SELECT name FROM sqlite_master
+		WHERE type='table' AND name GLOB 'Z_[0-9]*ASSETS'`)
+	if err != nil {
+		return err
+	}
+	tables, err := scanStrings(rows)
+	if err != nil {
+		return err
+	}
+
+	var joinTable, albumCol, assetCol string
+	for _, t := range tables {
+		columns, err := photosTableColumns(ctx, pdb, t)
+		if err != nil {
+			return err
+		}
+		a := slices.IndexFunc(columns, albumsColumn.MatchString)
+		b := slices.IndexFunc(columns, assetsColumn.MatchString)
+		if a >= 0 && b >= 0 {
+			joinTable, albumCol, assetCol = t, columns[a], columns[b]
+			break
+		}
+	}
+	if joinTable == "" {
+		return nil
+	}
+
+	rows, err = pdb.QueryContext(ctx, `
+		SELECT j.`+assetCol+`, a.ZTITLE
+		FROM `+joinTable+` j
+		INNER JOIN `+photosAlbumTable+` a ON a.Z_PK=j.`+albumCol+`
+		WHERE a.ZKIND=$1 AND a.ZTRASHEDSTATE=0 AND a.ZTITLE IS NOT NULL
+		ORDER BY a.ZTITLE`, photosUserAlbum)
+	if err != nil {
+		return err
+	}
+	return addAssetNames(rows, assets, func(a *photosAsset) *[]string { return &a.albums })
+}
+
+// readPhotosPeople adds the names of the people recognised in the assets.
+func readPhotosPeople(ctx context.Context, pdb *sql.DB, assets map[int]*photosAsset) error {
+	columns, err := photosTableColumns(ctx, pdb, "ZDETECTEDFACE")
+	if err != nil {
+		return err
+	}
+	// Renamed in Photos 9
+	assetCol := firstOf(columns, "ZASSETFORFACE", "ZASSET")
+	personCol := firstOf(columns, "ZPERSONFORFACE", "ZPERSON")
+	if assetCol == "" || personCol == "" {
+		return nil
+	}
+
+	rows, err := pdb.QueryContext(ctx, `
+		SELECT DISTINCT f.`+assetCol+`, COALESCE(NULLIF(p.ZFULLNAME, ''), p.ZDISPLAYNAME) AS name
+		FROM ZDETECTEDFACE f
+		INNER JOIN ZPERSON p ON p.Z_PK=f.`+personCol+`
+		WHERE name IS NOT NULL AND name != ''
+		ORDER BY name`)
+	if err != nil {
+		return err
+	}
+	return addAssetNames(rows, assets, func(a *photosAsset) *[]string { return &a.people })
+}
+
+// firstOf returns the first of names in columns, or "".
+func firstOf(columns []string, names ...string) string {
+	for _, name := range names {
+		if slices.Contains(columns, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// addAssetNames appends the names in rows of (asset pk, name) to the list of
+// each asset returned by list.
+func addAssetNames(rows *sql.Rows, assets map[int]*photosAsset, list func(*photosAsset) *[]string) error {
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			pk   int
+			name string
+		)
+		if err := rows.Scan(&pk, &name); err != nil {
+			return err
+		}
+		if a, ok := assets[pk]; ok {
+			names := list(a)
+			if !slices.Contains(*names, name) {
+				*names = append(*names, name)
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// scanStrings returns the single string column of rows.
+func scanStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var ss []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+	return ss, rows.Err()
+}
+
+// photosRendition returns the best JPEG rendition of a in the library at
+// library, as chosen by the apple-photos scan preset, or nil if there is none.
+func photosRendition(library string, a *photosAsset) *scanCandidate {
+	var paths []string
+	if ext := strings.ToLower(filepath.Ext(a.filename)); ext == ".jpg" || ext == ".jpeg" {
+		paths = append(paths, filepath.Join(library, "originals", a.directory, a.filename))
+	}
+	for _, dir := range []string{"resources/renders", "resources/derivatives"} {
+		matches, _ := filepath.Glob(filepath.Join(library, filepath.FromSlash(dir), a.directory, a.uuid+"_*"))
+		paths = append(paths, matches...)
+	}
+
+	var renditions []scanCandidate
+	for _, path := range paths {
+		if ext := strings.ToLower(filepath.Ext(path)); ext != ".jpg" && ext != ".jpeg" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(library, path)
+		if err != nil {
+			continue
+		}
+		renditions = append(renditions, scanCandidate{
+			path:    path,
+			rel:     filepath.ToSlash(rel),
+			modtime: info.ModTime(),
+			size:    info.Size(),
+		})
+	}
+	if len(renditions) == 0 {
+		return nil
+	}
+
+	best := slices.MinFunc(renditions, presets["apple-photos"].rank)
+	return &best
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestPhotosImport(t *testing.T) {
+	library := filepath.Join(t.TempDir(), "Photos Library.photoslibrary")
+	if err := os.MkdirAll(filepath.Join(library, "database"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := os.ReadFile(filepath.Join("testdata", "Photos.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdb, err := sql.Open("sqlite", filepath.Join(library, photosDBPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pdb.Exec(string(schema))
+	pdb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		path string
+		size int
+	}{
+		{"originals/A/AAAA-1111.jpeg", 32},
+		{"resources/renders/A/AAAA-1111_1_201_a.jpeg", 24},
+		{"originals/B/BBBB-2222.heic", 0},
+		{"resources/derivatives/B/BBBB-2222_1_105_c.jpeg", 16},
+		{"originals/C/CCCC-3333.jpeg", 32},
+		{"resources/derivatives/C/CCCC-3333_1_105_c.jpeg", 16},
+		{"originals/D/DDDD-4444.jpeg", 32},
+		{"originals/E/EEEE-5555.jpeg", 32},
+	}
+	for _, f := range files {
+		path := filepath.Join(library, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if f.size == 0 {
+			if err := os.WriteFile(path, []byte("not a jpeg"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		writeJPEG(t, path, f.size, f.size, color.RGBA{255, 0, 0, 255})
+	}
+
+	h := newTestHenri(t, AppModePipeline)
+
+	res, err := importPhotosLibrary(t.Context(), library, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := (photosResult{Assets: 4, Missing: 1, Added: 3, Albums: 2, People: 2}), *res; expected != actual {
+		t.Errorf("Expected %+v, got %+v", expected, actual)
+	}
+
+	// Importing again adds nothing
+	if res, err = importPhotosLibrary(t.Context(), library, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, res.Added; expected != actual {
+		t.Errorf("Expected %d new images, got %d", expected, actual)
+	}
+
+	roots, err := h.DB.Roots(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := h.DB.ImageIdsByPath(t.Context(), roots[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photos := []struct {
+		path     string
+		takenAt  time.Time
+		favorite bool
+	}{
+		{"resources/renders/A/AAAA-1111_1_201_a.jpeg", time.Date(2023, 12, 25, 9, 30, 0, 0, time.UTC), true},
+		{"resources/derivatives/B/BBBB-2222_1_105_c.jpeg", time.Date(2024, 7, 14, 18, 0, 0, 0, time.UTC), false},
+		{"originals/C/CCCC-3333.jpeg", time.Date(2024, 8, 2, 12, 0, 0, 0, time.UTC), true},
+	}
+	if expected, actual := len(photos), len(ids); expected != actual {
+		t.Fatalf("Expected %d images, got %v", expected, ids)
+	}
+	for _, p := range photos {
+		id, ok := ids[p.path]
+		if !ok {
+			t.Errorf("Expected %s to be scanned", p.path)
+			continue
+		}
+		img, err := h.DB.GetImage(t.Context(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := p.takenAt, img.TakenAt; !expected.Equal(actual) {
+			t.Errorf("%s: expected taken at %v, got %v", p.path, expected, actual)
+		}
+		if expected, actual := p.favorite, img.Favorite; expected != actual {
+			t.Errorf("%s: expected favorite %t, got %t", p.path, expected, actual)
+		}
+	}
+
+	for _, tc := range []struct {
+		kind   string
+		labels []string
+	}{
+		{henri.LabelAlbum, []string{"Christmas 1", "Holidays 2"}},
+		{henri.LabelPerson, []string{"Alex 1", "Sam Smith 2"}},
+	} {
+		labels, err := h.DB.Labels(t.Context(), tc.kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		for _, l := range labels {
+			actual = append(actual, fmt.Sprintf("%s %d", l.Name, l.Images))
+		}
+		if expected := tc.labels; !slices.Equal(expected, actual) {
+			t.Errorf("Expected %s labels %v, got %v", tc.kind, expected, actual)
+		}
+	}
+
+	if err := describeAndEmbed(t.Context(), h); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	tests := []struct {
+		query  string
+		status int
+		images int
+	}{
+		{"", http.StatusOK, 3},
+		{"&album=Holidays", http.StatusOK, 2},
+		{"&album=Holidays&person=Alex", http.StatusOK, 1},
+		{"&person=Sam+Smith", http.StatusOK, 2},
+		{"&favorites=true", http.StatusOK, 2},
+		{"&after=2024-01-01", http.StatusOK, 2},
+		{"&after=2024-01-01&before=2024-08-01", http.StatusOK, 1},
+		{"&album=Holidays&favorites=true", http.StatusOK, 1},
+		{"&album=Old", http.StatusBadRequest, 0},
+		{"&before=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(ts.URL + "/search?q=image" + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%s: expected status %d, got %d", tc.query, expected, actual)
+			continue
+		}
+		if expected, actual := tc.images, strings.Count(string(body), `src="/image/`); expected != actual {
+			t.Errorf("%s: expected %d results, got %d", tc.query, expected, actual)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, s := range []string{`value="Holidays"`, `value="Sam Smith"`, `name="favorites"`} {
+		if !strings.Contains(string(body), s) {
+			t.Errorf("Expected %s in the search filters, got %s", s, body)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+	"golang.org/x/sync/errgroup"
+)
+
+// pipelineCommand runs henri pipeline <library_path>.
+func pipelineCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing library path to scan")
+	}
+	return runPipeline(ctx, args[0], h)
+}
+
+// runPipeline scans library for new images and then describes and embeds
+// them. Images described by earlier runs but not yet embedded are embedded
+// first, so an interrupted pipeline resumes where it left off.
+func runPipeline(ctx context.Context, library string, h *henri.Henri) error {
+	added, err := findAndInsertImageFiles(ctx, library, h.DB)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added %d new images\n", added)
+	if lameduck {
+		return nil
+	}
+
+	return describeAndEmbed(ctx, h)
+}
+
+// describeAndEmbed describes every image lacking a description, handing each
+// described image to a second stage that computes its embedding. Images
+// described earlier but not yet embedded are embedded first. It prints nothing
+// if there is no work to do.
+func describeAndEmbed(ctx context.Context, h *henri.Henri) error {
+	backlog, err := h.DB.DescribedImagesMissingEmbeddings(ctx, h.Embedder.Model())
+	if err != nil {
+		return err
+	}
+	images, err := h.DB.ImagesToDescribe(ctx)
+	if err != nil {
+		return err
+	}
+	if *count > -1 {
+		images = images[:min(len(images), *count)]
+	}
+	if len(images) == 0 && len(backlog) == 0 {
+		return nil
+	}
+	cds, err := newCollectionDescribers(ctx, h)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d images to describe, %d described images to embed\n", len(images), len(backlog))
+	fmt.Printf("Using describer %s model %s, embedder %s model %s\n",
+		h.Describer.Name(), h.Describer.Model(), h.Embedder.Name(), h.Embedder.Model())
+
+	var described, embedded int
+
+	// The queue is buffered so the describer is not held up by a slow
+	// embedding, description is by far the slower stage.
+	queue := make(chan *henri.Image, 64)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		embedded, err = embedStage(gctx, h, backlog, queue)
+		return err
+	})
+	g.Go(func() error {
+		defer close(queue)
+
+		return processImages(gctx, images, cds.describeFn(h.DB), &termReporter{}, func(img *henri.Image) {
+			described++
+			select {
+			case queue <- img:
+			case <-gctx.Done():
+			}
+		})
+	})
+	err = g.Wait()
+
+	fmt.Printf("Described %d images, computed %d embeddings\n", described, embedded)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if embedded > 0 {
+		return updateSavedSearches(ctx, h.Embedder, h.DB)
+	}
+	return nil
+}
+
+// embedStage computes embeddings for the backlog of images, then for each
+// image received from queue until it is closed. Images already queued when
+// entering lameduck are still embedded, the backlog is abandoned. It returns
+// the number of embeddings computed.
+func embedStage(ctx context.Context, h *henri.Henri, backlog []*henri.Image, queue <-chan *henri.Image) (int, error) {
+	var n, errcnt int
+
+	embed := func(img *henri.Image) error {
+		err := withRetries(ctx, func() error { return calcEmbeddingFn(ctx, h.Embedder, img, h.DB) })
+		switch {
+		case err == nil:
+			n++
+			return nil
+		case errors.Is(err, context.Canceled), errors.Is(err, describer.ErrModelNotFound):
+			return err
+		}
+
+		fmt.Printf("Embedding <%d> error: %s\n", img.Id, err)
+		if errors.Is(err, describer.ErrContextLength) || errors.Is(err, describer.ErrBadResponse) {
+			// The backend can't handle this description, skip it
+			return nil
+		}
+		errcnt++
+		if errcnt >= 5 {
+			return fmt.Errorf("too many embedding errors - %w", err)
+		}
+		return nil
+	}
+
+	for _, img := range backlog {
+		if lameduck {
+			break
+		}
+		if err := embed(img); err != nil {
+			return n, err
+		}
+	}
+
+	for img := range queue {
+		if err := embed(img); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
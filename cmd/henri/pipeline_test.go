@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestRunPipeline(t *testing.T) {
+	library := newTestLibrary(t, 2)
+	h := newTestHenri(t, AppModePipeline)
+
+	// Describe one image ahead of time, as if an earlier run was interrupted
+	// before it was embedded.
+	if _, err := findAndInsertImageFiles(t.Context(), library, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	images, err := h.DB.ImagesToDescribe(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := describeImageFn(t.Context(), h.Describer, images[0], h.DB, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertDone := func() {
+		t.Helper()
+
+		images, err := h.DB.ImagesToDescribe(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(images) != 0 {
+			t.Errorf("Expected all images to be described, %d remaining", len(images))
+		}
+		images, err = h.DB.DescribedImagesMissingEmbeddings(t.Context(), h.Embedder.Model())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(images) != 0 {
+			t.Errorf("Expected all images to have embeddings, %d remaining", len(images))
+		}
+	}
+
+	if err := runPipeline(t.Context(), library, h); err != nil {
+		t.Fatalf("Unexpected pipeline error %s", err)
+	}
+	assertDone()
+
+	// New photos are picked up by the next run
+	writeJPEG(t, filepath.Join(library, "c.jpg"), 10, 8, testColors[2])
+	if err := runPipeline(t.Context(), library, h); err != nil {
+		t.Fatalf("Unexpected pipeline error %s", err)
+	}
+	assertDone()
+
+	ids, err := h.DB.EmbeddingIdsForModel(t.Context(), h.Embedder.Model(), henri.ImageFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 3, len(ids); expected != actual {
+		t.Errorf("Expected %d embeddings, got %d", expected, actual)
+	}
+}
@@ -2,7 +2,14 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/chriskillpack/henri"
@@ -36,21 +43,113 @@ func computeCosineSimilarity(a, b []float32) (float32, error) {
 		return 0, nil
 	}
 
-	return dot / (ma * mb), nil
+	return dot / float32(math.Sqrt(float64(ma)*float64(mb))), nil
 }
 
-func runQuery(query string, d describer.Describer, db *henri.DB) error {
+// imageFilter returns the filter for the search params in v. These are
+// collection, album and person, which may be repeated, favorites, after and
+// before dates such as 2024-06-30, and the description set to search as
+// descriptions=model or model@prompt-version.
+func imageFilter(ctx context.Context, db *henri.DB, v url.Values) (henri.ImageFilter, error) {
+	var (
+		filter henri.ImageFilter
+		err    error
+	)
+
+	if filter.Collections, err = collectionIds(ctx, db, v["collection"]); err != nil {
+		return filter, err
+	}
+	for _, kind := range []string{henri.LabelAlbum, henri.LabelPerson, henri.LabelKeyword} {
+		names := v[kind]
+		if len(names) == 0 {
+			continue
+		}
+		labels, err := db.Labels(ctx, kind)
+		if err != nil {
+			return filter, err
+		}
+		for _, name := range names {
+			i := slices.IndexFunc(labels, func(l *henri.Label) bool { return l.Name == name })
+			if i < 0 {
+				return filter, fmt.Errorf("no %s %q", kind, name)
+			}
+			filter.Labels = append(filter.Labels, labels[i].Id)
+		}
+	}
+
+	if fav := v.Get("favorites"); fav != "" {
+		if filter.Favorites, err = strconv.ParseBool(fav); err != nil {
+			return filter, fmt.Errorf("invalid favorites %q", fav)
+		}
+	}
+	for _, d := range []struct {
+		param string
+		t     *time.Time
+	}{{"after", &filter.TakenAfter}, {"before", &filter.TakenBefore}} {
+		if s := v.Get(d.param); s != "" {
+			if *d.t, err = time.ParseInLocation(time.DateOnly, s, time.Local); err != nil {
+				return filter, fmt.Errorf("invalid %s date %q, expected YYYY-MM-DD", d.param, s)
+			}
+		}
+	}
+
+	if s := v.Get("descriptions"); s != "" {
+		if filter.Descriptions, err = descriptionSetNamed(ctx, db, s); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+// descriptionSetNamed returns the description set written as model, or as
+// model@version for a prompt other than the default.
+func descriptionSetNamed(ctx context.Context, db *henri.DB, name string) (henri.DescriptionSet, error) {
+	sets, err := db.DescriptionSets(ctx)
+	if err != nil {
+		return henri.DescriptionSet{}, err
+	}
+	i := slices.IndexFunc(sets, func(ds *henri.DescriptionSet) bool { return ds.String() == name })
+	if i < 0 {
+		names := make([]string, len(sets))
+		for i, ds := range sets {
+			names[i] = ds.String()
+		}
+		return henri.DescriptionSet{}, fmt.Errorf("no descriptions by %q, there are %s", name, strings.Join(names, ", "))
+	}
+	return henri.DescriptionSet{Model: sets[i].Model, PromptVersion: sets[i].PromptVersion}, nil
+}
+
+var filterParams = flag.String("filter", "", "Search filters, e.g. album=Holidays&person=Sam&favorites=true&after=2024-01-01")
+
+// filterFlags returns the search params given by the --filter and
+// --collection flags.
+func filterFlags() (url.Values, error) {
+	v, err := url.ParseQuery(*filterParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter - %w", err)
+	}
+	for _, name := range splitNames(*collection) {
+		v.Add("collection", name)
+	}
+	return v, nil
+}
+
+// runQuery prints the images best matching query, parsed as cq, searching
+// only the images matched by filter. The matches are diversified with lambda,
+// and if r is not nil the best are re-ranked by it.
+func runQuery(query string, cq *compoundQuery, filter henri.ImageFilter, lambda float64, d describer.TextEmbedder, r describer.Reranker, db *henri.DB) error {
 	ctx := context.Background()
 
-	// First things first, convert the query into an embedding queryvec
+	// First things first, convert the query into embeddings to score with
 	fmt.Printf("Computing query embedding vector...\n")
-	queryvec, err := d.Embeddings(ctx, query)
+	score, err := queryScorer(ctx, query, cq, d, db)
 	if err != nil {
 		return err
 	}
 
 	// Get a count of the number of embeddings that match this model
-	eids, err := db.EmbeddingIdsForModel(ctx, d.Model())
+	eids, err := db.EmbeddingIdsForModel(ctx, d.Model(), filter)
 	if err != nil {
 		return err
 	}
@@ -63,7 +162,11 @@ func runQuery(query string, d describer.Describer, db *henri.DB) error {
 		progressbar.OptionOnCompletion(func() { fmt.Println() }),
 	)
 
-	top5 := NewTopKTracker(5)
+	n := 5
+	if r != nil {
+		n = max(n, *rerankN)
+	}
+	topk := NewTopKTracker(searchPool(n, lambda))
 
 	// Iterate over the embeddings scoring each one
 	var errcnt int
@@ -80,22 +183,21 @@ func runQuery(query string, d describer.Describer, db *henri.DB) error {
 			continue
 		}
 
-		// Compute the cosine similarity of this embeddeding with the query
-		// embedding.
-		var score float32
-		score, err = computeCosineSimilarity(queryvec, embed.Vector)
+		// Score this embedding against the query embeddings
+		var sim float32
+		sim, err = score(embed.Vector)
 
-		top5.ProcessItem(embed, score)
+		topk.ProcessItem(embed, sim)
 
 		bar.Add(1)
 	}
 	bar.Finish()
 
 	// Get top results
-	topes := top5.GetTopK()
+	topes := topk.GetTopK()
 
 	// Extract the embed ids
-	embedids := make([]int, top5.k)
+	embedids := make([]int, len(topes))
 	for i, es := range topes {
 		embedids[i] = es.embed.Id
 	}
@@ -104,13 +206,30 @@ func runQuery(query string, d describer.Describer, db *henri.DB) error {
 	if err != nil {
 		return err
 	}
-
-	// Iterate over the top 5 again and print out stuff we care about
 	for i, es := range topes {
 		emb := embeddings[es.embed.Id]
+		emb.Vector = es.embed.Vector // for diversifying
+		topes[i].embed = emb
+	}
+	topes = diversify(topes, n, lambda)
 
-		fmt.Printf("Idx %d    Score=%0.5f\nPath=%q\nDescription=%q\n", i+1, es.score, emb.Image.Path, emb.Image.Description)
-		if i < len(topes)-1 {
+	ranked := unranked(topes)
+	if r != nil {
+		fmt.Printf("Re-ranking %d results with %s...\n", len(topes), r.Model())
+		if ranked, err = rerank(ctx, r, query, topes, 0, db); err != nil {
+			fmt.Fprintf(os.Stderr, "Re-ranking failed, results not rated keep their order - %s\n", err)
+		}
+	}
+	ranked = ranked[:min(5, len(ranked))]
+
+	// Iterate over the top 5 again and print out stuff we care about
+	for i, res := range ranked {
+		fmt.Printf("Idx %d    Score=%0.5f", i+1, res.score)
+		if res.rated {
+			fmt.Printf("    Relevance=%0.2f", res.relevance)
+		}
+		fmt.Printf("\nPath=%q\nDescription=%q\n", res.embed.Image.Path, res.embed.Image.Description)
+		if i < len(ranked)-1 {
 			fmt.Println("==========")
 		}
 	}
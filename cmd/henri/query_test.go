@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     []float32
+		expected float32
+	}{
+		{[]float32{1, 0}, []float32{1, 0}, 1},
+		{[]float32{3, 4}, []float32{6, 8}, 1},
+		{[]float32{3, 4}, []float32{-3, -4}, -1},
+		{[]float32{2, 0}, []float32{0, 5}, 0},
+		{[]float32{1, 1}, []float32{0, 3}, float32(1 / math.Sqrt2)},
+		{[]float32{0, 0}, []float32{1, 2}, 0},
+	}
+	for _, tc := range tests {
+		actual, err := computeCosineSimilarity(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+		if math.Abs(float64(tc.expected-actual)) > 1e-6 {
+			t.Errorf("%v, %v: expected %v, got %v", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+
+	if _, err := computeCosineSimilarity([]float32{1}, []float32{1, 2}); err == nil {
+		t.Errorf("Expected an error for vectors of different lengths")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"slices"
+	"time"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+var (
+	rerankWith   = flag.String("reranker", "", "Backend URI of a text model to re-rank search results with, e.g. ollama://localhost:11434?model=llama3.2")
+	rerankN      = flag.Int("rerank", 20, "Number of the best search results to re-rank")
+	rerankBudget = flag.Duration("rerank-budget", 2*time.Second, "Time the server spends re-ranking a search, results not rated in time keep their order")
+)
+
+// rankedResult is a search result with the relevance of its description to
+// the query, when the re-ranker rated it.
+type rankedResult struct {
+	embedscore
+	relevance float32
+	rated     bool
+}
+
+// unranked returns the results in their order by embedding similarity.
+func unranked(results []embedscore) []rankedResult {
+	ranked := make([]rankedResult, len(results))
+	for i, es := range results {
+		ranked[i].embedscore = es
+	}
+	return ranked
+}
+
+// rerank orders results, which must have their images, by the relevance of
+// their descriptions to query as rated by r. Ratings are cached in db.
+// Results are rated best first until budget runs out, a zero budget is no
+// limit, and those left unrated follow the rated ones in their original order.
+// If r fails the results rated so far are returned with the error.
+func rerank(ctx context.Context, r describer.Reranker, query string, results []embedscore, budget time.Duration, db *henri.DB) ([]rankedResult, error) {
+	ranked := unranked(results)
+	query = normalQuery(query)
+
+	descriptions := make([]string, len(results))
+	for i, es := range results {
+		descriptions[i] = es.embed.Image.Description
+	}
+	cached, err := db.CachedRelevance(ctx, query, r.Model(), descriptions)
+	if err != nil {
+		return ranked, err
+	}
+
+	rctx := ctx
+	if budget > 0 {
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	for i := range ranked {
+		desc := descriptions[i]
+		if score, ok := cached[desc]; ok {
+			ranked[i].relevance, ranked[i].rated = score, true
+			continue
+		}
+		if rctx.Err() != nil {
+			continue // out of time, but later results may be cached
+		}
+
+		score, err := r.Relevance(rctx, query, desc)
+		if rctx.Err() != nil {
+			continue
+		} else if err != nil {
+			sortRanked(ranked)
+			return ranked, err
+		}
+		ranked[i].relevance, ranked[i].rated = score, true
+		cached[desc] = score
+		if err := db.CacheRelevance(ctx, query, r.Model(), desc, score, time.Now()); err != nil {
+			sortRanked(ranked)
+			return ranked, err
+		}
+	}
+
+	sortRanked(ranked)
+	return ranked, nil
+}
+
+// sortRanked puts the rated results first, most relevant first, breaking ties
+// by similarity. The unrated results keep their order.
+func sortRanked(ranked []rankedResult) {
+	slices.SortStableFunc(ranked, func(a, b rankedResult) int {
+		if a.rated != b.rated {
+			if a.rated {
+				return -1
+			}
+			return 1
+		}
+		if !a.rated {
+			return 0
+		}
+		return cmp.Or(cmp.Compare(b.relevance, a.relevance), cmp.Compare(b.score, a.score))
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+// countingReranker counts the ratings it is asked for, taking delay over each.
+type countingReranker struct {
+	describer.Reranker
+	delay time.Duration
+	calls int
+}
+
+func (cr *countingReranker) Relevance(ctx context.Context, query, description string) (float32, error) {
+	cr.calls++
+	select {
+	case <-time.After(cr.delay):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	return cr.Reranker.Relevance(ctx, query, description)
+}
+
+func TestRerank(t *testing.T) {
+	h := indexTestLibrary(t, newTestLibrary(t, 3))
+	b, err := describer.Open("fake://", http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := &countingReranker{Reranker: b.(describer.Reranker)}
+
+	// A query of the last words of an image's description rates it highest
+	img, err := h.DB.GetImage(t.Context(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := strings.Fields(strings.TrimSuffix(img.Description, "."))
+	query := strings.Join(words[len(words)-3:], " ")
+
+	var results []embedscore
+	batchCh, errCh := h.DB.EmbeddingsForModel(t.Context(), h.Embedder.Model(), 0, henri.ImageFilter{})
+	for batch := range batchCh {
+		for _, emb := range batch.Embeds {
+			results = append(results, embedscore{emb, float32(len(results))})
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	ranked, err := rerank(t.Context(), cr, query, results, 0, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 3, ranked[0].embed.ImageId; expected != actual {
+		t.Errorf("Expected image %d first, got %d", expected, actual)
+	}
+	if !ranked[0].rated || ranked[0].relevance != 1 {
+		t.Errorf("Expected the first result rated 1, got %+v", ranked[0])
+	}
+	if expected, actual := 3, cr.calls; expected != actual {
+		t.Errorf("Expected %d ratings, got %d", expected, actual)
+	}
+
+	// Ratings are cached
+	if _, err := rerank(t.Context(), cr, strings.ToUpper(query), results, 0, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 3, cr.calls; expected != actual {
+		t.Errorf("Expected cached ratings, got %d ratings", actual)
+	}
+
+	// Results not rated in the budget keep their order
+	cr.delay = time.Second
+	ranked, err = rerank(t.Context(), cr, "something else", results, 10*time.Millisecond, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range ranked {
+		if res.rated || res.embed != results[i].embed {
+			t.Errorf("Expected result %d unrated in its order, got %+v", i, res)
+		}
+	}
+
+	cr.delay = 0
+	srv := NewServer(h, "0")
+	srv.r = cr
+	ts := httptest.NewServer(srv.serveHandler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/search?" + url.Values{"q": {query}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if expected, actual := 3, strings.Count(string(body), "LLM "); expected != actual {
+		t.Errorf("Expected %d relevance scores, got %d", expected, actual)
+	}
+	if !strings.Contains(string(body), "LLM 1.00") {
+		t.Errorf("Expected the relevance of image 3 in %s", body)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chriskillpack/henri"
+)
+
+var rootName = flag.String("root", "", "Name of the library root when scanning, default is the library directory name")
+
+// libraryRoot returns the library root for the library at path, and its
+// collection or nil if it is not in one. The root is named by the --root flag,
+// or the base name of path. If the --collection flag is set the root is moved
+// into that collection, which is created if needed.
+func libraryRoot(ctx context.Context, path string, db *henri.DB) (*henri.Root, *henri.Collection, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := db.EnsureRoot(ctx, cmp.Or(*rootName, filepath.Base(abs)), abs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *collection != "" {
+		if strings.Contains(*collection, ",") {
+			return nil, nil, fmt.Errorf("a library can only be scanned into one collection, not %s", *collection)
+		}
+		c, err := db.EnsureCollection(ctx, *collection)
+		if err != nil {
+			return nil, nil, err
+		}
+		if root.CollectionId != c.Id {
+			if err := db.SetRootCollection(ctx, root.Id, c.Id); err != nil {
+				return nil, nil, err
+			}
+			root.CollectionId = c.Id
+		}
+		return root, c, nil
+	}
+
+	if root.CollectionId == 0 {
+		return root, nil, nil
+	}
+	collections, err := db.Collections(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := slices.IndexFunc(collections, func(c *henri.Collection) bool { return c.Id == root.CollectionId })
+	if i < 0 {
+		return nil, nil, fmt.Errorf("library root %q is in a missing collection", root.Name)
+	}
+	return root, collections[i], nil
+}
+
+// relocateCommand runs henri relocate <root> <path>.
+func relocateCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing library root or new path")
+	}
+	return relocateRoot(ctx, args[0], args[1], h.DB)
+}
+
+// relocateRoot moves the library root called name to path.
+func relocateRoot(ctx context.Context, name, path string, db *henri.DB) error {
+	roots, err := db.Roots(ctx)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(roots, func(r *henri.Root) bool { return r.Name == name })
+	if i < 0 {
+		names := make([]string, len(roots))
+		for i, r := range roots {
+			names[i] = r.Name
+		}
+		return fmt.Errorf("no library root %q, roots are %s", name, strings.Join(names, ", "))
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(abs); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", abs)
+	}
+
+	if err := db.RelocateRoot(ctx, name, abs); err != nil {
+		return err
+	}
+	fmt.Printf("Moved library root %q from %s to %s\n", name, roots[i].Path, abs)
+	return nil
+}
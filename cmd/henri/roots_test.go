@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelocate(t *testing.T) {
+	library := filepath.Join(t.TempDir(), "photos")
+	if err := os.Mkdir(library, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeJPEG(t, filepath.Join(library, "a.jpg"), 16, 8, color.RGBA{255, 0, 0, 255})
+
+	h := indexTestLibrary(t, library)
+
+	// The library is mounted somewhere else
+	moved := filepath.Join(t.TempDir(), "mnt")
+	if err := os.Rename(library, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := relocateRoot(t.Context(), "videos", moved, h.DB); err == nil {
+		t.Errorf("Expected an error relocating a missing root")
+	}
+	if err := relocateRoot(t.Context(), "photos", moved, h.DB); err != nil {
+		t.Fatalf("Unexpected relocate error %s", err)
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/image/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+		t.Errorf("Expected status %d, got %d", expected, actual)
+	}
+
+	// Nothing needs scanning or describing again
+	*rootName = "photos"
+	defer func() { *rootName = "" }()
+	n, err := findAndInsertImageFiles(t.Context(), moved, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, n; expected != actual {
+		t.Errorf("Expected %d new images, got %d", expected, actual)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+var (
+	presetName  = flag.String("preset", "", "Scan rules for a kind of library, apple-photos picks one rendition per photo")
+	scanHidden  = flag.Bool("hidden", false, "Scan hidden files and directories")
+	followLinks = flag.Bool("follow-symlinks", false, "Follow symbolic links when scanning")
+	minSize     = flag.Int("min-size", 0, "Minimum width and height in pixels of the images to scan")
+)
+
+// ignoreFile is read from the top of a library root for more exclude patterns.
+const ignoreFile = ".henriignore"
+
+// pattern is a gitignore style glob pattern. Patterns containing a slash, other
+// than a trailing one, match paths relative to the library root. The others
+// match a name at any depth. ** matches any number of directories, a trailing
+// slash only matches directories and a leading ! negates the pattern.
+type pattern struct {
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	segs     []string
+}
+
+// parsePattern parses s. It returns false for blank lines and # comments.
+func parsePattern(s string) (pattern, bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.HasPrefix(s, "#") {
+		return pattern{}, false, nil
+	}
+
+	var p pattern
+	if rest, ok := strings.CutPrefix(s, "!"); ok {
+		p.negate, s = true, rest
+	}
+	if rest, ok := strings.CutSuffix(s, "/"); ok {
+		p.dirOnly, s = true, rest
+	}
+	p.anchored = strings.Contains(s, "/")
+	p.segs = strings.Split(strings.TrimPrefix(s, "/"), "/")
+	for _, seg := range p.segs {
+		if _, err := path.Match(seg, ""); err != nil {
+			return pattern{}, false, fmt.Errorf("invalid pattern %q - %w", s, err)
+		}
+	}
+	return p, true, nil
+}
+
+// parsePatterns parses a list of patterns, skipping blanks and comments.
+func parsePatterns(lines []string) ([]pattern, error) {
+	var patterns []pattern
+	for _, line := range lines {
+		p, ok, err := parsePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, nil
+}
+
+// match returns whether p matches the slash separated path rel.
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	if p.anchored {
+		return matchSegs(p.segs, parts)
+	}
+	for i := range parts {
+		if matchSegs(p.segs, parts[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegs(segs, parts []string) bool {
+	if len(segs) == 0 {
+		return len(parts) == 0
+	}
+	if segs[0] == "**" {
+		for i := range len(parts) + 1 {
+			if matchSegs(segs[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(segs[0], parts[0])
+	return ok && matchSegs(segs[1:], parts[1:])
+}
+
+// matchPatterns returns whether rel matches patterns, where the last matching
+// pattern decides and negated patterns don't match.
+func matchPatterns(patterns []pattern, rel string, isDir bool) bool {
+	matched := false
+	for _, p := range patterns {
+		if p.match(rel, isDir) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+// scanPreset holds the scan rules for a kind of library.
+type scanPreset struct {
+	include []string
+	exclude []string
+
+	// asset returns the key of the asset the image at rel is a rendition of.
+	// If two images have the same key only the one that rank orders first is
+	// scanned.
+	asset func(rel string) string
+	rank  func(a, b scanCandidate) int
+}
+
+var presets = map[string]scanPreset{
+	// An Apple Photos library keeps the originals, edited renders and
+	// resized derivatives of every asset in files named by the asset's
+	// UUID, plus caches, face crops and thumbnails.
+	"apple-photos": {
+		include: []string{"/originals/", "/resources/renders/", "/resources/derivatives/"},
+		exclude: []string{"/resources/derivatives/masters/"},
+		asset: func(rel string) string {
+			name := path.Base(rel)
+			if i := strings.IndexAny(name, "_."); i >= 0 {
+				name = name[:i]
+			}
+			return strings.ToUpper(name)
+		},
+		// The edit if there is one, then the original, then the largest
+		// derivative
+		rank: func(a, b scanCandidate) int {
+			order := func(rel string) int {
+				switch {
+				case strings.HasPrefix(rel, "resources/renders/"):
+					return 0
+				case strings.HasPrefix(rel, "originals/"):
+					return 1
+				}
+				return 2
+			}
+			if c := order(a.rel) - order(b.rel); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.size, a.size)
+		},
+	},
+}
+
+// scanRules decides which files in a library root are scanned.
+type scanRules struct {
+	include []pattern
+	exclude []pattern
+	hidden  bool // scan hidden files and directories
+	follow  bool // follow symbolic links
+	minSize int  // minimum width and height in pixels
+	preset  *scanPreset
+}
+
+// newScanRules returns the rules for scanning root, which is in the collection
+// c or none if c is nil. They combine the root's ignore file, c's patterns and
+// the command line flags.
+func newScanRules(root *henri.Root, c *henri.Collection) (*scanRules, error) {
+	sr := &scanRules{hidden: *scanHidden, follow: *followLinks, minSize: *minSize}
+
+	var include, exclude []string
+	if *presetName != "" {
+		preset, ok := presets[*presetName]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q", *presetName)
+		}
+		sr.preset = &preset
+		include = append(include, preset.include...)
+		exclude = append(exclude, preset.exclude...)
+	}
+	if c != nil {
+		include = append(include, c.Include...)
+		exclude = append(exclude, c.Exclude...)
+	}
+	include = append(include, includePatterns...)
+	exclude = append(exclude, excludePatterns...)
+
+	lines, err := readLines(filepath.Join(root.Path, ignoreFile))
+	if err != nil {
+		return nil, err
+	}
+	exclude = append(exclude, lines...)
+
+	if sr.include, err = parsePatterns(include); err != nil {
+		return nil, err
+	}
+	if sr.exclude, err = parsePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// readLines returns the lines of the file at path, or none if it doesn't exist.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines, sc.Err()
+}
+
+// skipDir returns whether the directory at rel, relative to the library root,
+// should not be walked.
+func (sr *scanRules) skipDir(rel string) bool {
+	if !sr.hidden && strings.HasPrefix(path.Base(rel), ".") {
+		return true
+	}
+	return matchPatterns(sr.exclude, rel, true)
+}
+
+// wantFile returns whether the file at rel, relative to the library root,
+// should be scanned. Its directories have already been checked by skipDir. A
+// file is included if it, or one of its directories, matches an include
+// pattern.
+func (sr *scanRules) wantFile(rel string) bool {
+	if !sr.hidden && strings.HasPrefix(path.Base(rel), ".") {
+		return false
+	}
+	if matchPatterns(sr.exclude, rel, false) {
+		return false
+	}
+	if len(sr.include) == 0 {
+		return true
+	}
+	if matchPatterns(sr.include, rel, false) {
+		return true
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if matchPatterns(sr.include, dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanCandidate is an image file found by walking a library root.
+type scanCandidate struct {
+	path    string // absolute
+	rel     string // slash separated, relative to the library root
+	modtime time.Time
+	size    int64
+	seen    bool // already scanned by a previous walk
+}
+
+// pick returns the candidates to scan, which is all of them unless the preset
+// keeps one rendition per asset. That is the best ranked rendition that isn't
+// smaller than minSize. Candidates for assets with a rendition that was
+// already seen are left out.
+func (sr *scanRules) pick(candidates []scanCandidate) []scanCandidate {
+	if sr.preset == nil {
+		return slices.DeleteFunc(candidates, func(c scanCandidate) bool { return c.seen })
+	}
+
+	assets := map[string][]scanCandidate{}
+	var keys []string
+	for _, c := range candidates {
+		key := sr.preset.asset(c.rel)
+		if _, ok := assets[key]; !ok {
+			keys = append(keys, key)
+		}
+		assets[key] = append(assets[key], c)
+	}
+
+	var picked []scanCandidate
+	for _, key := range keys {
+		renditions := assets[key]
+		if slices.ContainsFunc(renditions, func(c scanCandidate) bool { return c.seen }) {
+			continue
+		}
+		if sr.minSize > 0 {
+			renditions = slices.DeleteFunc(renditions, sr.tooSmall)
+			if len(renditions) == 0 {
+				continue
+			}
+		}
+		picked = append(picked, slices.MinFunc(renditions, sr.preset.rank))
+	}
+	return picked
+}
+
+// tooSmall returns whether c is smaller than minSize. Candidates whose size
+// can't be read are not, the error is reported when they are scanned.
+func (sr *scanRules) tooSmall(c scanCandidate) bool {
+	w, h, err := imageSize(c.path)
+	return err == nil && (w < sr.minSize || h < sr.minSize)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{nil, "a.jpg", false, false},
+		{[]string{"*.jpg"}, "2024/a.jpg", false, true},
+		{[]string{"*-thumb.jpg"}, "2024/a.jpg", false, false},
+		{[]string{"2024/*"}, "2024/a.jpg", false, true},
+		{[]string{"2024/*"}, "old/2024/a.jpg", false, false},
+		{[]string{"2024"}, "old/2024", true, true},
+		{[]string{"/2024"}, "old/2024", true, false},
+		{[]string{"cache/"}, "cache", false, false},
+		{[]string{"cache/"}, "a/cache", true, true},
+		{[]string{"**/faces/*.jpg"}, "a/b/faces/1.jpg", false, true},
+		{[]string{"a/**/1.jpg"}, "a/1.jpg", false, true},
+		{[]string{"*.jpg", "!keep.jpg"}, "keep.jpg", false, false},
+		{[]string{"*.jpg", "!keep.jpg", "k*"}, "keep.jpg", false, true},
+		{[]string{"# comment", ""}, "# comment", false, false},
+	}
+	for _, tc := range tests {
+		patterns, err := parsePatterns(tc.patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := matchPatterns(patterns, tc.path, tc.isDir); tc.expected != actual {
+			t.Errorf("Expected %v matching %q against %v, got %v", tc.expected, tc.path, tc.patterns, actual)
+		}
+	}
+}
+
+func TestScanRules(t *testing.T) {
+	library := filepath.Join(t.TempDir(), "library")
+	elsewhere := t.TempDir()
+	for _, dir := range []string{".cache", "faces", "2024"} {
+		if err := os.MkdirAll(filepath.Join(library, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.jpg", ".cache/b.jpg", "faces/c.jpg", "2024/d.jpg"} {
+		writeJPEG(t, filepath.Join(library, name), 16, 16, color.RGBA{255, 0, 0, 255})
+	}
+	writeJPEG(t, filepath.Join(library, "2024", "small.jpg"), 4, 4, color.RGBA{0, 255, 0, 255})
+	writeJPEG(t, filepath.Join(elsewhere, "e.jpg"), 16, 16, color.RGBA{0, 0, 255, 255})
+	if err := os.Symlink(elsewhere, filepath.Join(library, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(library, filepath.Join(library, "2024", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(library, ignoreFile), []byte("# face crops\nfaces/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		hidden   bool
+		follow   bool
+		minSize  int
+		exclude  []string
+		expected []string
+	}{
+		{"defaults", false, false, 0, nil, []string{"2024/d.jpg", "2024/small.jpg", "a.jpg"}},
+		{"hidden", true, false, 0, nil, []string{".cache/b.jpg", "2024/d.jpg", "2024/small.jpg", "a.jpg"}},
+		{"follow", false, true, 0, nil, []string{"2024/d.jpg", "2024/small.jpg", "a.jpg", "linked/e.jpg"}},
+		{"min size", false, false, 8, nil, []string{"2024/d.jpg", "a.jpg"}},
+		{"exclude", false, false, 0, []string{"/2024/"}, []string{"a.jpg"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			*scanHidden, *followLinks, *minSize, excludePatterns = tc.hidden, tc.follow, tc.minSize, tc.exclude
+			defer func() { *scanHidden, *followLinks, *minSize, excludePatterns = false, false, 0, nil }()
+
+			db, err := henri.NewDB(t.Context(), ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if _, err := findAndInsertImageFiles(t.Context(), library, db); err != nil {
+				t.Fatal(err)
+			}
+
+			paths, err := db.ImagePaths(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rels []string
+			for _, p := range paths {
+				rels = append(rels, strings.TrimPrefix(p, library+"/"))
+			}
+			slices.Sort(rels)
+			if expected, actual := tc.expected, rels; !slices.Equal(expected, actual) {
+				t.Errorf("Expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestApplePhotosPreset(t *testing.T) {
+	library := filepath.Join(t.TempDir(), "Photos Library.photoslibrary")
+	const (
+		edited   = "AAAA-1111"
+		original = "BBBB-2222"
+		derived  = "CCCC-3333"
+	)
+	files := []struct {
+		path string
+		size int
+	}{
+		{"originals/A/" + edited + ".jpeg", 32},
+		{"resources/renders/A/" + edited + "_1_201_a.jpeg", 24},
+		{"resources/derivatives/A/" + edited + "_1_105_c.jpeg", 16},
+		{"originals/B/" + original + ".jpeg", 32},
+		{"resources/derivatives/B/" + original + "_1_105_c.jpeg", 16},
+		{"resources/derivatives/C/" + derived + "_1_100_o.jpeg", 8},
+		{"resources/derivatives/C/" + derived + "_1_105_c.jpeg", 24},
+		{"resources/derivatives/masters/C/" + derived + "_4_5005_c.jpeg", 64},
+		{"private/com.apple.photoanalysisd/faces/" + derived + ".jpeg", 8},
+	}
+	for _, f := range files {
+		path := filepath.Join(library, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeJPEG(t, path, f.size, f.size, color.RGBA{255, 0, 0, 255})
+	}
+
+	*presetName = "apple-photos"
+	defer func() { *presetName = "" }()
+
+	db, err := henri.NewDB(t.Context(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A second scan, as the watcher does, finds nothing new
+	seen := map[string]bool{}
+	for _, expected := range []int{3, 0} {
+		n, err := scanImageFiles(t.Context(), library, db, seen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := n; expected != actual {
+			t.Errorf("Expected %d new images, got %d", expected, actual)
+		}
+	}
+
+	paths, err := db.ImagePaths(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []string
+	for _, p := range paths {
+		rels = append(rels, strings.TrimPrefix(p, library+"/"))
+	}
+	slices.Sort(rels)
+	if expected, actual := []string{
+		"originals/B/" + original + ".jpeg",
+		"resources/derivatives/C/" + derived + "_1_105_c.jpeg",
+		"resources/renders/A/" + edited + "_1_201_a.jpeg",
+	}, rels; !slices.Equal(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	// The best rendition too small to scan gives way to the next
+	*minSize = 28
+	defer func() { *minSize = 0 }()
+
+	db, err = henri.NewDB(t.Context(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := scanImageFiles(t.Context(), library, db, nil); err != nil {
+		t.Fatal(err)
+	}
+	if paths, err = db.ImagePaths(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	rels = rels[:0]
+	for _, p := range paths {
+		rels = append(rels, strings.TrimPrefix(p, library+"/"))
+	}
+	slices.Sort(rels)
+	if expected, actual := []string{
+		"originals/A/" + edited + ".jpeg",
+		"originals/B/" + original + ".jpeg",
+	}, rels; !slices.Equal(expected, actual) {
+		t.Errorf("Expected %v with --min-size, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/chriskillpack/henri"
+	"github.com/chriskillpack/henri/describer"
+)
+
+var threshold = flag.Float64("threshold", 0.5, "Least similarity of the images in a saved search")
+
+// saveCommand runs henri save <name> <query>.
+func saveCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing saved search name or query")
+	}
+	params, err := filterFlags()
+	if err != nil {
+		return err
+	}
+	ss, err := saveSearch(ctx, args[0], args[1], params, *threshold, h.Embedder, h.DB)
+	if err != nil {
+		return err
+	}
+	if ss == nil {
+		fmt.Printf("Deleted saved search %q\n", args[0])
+	} else {
+		fmt.Printf("Saved search %q, %d images\n", ss.Name, ss.Images)
+	}
+	return nil
+}
+
+// savedCommand runs henri saved.
+func savedCommand(ctx context.Context, h *henri.Henri) error {
+	return printSavedSearches(ctx, os.Stdout, refreshEmbedder(h), h.DB)
+}
+
+// membersCommand runs henri members <name>.
+func membersCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing saved search name")
+	}
+	return printMembers(ctx, os.Stdout, args[0], refreshEmbedder(h), h.DB)
+}
+
+// refreshEmbedder returns the embedder to evaluate out of date saved searches
+// with when listing them, nil if there is none or it isn't up. Saved searches
+// are then listed as last evaluated.
+func refreshEmbedder(h *henri.Henri) describer.TextEmbedder {
+	d := h.Embedder
+	if d != nil && !d.IsHealthy() {
+		log.Printf("%s server is not responding, saved searches may be out of date", d.Name())
+		return nil
+	}
+	return d
+}
+
+// saveSearch saves query, searching the images matched by the search params
+// in params, as the smart album name and evaluates it with d. Its images are
+// those scoring at least threshold. An empty query deletes the saved search.
+func saveSearch(ctx context.Context, name, query string, params url.Values, threshold float64, d describer.TextEmbedder, db *henri.DB) (*henri.SavedSearch, error) {
+	if query == "" {
+		if err := db.DeleteSavedSearch(ctx, name); errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("no saved search %q", name)
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	// Check the query and filter are good before saving them
+	if _, err := parseQuery(query); err != nil {
+		return nil, err
+	}
+	if _, err := imageFilter(ctx, db, params); err != nil {
+		return nil, err
+	}
+
+	ss := &henri.SavedSearch{
+		Name:      name,
+		Query:     query,
+		Filter:    params.Encode(),
+		Threshold: float32(threshold),
+		CreatedAt: time.Now(),
+	}
+	if err := db.SaveSearch(ctx, ss); err != nil {
+		return nil, err
+	}
+	if err := evaluateSavedSearch(ctx, ss, d, db); err != nil {
+		return nil, err
+	}
+	return savedSearchNamed(ctx, name, d, db)
+}
+
+// evaluateSavedSearch finds the images scoring at least the threshold of ss
+// against its query with d, and records them as its images.
+func evaluateSavedSearch(ctx context.Context, ss *henri.SavedSearch, d describer.TextEmbedder, db *henri.DB) error {
+	// Embeddings computed while evaluating are left for the next time
+	at := time.Now()
+
+	cq, err := parseQuery(ss.Query)
+	if err != nil {
+		return fmt.Errorf("saved search %q - %w", ss.Name, err)
+	}
+	params, err := url.ParseQuery(ss.Filter)
+	if err != nil {
+		return fmt.Errorf("saved search %q - %w", ss.Name, err)
+	}
+	filter, err := imageFilter(ctx, db, params)
+	if err != nil {
+		return fmt.Errorf("saved search %q - %w", ss.Name, err)
+	}
+	filter.Keywords = append(filter.Keywords, cq.Keywords...)
+
+	score, err := queryScorer(ctx, ss.Query, cq, d, db)
+	if err != nil {
+		return err
+	}
+	scorer, err := newBatchScorer(ctx, db, d.Model(), filter)
+	if err != nil {
+		return err
+	}
+	scores := map[int]float32{}
+	err = scorer.scoreAll(score, func(emb *henri.Embedding, score float32) {
+		if score >= ss.Threshold {
+			scores[emb.ImageId] = max(scores[emb.ImageId], score)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.SetSavedSearchImages(ctx, ss.Id, d.Model(), scores, at)
+}
+
+// refreshSavedSearches evaluates the saved searches again with d if there are
+// embeddings by d newer than their images, or they were evaluated with
+// another model. It returns the saved searches and the number evaluated. With
+// a nil d the saved searches are returned as last evaluated.
+func refreshSavedSearches(ctx context.Context, d describer.TextEmbedder, db *henri.DB) ([]*henri.SavedSearch, int, error) {
+	searches, err := db.SavedSearches(ctx)
+	if err != nil || d == nil {
+		return searches, 0, err
+	}
+
+	var evaluated int
+	for _, ss := range searches {
+		stale := !ss.Evaluated.Valid || ss.Model != d.Model()
+		if !stale {
+			if stale, err = db.EmbeddedSince(ctx, d.Model(), ss.Evaluated.Time); err != nil {
+				return nil, 0, err
+			}
+		}
+		if !stale {
+			continue
+		}
+		if err := evaluateSavedSearch(ctx, ss, d, db); err != nil {
+			return nil, 0, err
+		}
+		evaluated++
+	}
+	if evaluated == 0 {
+		return searches, 0, nil
+	}
+
+	searches, err = db.SavedSearches(ctx)
+	return searches, evaluated, err
+}
+
+// updateSavedSearches evaluates the saved searches out of date after
+// computing embeddings with d.
+func updateSavedSearches(ctx context.Context, d describer.TextEmbedder, db *henri.DB) error {
+	_, evaluated, err := refreshSavedSearches(ctx, d, db)
+	if err != nil {
+		return err
+	}
+	if evaluated > 0 {
+		fmt.Printf("Updated %d saved searches\n", evaluated)
+	}
+	return nil
+}
+
+// savedSearchNamed returns the saved search called name, evaluated with d if
+// it is out of date and d is not nil.
+func savedSearchNamed(ctx context.Context, name string, d describer.TextEmbedder, db *henri.DB) (*henri.SavedSearch, error) {
+	searches, _, err := refreshSavedSearches(ctx, d, db)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(searches, func(ss *henri.SavedSearch) bool { return ss.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("no saved search %q", name)
+	}
+	return searches[i], nil
+}
+
+// printSavedSearches writes the saved searches to w, evaluating those out of
+// date with d if it is not nil.
+func printSavedSearches(ctx context.Context, w io.Writer, d describer.TextEmbedder, db *henri.DB) error {
+	searches, _, err := refreshSavedSearches(ctx, d, db)
+	if err != nil {
+		return err
+	}
+	if len(searches) == 0 {
+		fmt.Fprintln(w, "No saved searches, create one with henri save <name> <query>")
+	}
+
+	for _, ss := range searches {
+		fmt.Fprintf(w, "%s, %d images\n", ss.Name, ss.Images)
+		fmt.Fprintf(w, "  query     %s\n", ss.Query)
+		if ss.Filter != "" {
+			fmt.Fprintf(w, "  filter    %s\n", ss.Filter)
+		}
+		fmt.Fprintf(w, "  threshold %g\n", ss.Threshold)
+	}
+	return nil
+}
+
+// printMembers writes the paths of the images of the saved search name to w,
+// one per line and best first, evaluating it with d if it is out of date and d
+// is not nil.
+func printMembers(ctx context.Context, w io.Writer, name string, d describer.TextEmbedder, db *henri.DB) error {
+	ss, err := savedSearchNamed(ctx, name, d, db)
+	if err != nil {
+		return err
+	}
+	images, _, err := db.SavedSearchImages(ctx, ss.Id)
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		fmt.Fprintln(w, img.Path)
+	}
+	return nil
+}
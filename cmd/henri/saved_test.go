@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri/describer"
+)
+
+func TestSavedSearches(t *testing.T) {
+	library := t.TempDir()
+	for i := range 3 {
+		writeJPEG(t, filepath.Join(library, fmt.Sprintf("%d.jpg", i)), 8+i, 8, color.RGBA{uint8(80 * i), 0, 0, 255})
+	}
+
+	h := indexTestLibrary(t, library)
+	img, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An image's own description matches it best, and a threshold below any
+	// similarity matches every image
+	exact, err := saveSearch(t.Context(), "exact", fmt.Sprintf("%q", img.Description), url.Values{}, 0.999, h.Embedder, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	everything, err := saveSearch(t.Context(), "everything", "image", url.Values{}, -1, h.Embedder, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saveSearch(t.Context(), "bad", "image", url.Values{"album": {"missing"}}, 0, h.Embedder, h.DB); err == nil {
+		t.Error("Expected an error saving a search of a missing album")
+	}
+	if _, err := saveSearch(t.Context(), "bad", "image AND", url.Values{}, 0, h.Embedder, h.DB); err == nil {
+		t.Error("Expected an error saving a bad query")
+	}
+
+	var buf bytes.Buffer
+	if err := printMembers(t.Context(), &buf, "exact", h.Embedder, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := img.Path+"\n", buf.String(); expected != actual {
+		t.Errorf("Expected members %q, got %q", expected, actual)
+	}
+	if expected, actual := 3, everything.Images; expected != actual {
+		t.Errorf("Expected %d images in everything, got %d", expected, actual)
+	}
+
+	// Up to date saved searches are not evaluated again
+	if _, evaluated, err := refreshSavedSearches(t.Context(), h.Embedder, h.DB); err != nil {
+		t.Fatal(err)
+	} else if evaluated != 0 {
+		t.Errorf("Expected no saved searches evaluated, got %d", evaluated)
+	}
+
+	// New embeddings update the saved searches
+	writeJPEG(t, filepath.Join(library, "3.jpg"), 12, 8, color.RGBA{0, 0, 255, 255})
+	if err := runPipeline(t.Context(), library, h); err != nil {
+		t.Fatal(err)
+	}
+	searches, err := h.DB.SavedSearches(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]int{}
+	for _, ss := range searches {
+		images[ss.Name] = ss.Images
+	}
+	if expected, actual := (map[string]int{"everything": 4, "exact": 1}), images; !maps.Equal(expected, actual) {
+		t.Errorf("Expected saved searches %v, got %v", expected, actual)
+	}
+
+	// Without an embedder the saved searches are listed as last evaluated,
+	// even when out of date
+	writeJPEG(t, filepath.Join(library, "4.jpg"), 13, 8, color.RGBA{0, 255, 0, 255})
+	if _, err := findAndInsertImageFiles(t.Context(), library, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	todo, err := h.DB.ImagesToDescribe(t.Context())
+	if err != nil || len(todo) != 1 {
+		t.Fatalf("Expected one image to describe, got %v, %v", todo, err)
+	}
+	if err := describeImageFn(t.Context(), h.Describer, todo[0], h.DB, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := calcEmbeddingFn(t.Context(), h.Embedder, todo[0], h.DB); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := printSavedSearches(t.Context(), &buf, nil, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "everything, 4 images") {
+		t.Errorf("Expected everything with 4 images, got %q", buf.String())
+	}
+	buf.Reset()
+	if err := printMembers(t.Context(), &buf, "everything", nil, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 4, strings.Count(buf.String(), "\n"); expected != actual {
+		t.Errorf("Expected %d members, got %d", expected, actual)
+	}
+	if _, evaluated, err := refreshSavedSearches(t.Context(), h.Embedder, h.DB); err != nil {
+		t.Fatal(err)
+	} else if evaluated != 2 {
+		t.Errorf("Expected 2 saved searches evaluated, got %d", evaluated)
+	}
+
+	// The server shows the images saved searches have, without evaluating
+	// them again, even with another embedder
+	other, err := describer.Open("fake://?dim=32", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(h, "0")
+	srv.d = other.(describer.TextEmbedder)
+	ts := httptest.NewServer(srv.serveHandler())
+	defer ts.Close()
+
+	tests := []struct {
+		id      int
+		status  int
+		results int
+	}{
+		{everything.Id, http.StatusOK, 5},
+		{exact.Id, http.StatusOK, 1},
+		{999, http.StatusNotFound, 0},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(fmt.Sprintf("%s/saved/%d", ts.URL, tc.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := tc.status, resp.StatusCode; expected != actual {
+			t.Errorf("%d: expected status %d, got %d", tc.id, expected, actual)
+			continue
+		}
+		if expected, actual := tc.results, strings.Count(string(body), `class="searchresult"`); expected != actual {
+			t.Errorf("%d: expected %d results, got %d", tc.id, expected, actual)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "everything (5)") {
+		t.Errorf("Expected the smart album everything in %s", body)
+	}
+
+	// An empty query deletes a saved search
+	if ss, err := saveSearch(t.Context(), "exact", "", nil, 0, h.Embedder, h.DB); err != nil || ss != nil {
+		t.Errorf("Expected exact deleted, got %v, %v", ss, err)
+	}
+	if _, err := saveSearch(t.Context(), "exact", "", nil, 0, h.Embedder, h.DB); err == nil {
+		t.Error("Expected an error deleting a missing saved search")
+	}
+	buf.Reset()
+	if err := printMembers(t.Context(), &buf, "exact", h.Embedder, h.DB); err == nil {
+		t.Error("Expected an error listing a deleted saved search")
+	}
+}
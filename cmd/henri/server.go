@@ -3,16 +3,26 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/subtle"
+	"database/sql"
 	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chriskillpack/henri"
 	"github.com/chriskillpack/henri/describer"
@@ -28,35 +38,57 @@ var (
 
 	indexTmpl   *template.Template
 	resultsTmpl *template.Template
+	adminTmpl   *template.Template
+	imageTmpl   *template.Template
+
+	serverToken = flag.String("token", "", "Token clients other than localhost present to make changes in server mode, open a page with ?token=<token> to present it")
 )
 
 type Server struct {
 	hs     *http.Server
-	d      describer.Describer
+	d      describer.TextEmbedder
+	r      describer.Reranker // nil unless re-ranking
 	db     *henri.DB
+	jobs   *jobRunner
 	logger *log.Logger
+	token  string // clients other than localhost present it to make changes
+
+	shutdown chan struct{} // closed when the server is shutting down
 }
 
 func init() {
 	indexTmpl = template.Must(template.ParseFS(tmplFS, "tmpl/index.html"))
 	resultsTmpl = template.Must(template.ParseFS(tmplFS, "tmpl/_results.html"))
+	adminTmpl = template.Must(template.ParseFS(tmplFS, "tmpl/admin.html"))
+	imageTmpl = template.Must(template.ParseFS(tmplFS, "tmpl/image.html"))
 }
 
-func NewServer(d describer.Describer, db *henri.DB, port string) *Server {
+func NewServer(h *henri.Henri, port string) *Server {
 	srv := &Server{
-		d:      d,
-		db:     db,
-		logger: log.Default(),
+		d:        h.Embedder,
+		r:        h.Reranker,
+		db:       h.DB,
+		logger:   log.Default(),
+		token:    *serverToken,
+		shutdown: make(chan struct{}),
 	}
+	srv.jobs = newJobRunner(h, srv.logger)
 
 	srv.hs = &http.Server{
 		Addr:    net.JoinHostPort("0.0.0.0", port),
 		Handler: srv.serveHandler(),
 	}
+	// Event streams never finish on their own
+	srv.hs.RegisterOnShutdown(func() { close(srv.shutdown) })
 
 	return srv
 }
 
+// RunJobs runs the jobs started from the admin page until ctx is cancelled.
+func (s *Server) RunJobs(ctx context.Context) error {
+	return s.jobs.run(ctx)
+}
+
 func (s *Server) Start() error {
 	return s.hs.ListenAndServe()
 }
@@ -70,9 +102,91 @@ func (s *Server) serveHandler() http.Handler {
 	mux.Handle("GET /static/", http.FileServerFS(staticFS))
 	mux.Handle("GET /search", s.serveSearch())
 	mux.Handle("GET /image/{id}", s.serveImage())
+	mux.Handle("GET /images/{id}", s.serveImagePage())
+	mux.Handle("POST /images/{id}/description", s.authorize(s.serveEditDescription()))
+	mux.Handle("POST /feedback", s.authorize(s.serveFeedback()))
+	mux.Handle("GET /saved/{id}", s.serveSavedSearch())
+	mux.Handle("GET /stats", s.serveStats())
+	mux.Handle("GET /admin", s.serveAdmin())
+	mux.Handle("GET /jobs", s.serveJobs())
+	mux.Handle("POST /jobs", s.authorize(s.serveStartJob()))
+	mux.Handle("POST /jobs/{id}/{action}", s.authorize(s.serveJobAction()))
+	mux.Handle("GET /jobs/events", s.serveJobEvents())
 	mux.Handle("GET /", s.serveRoot())
 
-	return mux
+	return s.tokenCookie(mux)
+}
+
+// tokenCookieName is the cookie a browser presents the server token in.
+const tokenCookieName = "henri_token"
+
+// authorize only lets requests that change the library through to h if they
+// come from localhost, or present the server token when it has one. Requests
+// from the pages of other sites are always refused.
+func (s *Server) authorize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if origin := req.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != req.Host {
+				http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
+		if s.token != "" {
+			token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if c, err := req.Cookie(tokenCookieName); err == nil && token == "" {
+				token = c.Value
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		} else if !isLoopback(req.RemoteAddr) {
+			http.Error(w, "changes are only allowed from localhost, start the server with --token to allow them from elsewhere", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// tokenCookie has browsers that open a page with the server token in its
+// ?token= parameter present it from then on.
+func (s *Server) tokenCookie(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := req.URL.Query().Get("token")
+		if s.token == "" || token == "" || req.Method != http.MethodGet {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		// Keep the token out of the address bar and history
+		u := *req.URL
+		q := u.Query()
+		q.Del("token")
+		u.RawQuery = q.Encode()
+		http.Redirect(w, req, u.RequestURI(), http.StatusSeeOther)
+	})
+}
+
+// isLoopback returns whether the client at addr, a host:port, is localhost.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
 }
 
 func (s *Server) serveSearch() http.HandlerFunc {
@@ -83,34 +197,141 @@ func (s *Server) serveSearch() http.HandlerFunc {
 			return
 		}
 
+		filter, err := imageFilter(req.Context(), s.db, req.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lambda, err := parseLambda(cmp.Or(req.URL.Query().Get("mmr"), *mmrLambda))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		query := qvals[0]
+		cq, err := parseQuery(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Keywords = append(filter.Keywords, cq.Keywords...)
 		s.logger.Printf("query - %q\n", query)
-		topk, err := s.runQuery(req.Context(), query, 5)
+		n := 5
+		if s.r != nil {
+			n = max(n, *rerankN)
+		}
+		topk, err := s.runQuery(req.Context(), query, cq, searchPool(n, lambda), filter)
 		if err != nil {
 			s.logger.Printf("runQuery error - %s\n", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-
-		type searchresult struct {
-			Description   []string
-			Score         float32
-			ImageURL      string
-			ImageCSSClass string
+		// Results the re-ranker didn't rate in the budget keep their order
+		topes := diversify(topk.GetTopK(), n, lambda)
+		ranked := unranked(topes)
+		if s.r != nil {
+			if ranked, err = rerank(req.Context(), s.r, query, topes, *rerankBudget, s.db); err != nil {
+				s.logger.Printf("rerank error - %s\n", err)
+			}
 		}
-		results := struct {
-			Results []searchresult
-		}{Results: make([]searchresult, 5)}
-		for i, es := range topk.GetTopK() {
-			results.Results[i].Description = splitByNewline(es.embed.Image.Description)
-			results.Results[i].Score = es.score
-			results.Results[i].ImageURL = fmt.Sprintf("/image/%d", es.embed.ImageId)
+		ranked = ranked[:min(5, len(ranked))]
 
-			cssClass := "img-landscape"
-			if es.embed.Image.Height.Int16 > es.embed.Image.Width.Int16 {
-				cssClass = "img-portrait"
+		feedback, err := s.db.QueryFeedback(req.Context(), normalQuery(query), s.d.Model())
+		if err != nil {
+			s.logger.Printf("feedback error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		votes := map[int]string{}
+		for _, fb := range feedback {
+			votes[fb.ImageId] = "down"
+			if fb.Relevant {
+				votes[fb.ImageId] = "up"
 			}
-			results.Results[i].ImageCSSClass = cssClass
+		}
+
+		results := searchResults{Query: query, Results: make([]searchresult, len(ranked))}
+		if s.r != nil {
+			results.Reranker = s.r.Model()
+		}
+		for i, es := range ranked {
+			results.Results[i] = newSearchResult(es.embed.Image, es.score)
+			results.Results[i].Vote = votes[es.embed.ImageId]
+			results.Results[i].Relevance, results.Results[i].Rated = es.relevance, es.rated
+		}
+		resultsTmpl.Execute(w, results)
+	}
+}
+
+// searchResults is the data of the results template.
+type searchResults struct {
+	Query    string
+	Reranker string
+	Results  []searchresult
+}
+
+type searchresult struct {
+	Id            int
+	Description   []string
+	Score         float32
+	ImageURL      string
+	PageURL       string
+	ImageCSSClass string
+	Vote          string  // up, down or empty
+	Relevance     float32 // set if Rated
+	Rated         bool
+}
+
+// newSearchResult returns the result showing img with its score.
+func newSearchResult(img *henri.Image, score float32) searchresult {
+	cssClass := "img-landscape"
+	if img.Height.Int16 > img.Width.Int16 {
+		cssClass = "img-portrait"
+	}
+	return searchresult{
+		Id:            img.Id,
+		Description:   splitByNewline(img.Description),
+		Score:         score,
+		ImageURL:      fmt.Sprintf("/image/%d", img.Id),
+		PageURL:       fmt.Sprintf("/images/%d", img.Id),
+		ImageCSSClass: cssClass,
+	}
+}
+
+// serveSavedSearch serves the images of a saved search, its smart album, in
+// the same form as search results. It serves the images the search had when
+// last evaluated.
+func (s *Server) serveSavedSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		// Saved searches are evaluated as embeddings are computed, not
+		// while serving them
+		searches, err := s.db.SavedSearches(req.Context())
+		if err != nil {
+			s.logger.Printf("saved search error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		i := slices.IndexFunc(searches, func(ss *henri.SavedSearch) bool { return ss.Id == id })
+		if i < 0 {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		images, scores, err := s.db.SavedSearchImages(req.Context(), id)
+		if err != nil {
+			s.logger.Printf("saved search images error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		results := searchResults{Query: searches[i].Query, Results: make([]searchresult, len(images))}
+		for i, img := range images {
+			results.Results[i] = newSearchResult(img, scores[img.Id])
 		}
 		resultsTmpl.Execute(w, results)
 	}
@@ -150,75 +371,486 @@ func (s *Server) serveImage() http.HandlerFunc {
 		w.Write(data)
 	}
 }
+
+// labelTitles names the kinds of label on the image page.
+var labelTitles = []struct{ kind, title string }{
+	{henri.LabelAlbum, "Albums"},
+	{henri.LabelPerson, "People"},
+	{henri.LabelKeyword, "Keywords"},
+}
+
+// similarImages is how many similar images the image page lists.
+const similarImages = 8
+
+// imagePage is the data of the image page.
+type imagePage struct {
+	Image        *henri.Image
+	Description  []string
+	Fields       []exifField
+	Exif         []exifField
+	History      []*henri.DescriptionVersion
+	Embeddings   []*henri.Embedding
+	SimilarModel string
+	Similar      []similarImage
+}
+
+type similarImage struct {
+	Id            int
+	Description   string
+	Score         float32
+	ImageCSSClass string
+}
+
+// serveImagePage serves a page about an image, with its description, where it
+// came from and the images most similar to it.
+func (s *Server) serveImagePage() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		page, err := s.imagePage(req.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.logger.Printf("image page error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		imageTmpl.Execute(w, page)
+	}
+}
+
+// serveEditDescription replaces an image's description with one written on
+// the image page, then shows the page again.
+func (s *Server) serveEditDescription() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		author := cmp.Or(strings.TrimSpace(req.FormValue("author")), "web")
+		err = editDescription(req.Context(), id, req.FormValue("description"), author, s.d, s.db)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.logger.Printf("edit description error - %s\n", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.Printf("description of image %d edited by %s\n", id, author)
+		http.Redirect(w, req, fmt.Sprintf("/images/%d", id), http.StatusSeeOther)
+	}
+}
+
+// serveFeedback records a thumbs up or down on a search result, or removes it
+// if the vote is empty. Feedback is kept for the query and the model it was
+// searched with.
+func (s *Server) serveFeedback() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		query := normalQuery(req.FormValue("q"))
+		id, err := strconv.Atoi(req.FormValue("image"))
+		if query == "" || err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if _, err := s.db.GetImage(req.Context(), id); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		model := s.d.Model()
+		switch vote := req.FormValue("vote"); vote {
+		case "up", "down":
+			err = s.db.SetFeedback(req.Context(), query, id, model, vote == "up", time.Now())
+		case "":
+			err = s.db.DeleteFeedback(req.Context(), query, id, model)
+		default:
+			http.Error(w, fmt.Sprintf("unknown vote %q", vote), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.logger.Printf("feedback error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) imagePage(ctx context.Context, id int) (*imagePage, error) {
+	img, err := s.db.GetImage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	page := &imagePage{Image: img, Description: splitByNewline(img.Description)}
+
+	add := func(name, value string) {
+		if value != "" {
+			page.Fields = append(page.Fields, exifField{name, value})
+		}
+	}
+	add("Path", img.Path)
+	if img.CollectionId != 0 {
+		collections, err := s.db.Collections(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if i := slices.IndexFunc(collections, func(c *henri.Collection) bool { return c.Id == img.CollectionId }); i >= 0 {
+			add("Collection", collections[i].Name)
+		}
+	}
+	if img.Width.Valid && img.Height.Valid {
+		add("Dimensions", fmt.Sprintf("%d x %d", img.Width.Int16, img.Height.Int16))
+	}
+	add("Modified", img.PathMTime.Format(time.DateTime))
+	if !img.TakenAt.IsZero() {
+		add("Taken", img.TakenAt.Local().Format(time.DateTime))
+	}
+	if img.Favorite {
+		add("Favourite", "Yes")
+	}
+
+	labels, err := s.db.ImageLabels(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, lt := range labelTitles {
+		var names []string
+		for _, l := range labels {
+			if l.Kind == lt.kind {
+				names = append(names, l.Name)
+			}
+		}
+		add(lt.title, strings.Join(names, ", "))
+	}
+
+	if img.Model != "" {
+		add("Described by", img.Describer+" "+img.Model)
+	}
+	if !img.EditedAt.IsZero() {
+		add("Edited", img.EditedAt.Local().Format(time.DateTime))
+	}
+	if img.ProcessedAt.Valid {
+		add("Described", img.ProcessedAt.Time.Local().Format(time.DateTime))
+	} else if img.AttemptedAt.Valid {
+		add("Failed to describe", img.AttemptedAt.Time.Local().Format(time.DateTime))
+	}
+	if img.DescribeTime > 0 {
+		add("Describe time", img.DescribeTime.Round(time.Millisecond).String())
+	}
+	add("SHA-256", img.Hash)
+
+	if page.Exif, err = readExif(img.Path); err != nil {
+		// The file may have moved, the page is still useful
+		s.logger.Printf("EXIF error - %s\n", err)
+	}
+
+	if page.History, err = s.db.DescriptionHistory(ctx, id); err != nil {
+		return nil, err
+	}
+	if page.Embeddings, err = s.db.ImageEmbeddings(ctx, id); err != nil {
+		return nil, err
+	}
+	if len(page.Embeddings) == 0 {
+		return page, nil
+	}
+
+	// Images similar by the search embedder, or by whichever model there is
+	emb := page.Embeddings[0]
+	if i := slices.IndexFunc(page.Embeddings, func(e *henri.Embedding) bool { return e.Model == s.d.Model() }); i >= 0 {
+		emb = page.Embeddings[i]
+	}
+	scorer, err := newBatchScorer(ctx, s.db, emb.Model, henri.ImageFilter{})
+	if err != nil {
+		return nil, err
+	}
+	topk, err := scorer.topK(emb.Vector, similarImages+1)
+	if err != nil {
+		return nil, err
+	}
+	page.SimilarModel = emb.Model
+	for _, es := range topk.GetTopK() {
+		if es.embed.ImageId == id || len(page.Similar) == similarImages {
+			continue
+		}
+		si := similarImage{Id: es.embed.ImageId, Score: es.score, ImageCSSClass: "img-landscape"}
+		if paras := splitByNewline(es.embed.Image.Description); len(paras) > 0 {
+			si.Description = paras[0]
+		}
+		if es.embed.Image.Height.Int16 > es.embed.Image.Width.Int16 {
+			si.ImageCSSClass = "img-portrait"
+		}
+		page.Similar = append(page.Similar, si)
+	}
+
+	return page, nil
+}
+
 func (s *Server) serveRoot() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		indexTmpl.Execute(w, nil)
+		collections, err := s.db.Collections(req.Context())
+		if err != nil {
+			s.logger.Printf("collections error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		albums, err := s.db.Labels(req.Context(), henri.LabelAlbum)
+		if err != nil {
+			s.logger.Printf("albums error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		people, err := s.db.Labels(req.Context(), henri.LabelPerson)
+		if err != nil {
+			s.logger.Printf("people error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		sets, err := s.db.DescriptionSets(req.Context())
+		if err != nil {
+			s.logger.Printf("description sets error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		searches, err := s.db.SavedSearches(req.Context())
+		if err != nil {
+			s.logger.Printf("saved searches error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		// The diverse results option starts checked if the server diversifies
+		// by default
+		diverse, lambda := false, diverseLambda
+		if l, err := parseLambda(*mmrLambda); err == nil && l < 1 {
+			diverse, lambda = true, *mmrLambda
+		}
+		indexTmpl.Execute(w, struct {
+			Collections     []*henri.Collection
+			Albums, People  []*henri.Label
+			DescriptionSets []*henri.DescriptionSet
+			SmartAlbums     []*henri.SavedSearch
+			Diverse         bool
+			DiverseLambda   string
+		}{collections, albums, people, sets, searches, diverse, lambda})
 	}
 }
 
-func (s *Server) runQuery(ctx context.Context, query string, k int) (*TopKTracker, error) {
+func (s *Server) serveStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		sr, err := newStatusReport(req.Context(), s.db)
+		if err != nil {
+			s.logger.Printf("stats error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, sr)
+	}
+}
+
+func (s *Server) serveAdmin() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		adminTmpl.Execute(w, nil)
+	}
+}
+
+func (s *Server) serveJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		jobs, err := s.jobs.jobs(req.Context(), 50)
+		if err != nil {
+			s.logger.Printf("jobs error - %s\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	}
+}
+
+func (s *Server) serveStartJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		job, err := s.jobs.enqueue(req.Context(), req.FormValue("kind"), req.FormValue("path"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, newJobView(job, ""))
+	}
+}
+
+func (s *Server) serveJobAction() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		switch req.PathValue("action") {
+		case "pause":
+			err = s.jobs.pause(id)
+		case "resume":
+			err = s.jobs.resume(id)
+		case "cancel":
+			err = s.jobs.cancel(req.Context(), id)
+		default:
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		switch {
+		case errors.Is(err, errNoSuchJob):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// serveJobEvents streams job changes as Server-Sent Events.
+func (s *Server) serveJobEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ch := s.jobs.subscribe()
+		defer s.jobs.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-s.shutdown:
+				return
+			case jv := <-ch:
+				data, err := json.Marshal(jv)
+				if err != nil {
+					s.logger.Printf("job event error - %s\n", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// runQuery returns the k embeddings best matching query, parsed as cq,
+// searching only the images matched by filter.
+func (s *Server) runQuery(ctx context.Context, query string, cq *compoundQuery, k int, filter henri.ImageFilter) (*TopKTracker, error) {
 	g, _ := errgroup.WithContext(ctx)
 
 	var (
-		batch    henri.EmbeddingBatch
-		batchCh  <-chan henri.EmbeddingBatch
-		errCh    <-chan error
-		queryvec []float32
-		ok       bool
+		score  scoreFn
+		scorer *batchScorer
 	)
 
 	// Compute the embeddings for this query
 	g.Go(func() error {
 		var err error
-		queryvec, err = s.d.Embeddings(ctx, query)
+		score, err = queryScorer(ctx, query, cq, s.d, s.db)
 		return err
 	})
 
 	// Concurrently retrieve the first batch of embeddings for this model
 	g.Go(func() error {
-		batchCh, errCh = s.db.EmbeddingsForModel(ctx, s.d.Model(), 0)
-
-		select {
-		case err := <-errCh:
-			return err
-
-		case batch, ok = <-batchCh:
-		}
-
-		return nil
+		var err error
+		scorer, err = newBatchScorer(ctx, s.db, s.d.Model(), filter)
+		return err
 	})
 	if err := g.Wait(); err != nil {
 		return nil, fmt.Errorf("query error - %w", err)
 	}
 
+	return scorer.topKBy(score, k)
+}
+
+// batchScorer scores the stored embeddings of a model against a vector.
+type batchScorer struct {
+	batch   henri.EmbeddingBatch
+	ok      bool
+	batchCh <-chan henri.EmbeddingBatch
+	errCh   <-chan error
+}
+
+// newBatchScorer starts loading the embeddings of model for the images
+// matched by filter, returning once the first batch has been retrieved.
+func newBatchScorer(ctx context.Context, db *henri.DB, model string, filter henri.ImageFilter) (*batchScorer, error) {
+	bs := &batchScorer{}
+	bs.batchCh, bs.errCh = db.EmbeddingsForModel(ctx, model, 0, filter)
+
+	select {
+	case err := <-bs.errCh:
+		return nil, err
+	case bs.batch, bs.ok = <-bs.batchCh:
+	}
+	return bs, nil
+}
+
+// topK returns the k embeddings most similar to vec.
+func (bs *batchScorer) topK(vec []float32, k int) (*TopKTracker, error) {
+	return bs.topKBy(cosineScore(vec), k)
+}
+
+// topKBy returns the k embeddings scoring highest by score.
+func (bs *batchScorer) topKBy(score scoreFn, k int) (*TopKTracker, error) {
+	topk := NewTopKTracker(k)
+	if err := bs.scoreAll(score, topk.ProcessItem); err != nil {
+		return nil, err
+	}
+	return topk, nil
+}
+
+// scoreAll calls fn with every embedding and its score by score.
+func (bs *batchScorer) scoreAll(score scoreFn, fn func(emb *henri.Embedding, score float32)) error {
+	var g errgroup.Group
+
 	// With the data collected we can start scoring. While the first batch is
 	// being scored, concurrently the next batch will be fetched.
-	topk := NewTopKTracker(k)
+	batch, ok := bs.batch, bs.ok
 	for ok {
 		// Fetch the next batch concurrently while computing scores for the current batch
 		var nb henri.EmbeddingBatch
 		g.Go(func() error {
 			select {
-			case err := <-errCh:
+			case err := <-bs.errCh:
 				return err
-			case nb, ok = <-batchCh:
+			case nb, ok = <-bs.batchCh:
 			}
 			return nil
 		})
 		g.Go(func() error {
 			for _, emb := range batch.Embeds {
-				score, err := computeCosineSimilarity(queryvec, emb.Vector)
+				sim, err := score(emb.Vector)
 				if err != nil {
 					return err
 				}
 
-				topk.ProcessItem(emb, score)
+				fn(emb, sim)
 			}
 			return nil
 		})
 		err := g.Wait()
 		if err != nil {
-			return nil, fmt.Errorf("scoring batches - %w", err)
+			return fmt.Errorf("scoring batches - %w", err)
 		}
 
 		// Intermediate batches will have batch.Done=false,ok=true
@@ -232,7 +864,7 @@ func (s *Server) runQuery(ctx context.Context, query string, k int) (*TopKTracke
 		batch = nb
 	}
 
-	return topk, nil
+	return nil
 }
 
 // Splits s into separate substrings by newline character. Each substring is
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerAuthorize(t *testing.T) {
+	h := newTestHenri(t, AppModeServer)
+
+	defer func(old string) { *serverToken = old }(*serverToken)
+
+	tests := []struct {
+		token  string // of the server
+		remote string
+		header map[string]string
+		status int
+	}{
+		{"", "127.0.0.1:1234", nil, http.StatusBadRequest},
+		{"", "[::1]:1234", nil, http.StatusBadRequest},
+		{"", "192.168.1.2:1234", nil, http.StatusForbidden},
+		{"", "127.0.0.1:1234", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{"", "127.0.0.1:1234", map[string]string{"Origin": "http://henri.local"}, http.StatusBadRequest},
+		{"secret", "127.0.0.1:1234", nil, http.StatusUnauthorized},
+		{"secret", "192.168.1.2:1234", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized},
+		{"secret", "192.168.1.2:1234", map[string]string{"Authorization": "Bearer secret"}, http.StatusBadRequest},
+		{"secret", "192.168.1.2:1234", map[string]string{"Cookie": tokenCookieName + "=secret"}, http.StatusBadRequest},
+	}
+	for i, tc := range tests {
+		*serverToken = tc.token
+		handler := NewServer(h, "0").serveHandler()
+
+		// Authorized requests fail on the unknown job kind
+		req := httptest.NewRequest("POST", "http://henri.local/jobs", strings.NewReader("kind=bogus"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if expected, actual := tc.status, w.Code; expected != actual {
+			t.Errorf("%d: Expected status %d, got %d (%s)", i, expected, actual, w.Body)
+		}
+	}
+
+	// Opening a page with the token sets the cookie
+	*serverToken = "secret"
+	handler := NewServer(h, "0").serveHandler()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/admin?token=secret", nil))
+	if expected, actual := http.StatusSeeOther, w.Code; expected != actual {
+		t.Fatalf("Expected status %d, got %d", expected, actual)
+	}
+	if expected, actual := "/admin", w.Header().Get("Location"); expected != actual {
+		t.Errorf("Expected redirect to %q, got %q", expected, actual)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != tokenCookieName || cookies[0].Value != "secret" {
+		t.Errorf("Expected the token cookie, got %v", cookies)
+	}
+}
@@ -0,0 +1,90 @@
+let jobsContainer;
+let jobError;
+
+addEventListener("load", (event) => {
+    jobsContainer = document.querySelector("#jobsContainer");
+    jobError = document.querySelector("#jobError");
+
+    document.querySelector("#jobForm").addEventListener("submit", startJob);
+
+    fetch("/jobs")
+    .then((response) => response.json())
+    .then((jobs) => {
+        // Newest first
+        jobs.reverse().forEach(renderJob);
+    })
+    .catch((err) => {
+        console.error('Error fetching jobs: ', err);
+    });
+
+    const events = new EventSource("/jobs/events");
+    events.addEventListener("job", (ev) => {
+        renderJob(JSON.parse(ev.data));
+    });
+});
+
+function startJob(event) {
+    event.preventDefault();
+    jobError.classList.add("hidden");
+
+    fetch("/jobs", {method: "POST", body: new FormData(event.target)})
+    .then((response) => {
+        if (!response.ok) {
+            return response.text().then((text) => { throw new Error(text); });
+        }
+    })
+    .catch((err) => {
+        jobError.textContent = err.message;
+        jobError.classList.remove("hidden");
+    });
+}
+
+function jobAction(id, action) {
+    fetch(`/jobs/${id}/${action}`, {method: "POST"})
+    .catch((err) => {
+        console.error(`Error sending ${action}: `, err);
+    });
+}
+
+// renderJob adds or updates the row for job, newer jobs are at the top.
+function renderJob(job) {
+    let row = document.querySelector(`#job-${job.id}`);
+    if (!row) {
+        row = document.createElement("div");
+        row.id = `job-${job.id}`;
+        row.className = "searchresult";
+        jobsContainer.prepend(row);
+    }
+
+    let title = `#${job.id} ${job.kind}`;
+    if (job.arg) {
+        title += ` ${job.arg}`;
+    }
+    let progress = `${job.state}`;
+    if (job.total > 0) {
+        progress += `, ${job.done}/${job.total}`;
+    }
+    if (job.errors > 0) {
+        progress += `, ${job.errors} errors`;
+    }
+
+    row.replaceChildren();
+    const info = document.createElement("div");
+    info.className = "flex-1";
+    for (const [text, cls] of [[title, "text-gray-700"], [progress, "text-gray-600"], [job.current || job.message || "", "text-gray-400 text-sm"]]) {
+        const p = document.createElement("p");
+        p.className = cls;
+        p.textContent = text;
+        info.appendChild(p);
+    }
+    row.appendChild(info);
+
+    const actions = {running: ["pause", "cancel"], paused: ["resume", "cancel"], queued: ["cancel"]}[job.state] || [];
+    for (const action of actions) {
+        const button = document.createElement("button");
+        button.className = "py-3 px-4 text-sm font-medium rounded-lg border border-transparent bg-orange-600 text-white mr-3";
+        button.textContent = action;
+        button.addEventListener("click", () => jobAction(job.id, action));
+        row.appendChild(button);
+    }
+}
@@ -4,10 +4,13 @@ let spinner;
 let resultsContainer;
 
 addEventListener("load", (event) => {
-    const buttons = document.querySelectorAll("div.w-full.relative button")
+    const buttons = document.querySelectorAll("div.w-full.relative button:not(.smartalbum)")
     buttons.forEach((button) => {
         button.addEventListener("click", handleSearch);
     });
+    document.querySelectorAll("button.smartalbum").forEach((button) => {
+        button.addEventListener("click", handleSmartAlbum);
+    });
 
     searchInput = document.querySelector("#searchInput");
     searchInput.addEventListener("keydown", function(ev) {
@@ -28,15 +31,75 @@ addEventListener("load", (event) => {
     searchButton = document.querySelector("#searchbutton");
     spinner = document.querySelector("#spinner");
     resultsContainer = document.querySelector("#resultsContainer");
+    resultsContainer.addEventListener("click", handleVote);
 });
 
+// handleVote records a thumbs up or down on a result. Clicking the current
+// vote again removes it.
+function handleVote(event) {
+    const button = event.target.closest("button.vote");
+    if (!button) {
+        return;
+    }
+    const result = button.parentElement;
+    const vote = result.dataset.vote === button.value ? "" : button.value;
+    const params = new URLSearchParams({q: result.dataset.query, image: result.dataset.image, vote: vote});
+    fetch("/feedback", {method: "POST", body: params})
+    .then((response) => {
+        if (!response.ok) {
+            throw new Error(`HTTP error, status ${response.status}`);
+        }
+        result.dataset.vote = vote;
+        result.querySelectorAll("button.vote").forEach((b) => {
+            b.classList.toggle("text-orange-600", b.value === vote);
+            b.classList.toggle("text-gray-400", b.value !== vote);
+        });
+    })
+    .catch((err) => {
+        console.error('Error recording feedback: ', err);
+    });
+}
+
+// handleSmartAlbum shows all the images of a saved search.
+function handleSmartAlbum(event) {
+    showSpinner();
+    fetch(`/saved/${event.target.value}`)
+    .then((response) => {
+        if (!response.ok) {
+            throw new Error(`HTTP error, status ${response.status}`);
+        }
+        return response.text();
+    })
+    .then((html) => {
+        resultsContainer.innerHTML = html;
+    })
+    .catch((err) => {
+        console.error('Error fetching smart album: ', err);
+    })
+    .finally(() => {
+        hideSpinner();
+    })
+}
+
 function handleSearch(event) {
     disableSearchButton();
     showSpinner();
 
     const query = searchInput.value.trim();
     if (query) {
-        fetch(`/search?q=${encodeURIComponent(query)}`)
+        const params = new URLSearchParams({q: query});
+        document.querySelectorAll("#collections input:checked").forEach((input) => {
+            params.append("collection", input.value);
+        });
+        document.querySelectorAll("#filters select, #filters input:checked").forEach((input) => {
+            if (input.value) {
+                params.append(input.name, input.value);
+            }
+        });
+        // Unchecked asks for no diversity, whatever the server's default
+        const diverse = document.querySelector("#diverse");
+        params.append("mmr", diverse.checked ? diverse.value : "1");
+        fetch(`/search?${params}`)
         .then((response) => {
             if (!response.ok) {
                 throw new Error(`HTTP error, status ${response.status}`);
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+// statusCommand runs henri status.
+func statusCommand(ctx context.Context, h *henri.Henri) error {
+	sr, err := newStatusReport(ctx, h.DB)
+	if err != nil {
+		return err
+	}
+	sr.print(os.Stdout)
+	return nil
+}
+
+// statusReport is the pipeline coverage reported by the status command and
+// the /stats endpoint.
+type statusReport struct {
+	Images            int            `json:"images"`
+	Described         int            `json:"described"`
+	Failed            int            `json:"failed"`
+	Pending           int            `json:"pending"`
+	Descriptions      map[string]int `json:"descriptions"` // images described by each description set
+	Embeddings        map[string]int `json:"embeddings"`
+	MissingEmbeddings map[string]int `json:"missing_embeddings"`
+	AvgDescribeSecs   float64        `json:"avg_describe_secs"`
+	ETASecs           float64        `json:"eta_secs"` // to describe the pending images
+	DBSizeBytes       int64          `json:"db_size_bytes"`
+}
+
+func newStatusReport(ctx context.Context, db *henri.DB) (*statusReport, error) {
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := db.DescriptionSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	descriptions := make(map[string]int, len(sets))
+	for _, ds := range sets {
+		descriptions[ds.String()] = ds.Images
+	}
+
+	return &statusReport{
+		Images:            stats.Images,
+		Described:         stats.Described,
+		Failed:            stats.Failed,
+		Pending:           stats.Pending,
+		Descriptions:      descriptions,
+		Embeddings:        stats.Embeddings,
+		MissingEmbeddings: stats.MissingEmbeddings,
+		AvgDescribeSecs:   stats.AvgDescribeTime.Seconds(),
+		ETASecs:           (time.Duration(stats.Pending) * stats.AvgDescribeTime).Seconds(),
+		DBSizeBytes:       stats.DBSize,
+	}, nil
+}
+
+// print writes the report as text to w.
+func (sr *statusReport) print(w io.Writer) {
+	percent := func(n int) float64 {
+		if sr.Images == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(sr.Images)
+	}
+
+	fmt.Fprintf(w, "Images       %8d\n", sr.Images)
+	fmt.Fprintf(w, "  described  %8d (%.1f%%)\n", sr.Described, percent(sr.Described))
+	fmt.Fprintf(w, "  failed     %8d (%.1f%%)\n", sr.Failed, percent(sr.Failed))
+	fmt.Fprintf(w, "  pending    %8d (%.1f%%)\n", sr.Pending, percent(sr.Pending))
+	if sr.AvgDescribeSecs > 0 {
+		eta := time.Duration(sr.ETASecs * float64(time.Second)).Round(time.Minute)
+		fmt.Fprintf(w, "Average describe time %.1f secs, ETA %s\n", sr.AvgDescribeSecs, eta)
+	}
+
+	if len(sr.Descriptions) > 1 {
+		fmt.Fprintln(w, "Descriptions")
+		for _, set := range slices.Sorted(maps.Keys(sr.Descriptions)) {
+			fmt.Fprintf(w, "  %-24s %8d\n", cmp.Or(set, "(unknown)"), sr.Descriptions[set])
+		}
+	}
+
+	fmt.Fprintln(w, "Embeddings")
+	if len(sr.Embeddings) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, model := range slices.Sorted(maps.Keys(sr.Embeddings)) {
+		fmt.Fprintf(w, "  %-24s %8d, %d described images missing\n",
+			cmp.Or(model, "(unknown)"), sr.Embeddings[model], sr.MissingEmbeddings[model])
+	}
+
+	fmt.Fprintf(w, "Database size %.1f MB\n", float64(sr.DBSizeBytes)/(1<<20))
+}
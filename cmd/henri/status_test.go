@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	library := newTestLibrary(t, 3)
+	h := indexTestLibrary(t, library)
+	writeJPEG(t, filepath.Join(library, "d.jpg"), 11, 8, testColors[3])
+	if _, err := findAndInsertImageFiles(t.Context(), library, h.DB); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sr statusReport
+	err = json.NewDecoder(resp.Body).Decode(&sr)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 3, sr.Described; expected != actual {
+		t.Errorf("Expected %d described images, got %d", expected, actual)
+	}
+	if expected, actual := 1, sr.Pending; expected != actual {
+		t.Errorf("Expected %d pending images, got %d", expected, actual)
+	}
+	if expected, actual := 3, sr.Embeddings[h.Embedder.Model()]; expected != actual {
+		t.Errorf("Expected %d embeddings, got %d", expected, actual)
+	}
+	if expected, actual := 0, sr.MissingEmbeddings[h.Embedder.Model()]; expected != actual {
+		t.Errorf("Expected %d missing embeddings, got %d", expected, actual)
+	}
+
+	var buf bytes.Buffer
+	sr.print(&buf)
+	if !strings.Contains(buf.String(), "  pending           1 (25.0%)") {
+		t.Errorf("Expected 1 pending image in %q", buf.String())
+	}
+}
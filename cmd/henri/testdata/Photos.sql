@@ -0,0 +1,45 @@
+-- A subset of the Photos.sqlite schema of a Photos 9 library, with the
+-- columns read by the photos command.
+
+CREATE TABLE ZASSET ( Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZFAVORITE INTEGER, ZHIDDEN INTEGER, ZKIND INTEGER, ZTRASHEDSTATE INTEGER, ZDATECREATED TIMESTAMP, ZDIRECTORY VARCHAR, ZFILENAME VARCHAR, ZUUID VARCHAR );
+CREATE TABLE ZGENERICALBUM ( Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZKIND INTEGER, ZTRASHEDSTATE INTEGER, ZTITLE VARCHAR, ZUUID VARCHAR );
+CREATE TABLE Z_28ASSETS ( Z_28ALBUMS INTEGER, Z_3ASSETS INTEGER, Z_FOK_3ASSETS INTEGER, PRIMARY KEY (Z_28ALBUMS, Z_3ASSETS) );
+CREATE TABLE Z_45ASSETS ( Z_45MEMORIES INTEGER, Z_3ASSETS INTEGER, PRIMARY KEY (Z_45MEMORIES, Z_3ASSETS) );
+CREATE TABLE ZPERSON ( Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZFULLNAME VARCHAR, ZDISPLAYNAME VARCHAR );
+CREATE TABLE ZDETECTEDFACE ( Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZASSETFORFACE INTEGER, ZPERSONFORFACE INTEGER );
+
+-- Photos: edited, HEIC original with a JPEG derivative, unedited, trashed,
+-- hidden, a video and one with no rendition on disk
+INSERT INTO ZASSET VALUES (1, 3, 1, 1, 0, 0, 0, 725189400, 'A', 'AAAA-1111.jpeg', 'AAAA-1111');
+INSERT INTO ZASSET VALUES (2, 3, 1, 0, 0, 0, 0, 742672800, 'B', 'BBBB-2222.heic', 'BBBB-2222');
+INSERT INTO ZASSET VALUES (3, 3, 1, 1, 0, 0, 0, 744292800, 'C', 'CCCC-3333.jpeg', 'CCCC-3333');
+INSERT INTO ZASSET VALUES (4, 3, 1, 0, 0, 0, 1, 744379200, 'D', 'DDDD-4444.jpeg', 'DDDD-4444');
+INSERT INTO ZASSET VALUES (5, 3, 1, 0, 1, 0, 0, 744379200, 'E', 'EEEE-5555.jpeg', 'EEEE-5555');
+INSERT INTO ZASSET VALUES (6, 3, 1, 0, 0, 1, 0, 744379200, 'F', 'FFFF-6666.mov', 'FFFF-6666');
+INSERT INTO ZASSET VALUES (7, 3, 1, 0, 0, 0, 0, 744379200, 'G', 'GGGG-7777.heic', 'GGGG-7777');
+
+-- Albums: two made by the user, a folder and a deleted album
+INSERT INTO ZGENERICALBUM VALUES (10, 28, 1, 2, 0, 'Christmas', 'ALBUM-10');
+INSERT INTO ZGENERICALBUM VALUES (11, 28, 1, 2, 0, 'Holidays', 'ALBUM-11');
+INSERT INTO ZGENERICALBUM VALUES (12, 28, 1, 4000, 0, 'Trips', 'ALBUM-12');
+INSERT INTO ZGENERICALBUM VALUES (13, 28, 1, 2, 1, 'Old', 'ALBUM-13');
+
+INSERT INTO Z_28ASSETS VALUES (10, 1, 1);
+INSERT INTO Z_28ASSETS VALUES (11, 2, 1);
+INSERT INTO Z_28ASSETS VALUES (11, 3, 2);
+INSERT INTO Z_28ASSETS VALUES (11, 4, 3);
+INSERT INTO Z_28ASSETS VALUES (13, 3, 1);
+
+INSERT INTO Z_45ASSETS VALUES (1, 1);
+
+-- People: one with a full name, one only with a display name and one not
+-- yet named
+INSERT INTO ZPERSON VALUES (1, 40, 1, 'Sam Smith', 'Sam');
+INSERT INTO ZPERSON VALUES (2, 40, 1, '', 'Alex');
+INSERT INTO ZPERSON VALUES (3, 40, 1, NULL, NULL);
+
+INSERT INTO ZDETECTEDFACE VALUES (1, 20, 1, 1, 1);
+INSERT INTO ZDETECTEDFACE VALUES (2, 20, 1, 2, 1);
+INSERT INTO ZDETECTEDFACE VALUES (3, 20, 1, 2, 2);
+INSERT INTO ZDETECTEDFACE VALUES (4, 20, 1, 2, 1);
+INSERT INTO ZDETECTEDFACE VALUES (5, 20, 1, 3, 3);
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+var (
+	watchLibrary = flag.String("watch", "", "Library path to index in the background in server mode")
+	pollInterval = flag.Duration("poll", time.Minute, "Interval between library scans when watching")
+)
+
+// watchCommand runs henri watch <library_path>.
+func watchCommand(ctx context.Context, args []string, h *henri.Henri) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing library path to watch")
+	}
+	return newWatcher(args[0], h, *pollInterval).run(ctx)
+}
+
+// watcher keeps the DB up to date with a library, polling it for new images
+// and describing and embedding them as they arrive.
+type watcher struct {
+	root     string
+	h        *henri.Henri
+	interval time.Duration
+
+	// Paths already in the DB, these are not read again. Loaded on the
+	// first poll.
+	seen map[string]bool
+}
+
+func newWatcher(root string, h *henri.Henri, interval time.Duration) *watcher {
+	return &watcher{root: root, h: h, interval: interval}
+}
+
+// run polls the library until ctx is cancelled or lameduck is entered. Errors
+// are logged and the library is polled again after the interval, so a backend
+// that is down for a while does not stop the watcher.
+func (w *watcher) run(ctx context.Context) error {
+	log.Printf("Watching %s for new images every %s", w.root, w.interval)
+	for {
+		if err := w.poll(ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			log.Printf("Watch error - %s", err)
+		}
+
+		if !waitOrStop(ctx, w.interval) {
+			return nil
+		}
+	}
+}
+
+// poll scans the library once, inserting new images, then describes and
+// embeds any images that need it.
+func (w *watcher) poll(ctx context.Context) error {
+	if w.seen == nil {
+		paths, err := w.h.DB.ImagePaths(ctx)
+		if err != nil {
+			return err
+		}
+		w.seen = make(map[string]bool, len(paths))
+		for _, p := range paths {
+			w.seen[p] = true
+		}
+	}
+
+	added, err := scanImageFiles(ctx, w.root, w.h.DB, w.seen)
+	if err != nil {
+		// Paths may have been marked seen without being inserted, start
+		// afresh from the DB next time.
+		w.seen = nil
+		return err
+	}
+	if added > 0 {
+		log.Printf("Added %d new images", added)
+	}
+	if lameduck {
+		return nil
+	}
+
+	return describeAndEmbed(ctx, w.h)
+}
+
+// waitOrStop waits for d and returns true, or returns false as soon as ctx is
+// cancelled or lameduck is entered.
+func waitOrStop(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return !lameduck
+		case <-tick.C:
+			if lameduck {
+				return false
+			}
+		}
+	}
+}
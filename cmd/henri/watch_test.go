@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/henri"
+)
+
+func TestWatcherPoll(t *testing.T) {
+	library := t.TempDir()
+	h := newTestHenri(t, AppModeWatch)
+
+	countEmbeddings := func() int {
+		t.Helper()
+
+		ids, err := h.DB.EmbeddingIdsForModel(t.Context(), h.Embedder.Model(), henri.ImageFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(ids)
+	}
+
+	w := newWatcher(library, h, time.Minute)
+
+	writeJPEG(t, filepath.Join(library, "a.jpg"), 16, 8, color.RGBA{255, 0, 0, 255})
+	// A file still being copied into the library can't be decoded yet
+	partial := filepath.Join(library, "b.jpg")
+	if err := os.WriteFile(partial, []byte{0xff, 0xd8}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.poll(t.Context()); err != nil {
+		t.Fatalf("Unexpected poll error %s", err)
+	}
+	if expected, actual := 1, countEmbeddings(); expected != actual {
+		t.Errorf("Expected %d embeddings, got %d", expected, actual)
+	}
+
+	// The finished copy and new arrivals are picked up by the next poll
+	writeJPEG(t, partial, 8, 16, color.RGBA{0, 255, 0, 255})
+	writeJPEG(t, filepath.Join(library, "c.jpg"), 8, 8, color.RGBA{0, 0, 255, 255})
+	if err := w.poll(t.Context()); err != nil {
+		t.Fatalf("Unexpected poll error %s", err)
+	}
+	if expected, actual := 3, countEmbeddings(); expected != actual {
+		t.Errorf("Expected %d embeddings, got %d", expected, actual)
+	}
+
+	// Nothing new, nothing to do
+	if err := w.poll(t.Context()); err != nil {
+		t.Fatalf("Unexpected poll error %s", err)
+	}
+	if expected, actual := 3, countEmbeddings(); expected != actual {
+		t.Errorf("Expected %d embeddings, got %d", expected, actual)
+	}
+}
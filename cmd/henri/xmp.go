@@ -0,0 +1,686 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chriskillpack/henri"
+)
+
+// XML namespaces of the XMP properties read and written.
+const (
+	nsRDF     = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC      = "http://purl.org/dc/elements/1.1/"
+	nsIptcExt = "http://iptc.org/std/Iptc4xmpExt/2008-02-29/"
+	nsHenri   = "https://github.com/chriskillpack/henri/xmp/1.0/"
+)
+
+var (
+	xmpDescription = xml.Name{Space: nsRDF, Local: "Description"}
+	xmpLi          = xml.Name{Space: nsRDF, Local: "li"}
+	xmpCaption     = xml.Name{Space: nsDC, Local: "description"}
+	xmpKeywords    = xml.Name{Space: nsDC, Local: "subject"}
+	xmpPeople      = xml.Name{Space: nsIptcExt, Local: "PersonInImage"}
+	xmpHenri       = xml.Name{Space: nsHenri, Local: "description"}
+	xmpHenriModel  = xml.Name{Space: nsHenri, Local: "model"}
+)
+
+// JPEG segment prefixes of embedded XMP, EXIF and of Photoshop image
+// resources, which hold the IPTC IIM record.
+const (
+	jpegXMPPrefix       = "http://ns.adobe.com/xap/1.0/\x00"
+	jpegExifPrefix      = "Exif\x00\x00"
+	jpegPhotoshopPrefix = "Photoshop 3.0\x00"
+)
+
+// fileMetadata is the caption, keywords and people recorded in an image file,
+// or its XMP sidecar, by other photo tools.
+type fileMetadata struct {
+	caption  string
+	keywords []string // sorted
+	people   []string // sorted
+
+	// henri is the description written by henri writeback. A caption equal
+	// to it is not a caption.
+	henri string
+}
+
+// merge adds the metadata in o, keeping m's caption if it has one.
+func (m *fileMetadata) merge(o *fileMetadata) {
+	m.caption = cmp.Or(m.caption, o.caption)
+	m.henri = cmp.Or(m.henri, o.henri)
+	m.keywords = mergeNames(m.keywords, o.keywords)
+	m.people = mergeNames(m.people, o.people)
+}
+
+// mergeNames returns the sorted union of a and b.
+func mergeNames(a, b []string) []string {
+	names := slices.Concat(a, b)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// sidecarPaths returns the paths an XMP sidecar of the image at path may have.
+// digiKam and darktable add .xmp to the file name, Lightroom replaces the
+// extension.
+func sidecarPaths(path string) []string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	return []string{path + ".xmp", path + ".XMP", base + ".xmp", base + ".XMP"}
+}
+
+// findSidecar returns the path of the XMP sidecar of the image at path, or ""
+// if it has none.
+func findSidecar(path string) string {
+	for _, p := range sidecarPaths(path) {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// readFileMetadata reads the metadata of the image at path from its XMP
+// sidecar, XMP embedded in the file and the file's IPTC record, in that
+// order of precedence.
+func readFileMetadata(path string) (*fileMetadata, error) {
+	m := &fileMetadata{}
+	if sidecar := findSidecar(path); sidecar != "" {
+		data, err := os.ReadFile(sidecar)
+		if err != nil {
+			return nil, err
+		}
+		sm, err := parseXMP(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s - %w", sidecar, err)
+		}
+		m.merge(sm)
+	}
+
+	jm, err := readJPEGMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	if jm.xmp != nil {
+		xm, err := parseXMP(jm.xmp)
+		if err != nil {
+			return nil, fmt.Errorf("embedded XMP - %w", err)
+		}
+		m.merge(xm)
+	}
+	if jm.resources != nil {
+		m.merge(parseIPTC(jm.resources))
+	}
+	return m, nil
+}
+
+// parseXMP returns the caption, keywords and people in the XMP packet data.
+func parseXMP(data []byte) (*fileMetadata, error) {
+	var (
+		d      = xml.NewDecoder(bytes.NewReader(data))
+		values = map[xml.Name][]string{}
+		parent []xml.Name
+		prop   xml.Name // property of an rdf:Description being read
+		sawLi  bool     // prop is an array
+		text   strings.Builder
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(parent) > 0 && parent[len(parent)-1] == xmpDescription {
+				prop, sawLi = t.Name, false
+			}
+			parent = append(parent, t.Name)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			parent = parent[:len(parent)-1]
+			switch {
+			case t.Name == xmpLi && prop != (xml.Name{}):
+				sawLi = true
+				if s := strings.TrimSpace(text.String()); s != "" {
+					values[prop] = append(values[prop], s)
+				}
+			case t.Name == prop:
+				if s := strings.TrimSpace(text.String()); s != "" && !sawLi {
+					values[prop] = append(values[prop], s)
+				}
+				prop = xml.Name{}
+			}
+		}
+	}
+
+	m := &fileMetadata{
+		keywords: mergeNames(values[xmpKeywords], nil),
+		people:   mergeNames(values[xmpPeople], nil),
+	}
+	if v := values[xmpCaption]; len(v) > 0 {
+		m.caption = v[0]
+	}
+	if v := values[xmpHenri]; len(v) > 0 {
+		m.henri = v[0]
+	}
+	if m.caption == m.henri {
+		m.caption = ""
+	}
+	return m, nil
+}
+
+// jpegMetadata holds the metadata segments of a JPEG, nil if it has none.
+type jpegMetadata struct {
+	xmp       []byte // XMP packet
+	resources []byte // Photoshop image resources
+	exif      []byte // TIFF structure
+}
+
+// readJPEGMetadata returns the metadata embedded in the JPEG at path. Only the
+// segments before the image data are read.
+func readJPEGMetadata(path string) (*jpegMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	jm := &jpegMetadata{}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return jm, nil
+		}
+		if b != 0xff {
+			continue
+		}
+		marker, err := r.ReadByte()
+		if err != nil {
+			return jm, nil
+		}
+		switch {
+		case marker == 0xff || marker == 0x00:
+			// Fill byte or stuffed zero
+			r.UnreadByte()
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// No length
+			continue
+		case marker == 0xda || marker == 0xd9:
+			// Start of scan or end of image
+			return jm, nil
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return jm, nil
+		}
+		n := int(length) - 2
+		if marker != 0xe1 && marker != 0xed {
+			if _, err := r.Discard(n); err != nil {
+				return jm, nil
+			}
+			continue
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return jm, nil
+		}
+		if marker == 0xe1 {
+			if rest, ok := bytes.CutPrefix(seg, []byte(jpegXMPPrefix)); ok {
+				jm.xmp = rest
+			} else if rest, ok := bytes.CutPrefix(seg, []byte(jpegExifPrefix)); ok && jm.exif == nil {
+				jm.exif = rest
+			}
+		} else if rest, ok := bytes.CutPrefix(seg, []byte(jpegPhotoshopPrefix)); ok {
+			jm.resources = append(jm.resources, rest...)
+		}
+	}
+}
+
+// IPTC IIM datasets.
+const (
+	iimEnvelope     = 1
+	iimApplication  = 2
+	iimCharset      = 90  // envelope
+	iimKeywords     = 25  // application
+	iimCaption      = 120 // application
+	photoshopIPTCId = 0x0404
+)
+
+// parseIPTC returns the caption and keywords in the IPTC IIM record found in
+// the Photoshop image resources data. Malformed data ends the parse.
+func parseIPTC(data []byte) *fileMetadata {
+	// Find the IPTC resource, each is "8BIM", a 2 byte id, a padded pascal
+	// string name and the padded data with a 4 byte length
+	var iim []byte
+	for len(data) >= 12 && string(data[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(data[4:])
+		nameLen := int(data[6]) + 1
+		nameLen += nameLen % 2
+		if len(data) < 6+nameLen+4 {
+			break
+		}
+		data = data[6+nameLen:]
+		size := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if size > len(data) {
+			break
+		}
+		if id == photoshopIPTCId {
+			iim = data[:size]
+			break
+		}
+		data = data[min(size+size%2, len(data)):]
+	}
+
+	m := &fileMetadata{}
+	utf8Charset := false
+	decode := func(b []byte) string {
+		if utf8Charset || utf8.Valid(b) {
+			return strings.TrimSpace(string(b))
+		}
+		// Latin-1
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.TrimSpace(string(runes))
+	}
+
+	var keywords []string
+	for len(iim) >= 5 && iim[0] == 0x1c {
+		record, dataset := iim[1], iim[2]
+		size := int(binary.BigEndian.Uint16(iim[3:]))
+		iim = iim[5:]
+		if size&0x8000 != 0 {
+			// Extended dataset, the length of the size follows
+			n := size & 0x7fff
+			if n > 4 || n > len(iim) {
+				break
+			}
+			size = 0
+			for _, b := range iim[:n] {
+				size = size<<8 | int(b)
+			}
+			iim = iim[n:]
+		}
+		if size > len(iim) {
+			break
+		}
+		value := iim[:size]
+		iim = iim[size:]
+
+		switch {
+		case record == iimEnvelope && dataset == iimCharset:
+			utf8Charset = bytes.Equal(value, []byte("\x1b%G"))
+		case record == iimApplication && dataset == iimKeywords:
+			if s := decode(value); s != "" {
+				keywords = append(keywords, s)
+			}
+		case record == iimApplication && dataset == iimCaption:
+			m.caption = decode(value)
+		}
+	}
+	m.keywords = mergeNames(keywords, nil)
+	return m
+}
+
+// applyFileMetadata stores the metadata read from the file of the image id.
+// If the caption or keywords changed the image's embeddings are deleted, to
+// be computed again with them. People are only replaced if the file names
+// some, so those imported from Photos are kept.
+func applyFileMetadata(ctx context.Context, db *henri.DB, id int, m *fileMetadata) error {
+	img, err := db.GetImage(ctx, id)
+	if err != nil {
+		return err
+	}
+	labels, err := db.ImageLabels(ctx, id)
+	if err != nil {
+		return err
+	}
+	var keywords []string
+	for _, l := range labels {
+		if l.Kind == henri.LabelKeyword {
+			keywords = append(keywords, l.Name)
+		}
+	}
+
+	if img.Caption != m.caption || !slices.Equal(keywords, m.keywords) {
+		if err := db.SetImageCaption(ctx, id, m.caption); err != nil {
+			return err
+		}
+		if err := db.SetImageLabels(ctx, id, henri.LabelKeyword, m.keywords); err != nil {
+			return err
+		}
+		if err := db.DeleteImageEmbeddings(ctx, id); err != nil {
+			return err
+		}
+	}
+	if len(m.people) > 0 {
+		return db.SetImageLabels(ctx, id, henri.LabelPerson, m.people)
+	}
+	return nil
+}
+
+// xmpTemplate is the sidecar written for images without one.
+const xmpTemplate = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="">
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+`
+
+// xmpProperty is a property written to a sidecar. A single value is written
+// as text, or as a language alternative if alt is set, more as a bag.
+type xmpProperty struct {
+	name   xml.Name
+	prefix string
+	values []string
+	alt    bool
+}
+
+// xmpProperties formats props as children of an rdf:Description element, in
+// which rdf is the prefix of the RDF namespace. Each declares its own
+// namespace so they can be added to any sidecar.
+func xmpProperties(props []xmpProperty, rdf string) []byte {
+	var b bytes.Buffer
+	esc := func(s string) string {
+		var sb strings.Builder
+		xml.EscapeText(&sb, []byte(s))
+		return sb.String()
+	}
+	for _, p := range props {
+		p.values = slices.DeleteFunc(slices.Clone(p.values), func(v string) bool { return v == "" })
+		if len(p.values) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n   <%s:%s xmlns:%s=%q>", p.prefix, p.name.Local, p.prefix, p.name.Space)
+		switch {
+		case p.alt:
+			fmt.Fprintf(&b, "\n    <%s:Alt>\n     <%s:li xml:lang=\"x-default\">%s</%s:li>\n    </%s:Alt>\n   ",
+				rdf, rdf, esc(p.values[0]), rdf, rdf)
+		case len(p.values) == 1 && p.name.Space == nsHenri:
+			b.WriteString(esc(p.values[0]))
+		default:
+			fmt.Fprintf(&b, "\n    <%s:Bag>", rdf)
+			for _, v := range p.values {
+				fmt.Fprintf(&b, "\n     <%s:li>%s</%s:li>", rdf, esc(v), rdf)
+			}
+			fmt.Fprintf(&b, "\n    </%s:Bag>\n   ", rdf)
+		}
+		fmt.Fprintf(&b, "</%s:%s>", p.prefix, p.name.Local)
+	}
+	return b.Bytes()
+}
+
+// updateXMP returns the XMP packet data with props replacing any existing
+// values of the same properties, which are added to its first
+// rdf:Description. Everything else in data is kept as it is.
+func updateXMP(data []byte, props []xmpProperty) ([]byte, error) {
+	replaced := func(name xml.Name) bool {
+		return slices.ContainsFunc(props, func(p xmpProperty) bool { return p.name == name })
+	}
+
+	var (
+		d = xml.NewDecoder(bytes.NewReader(data))
+		// Prefixes are resolved without scoping, XMP declares them once
+		ns        = map[string]string{"xml": "http://www.w3.org/XML/1998/namespace"}
+		cuts      [][2]int64
+		depth     int
+		descDepth int // of the rdf:Description being read, 0 if none
+
+		// The insert replaces data[insertAt:insertEnd]
+		insertAt, insertEnd int64 = -1, -1
+		insert              []byte
+	)
+	for {
+		start := d.InputOffset()
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					ns[a.Name.Local] = a.Value
+				}
+			}
+			name := xml.Name{Space: ns[t.Name.Space], Local: t.Name.Local}
+
+			if descDepth > 0 && depth == descDepth+1 && replaced(name) {
+				// Skip to the end of the property, with the whitespace
+				// before it
+				for n := 1; n > 0; {
+					tok, err := d.RawToken()
+					if err != nil {
+						return nil, err
+					}
+					switch tok.(type) {
+					case xml.StartElement:
+						n++
+					case xml.EndElement:
+						n--
+					}
+				}
+				for start > 0 && strings.ContainsRune(" \t", rune(data[start-1])) {
+					start--
+				}
+				if start > 0 && data[start-1] == '\n' {
+					start--
+				}
+				cuts = append(cuts, [2]int64{start, d.InputOffset()})
+				depth--
+				continue
+			}
+
+			if name == xmpDescription {
+				descDepth = depth
+				end := d.InputOffset()
+				if insertAt < 0 && bytes.HasSuffix(data[:end], []byte("/>")) {
+					// <rdf:Description .../> is opened to add the
+					// properties
+					insertAt, insertEnd = end-2, end
+					insert = slices.Concat([]byte(">"), xmpProperties(props, t.Name.Space),
+						[]byte("\n  </"+t.Name.Space+":"+t.Name.Local+">"))
+				} else if insertAt < 0 {
+					insert = xmpProperties(props, t.Name.Space)
+				}
+			}
+		case xml.EndElement:
+			if depth == descDepth {
+				descDepth = 0
+				if insertEnd < 0 {
+					// Before the whitespace ahead of the end tag
+					insertAt = start
+					for insertAt > 0 && strings.ContainsRune(" \t\r\n", rune(data[insertAt-1])) {
+						insertAt--
+					}
+					insertEnd = insertAt
+				}
+			}
+			depth--
+		}
+	}
+	if insertAt < 0 {
+		return nil, errors.New("no rdf:Description in XMP")
+	}
+
+	var (
+		out bytes.Buffer
+		pos int64
+	)
+	emit := func(to int64) {
+		if to > pos {
+			out.Write(data[pos:to])
+			pos = to
+		}
+	}
+	inserted := false
+	for _, c := range cuts {
+		if !inserted && insertAt <= c[0] {
+			emit(insertAt)
+			out.Write(insert)
+			pos, inserted = insertEnd, true
+		}
+		emit(c[0])
+		pos = c[1]
+	}
+	if !inserted {
+		emit(insertAt)
+		out.Write(insert)
+		pos = insertEnd
+	}
+	emit(int64(len(data)))
+	return out.Bytes(), nil
+}
+
+// writebackResult counts what runWriteback did.
+type writebackResult struct {
+	Written   int // sidecars written
+	Unchanged int // sidecars already up to date
+	Skipped   int // missing files and images in Photos libraries
+}
+
+// writebackCommand runs henri writeback.
+func writebackCommand(ctx context.Context, h *henri.Henri) error {
+	res, err := runWriteback(ctx, h.DB)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d sidecars, %d were up to date, skipped %d images\n", res.Written, res.Unchanged, res.Skipped)
+	return nil
+}
+
+// runWriteback writes the description of every described image, with its
+// keywords and people, to the image's XMP sidecar for other photo tools to
+// use. A sidecar is created next to images without one. The description is
+// written as the caption unless the image already has one. Images in Apple
+// Photos libraries are skipped, Photos doesn't read sidecars.
+func runWriteback(ctx context.Context, db *henri.DB) (*writebackResult, error) {
+	images, err := db.DescribedImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &writebackResult{}
+	for _, img := range images {
+		if ctx.Err() != nil || lameduck {
+			return res, errors.New("interrupted while writing sidecars")
+		}
+		if strings.Contains(img.Path, ".photoslibrary"+string(filepath.Separator)) {
+			res.Skipped++
+			continue
+		}
+		if _, err := os.Stat(img.Path); errors.Is(err, fs.ErrNotExist) {
+			res.Skipped++
+			continue
+		}
+
+		written, err := writeSidecar(ctx, db, img)
+		if err != nil {
+			return res, fmt.Errorf("%s - %w", img.Path, err)
+		}
+		if written {
+			res.Written++
+		} else {
+			res.Unchanged++
+		}
+	}
+	return res, nil
+}
+
+// writeSidecar writes img's description, keywords and people to its XMP
+// sidecar, returning false if the sidecar was already up to date.
+func writeSidecar(ctx context.Context, db *henri.DB, img *henri.Image) (bool, error) {
+	path := findSidecar(img.Path)
+	data := []byte(xmpTemplate)
+	existing := &fileMetadata{}
+	if path == "" {
+		path = img.Path + ".xmp"
+	} else {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return false, err
+		}
+		if existing, err = parseXMP(data); err != nil {
+			return false, err
+		}
+	}
+
+	labels, err := db.ImageLabels(ctx, img.Id)
+	if err != nil {
+		return false, err
+	}
+	var keywords, people []string
+	for _, l := range labels {
+		switch l.Kind {
+		case henri.LabelKeyword:
+			keywords = append(keywords, l.Name)
+		case henri.LabelPerson:
+			people = append(people, l.Name)
+		}
+	}
+
+	props := []xmpProperty{
+		{name: xmpCaption, prefix: "dc", values: []string{cmp.Or(existing.caption, img.Caption, img.Description)}, alt: true},
+		{name: xmpKeywords, prefix: "dc", values: mergeNames(existing.keywords, keywords)},
+		{name: xmpPeople, prefix: "Iptc4xmpExt", values: mergeNames(existing.people, people)},
+		{name: xmpHenri, prefix: "henri", values: []string{img.Description}},
+		{name: xmpHenriModel, prefix: "henri", values: []string{img.Model}},
+	}
+	updated, err := updateXMP(data, props)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, updated) {
+		return false, nil
+	}
+
+	// Replace the sidecar atomically, other tools may be reading it
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".henri-*.xmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(updated); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), path)
+}
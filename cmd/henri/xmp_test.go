@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chriskillpack/henri"
+)
+
+// addJPEGSegments inserts APPn segments with the given markers and payloads
+// after the start of image marker of the JPEG at path.
+func addJPEGSegments(t *testing.T, path string, markers []byte, payloads [][]byte) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segs := data[:2:2]
+	for i, payload := range payloads {
+		n := len(payload) + 2
+		segs = append(segs, 0xff, markers[i], byte(n>>8), byte(n))
+		segs = append(segs, payload...)
+	}
+	if err := os.WriteFile(path, append(segs, data[2:]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testXMPHeader = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+`
+
+const testXMPFooter = `
+ </rdf:RDF>
+</x:xmpmeta>
+`
+
+func TestFileMetadata(t *testing.T) {
+	library := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"} {
+		writeJPEG(t, filepath.Join(library, name), 16, 8, color.RGBA{255, 0, 0, 255})
+	}
+
+	// a.jpg has embedded XMP keywords and an IPTC caption and keywords,
+	// after another Photoshop resource
+	iim := func(record, dataset byte, value string) []byte {
+		return append([]byte{0x1c, record, dataset, byte(len(value) >> 8), byte(len(value))}, value...)
+	}
+	records := slices.Concat(iim(1, 90, "\x1b%G"), iim(2, 120, "Sunset at the beach"), iim(2, 25, "sunset"), iim(2, 25, "beach"))
+	resources := slices.Concat(
+		[]byte("8BIM\x03\xed\x00\x00\x00\x00\x00\x03abc\x00"),
+		[]byte("8BIM\x04\x04\x00\x00"), []byte{0, 0, byte(len(records) >> 8), byte(len(records))}, records)
+	embedded := testXMPHeader + `  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <dc:subject><rdf:Bag><rdf:li>beach</rdf:li><rdf:li>summer</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>` + testXMPFooter
+	addJPEGSegments(t, filepath.Join(library, "a.jpg"), []byte{0xe1, 0xed},
+		[][]byte{[]byte(jpegXMPPrefix + embedded), []byte(jpegPhotoshopPrefix + string(resources))})
+
+	// b.jpg has a digiKam sidecar and c.jpg a Lightroom one
+	sidecars := map[string]string{
+		"b.jpg.xmp": testXMPHeader + `  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:Iptc4xmpExt="http://iptc.org/std/Iptc4xmpExt/2008-02-29/"
+    xmp:Rating="4">
+   <xmp:Label>Red</xmp:Label>
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Sam on the pier</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>pier</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <Iptc4xmpExt:PersonInImage>
+    <rdf:Bag>
+     <rdf:li>Sam</rdf:li>
+    </rdf:Bag>
+   </Iptc4xmpExt:PersonInImage>
+  </rdf:Description>` + testXMPFooter,
+		"c.xmp": testXMPHeader + `  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="3"/>` + testXMPFooter,
+	}
+	for name, xmp := range sidecars {
+		if err := os.WriteFile(filepath.Join(library, name), []byte(xmp), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := newTestHenri(t, AppModePipeline)
+
+	type metadata struct {
+		caption          string
+		keywords, people []string
+	}
+	expected := map[string]metadata{
+		"a.jpg": {"Sunset at the beach", []string{"beach", "summer", "sunset"}, nil},
+		"b.jpg": {"Sam on the pier", []string{"pier"}, []string{"Sam"}},
+		"c.jpg": {},
+		"d.jpg": {},
+	}
+	checkMetadata := func() {
+		t.Helper()
+
+		roots, err := h.DB.Roots(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := h.DB.ImageIdsByPath(t.Context(), roots[0].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, md := range expected {
+			img, err := h.DB.GetImage(t.Context(), ids[name])
+			if err != nil {
+				t.Fatal(err)
+			}
+			labels, err := h.DB.ImageLabels(t.Context(), img.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual metadata
+			actual.caption = img.Caption
+			for _, l := range labels {
+				switch l.Kind {
+				case henri.LabelKeyword:
+					actual.keywords = append(actual.keywords, l.Name)
+				case henri.LabelPerson:
+					actual.people = append(actual.people, l.Name)
+				}
+			}
+			if md.caption != actual.caption || !slices.Equal(md.keywords, actual.keywords) || !slices.Equal(md.people, actual.people) {
+				t.Errorf("%s: expected %+v, got %+v", name, md, actual)
+			}
+		}
+	}
+
+	if err := runPipeline(t.Context(), library, h); err != nil {
+		t.Fatal(err)
+	}
+	checkMetadata()
+
+	img, err := h.DB.GetImage(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := embeddingText(t.Context(), img, h.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := img.Description+"\nSunset at the beach\nKeywords: beach, summer, sunset", text; expected != actual {
+		t.Errorf("Expected embedding text %q, got %q", expected, actual)
+	}
+
+	ts := httptest.NewServer(NewServer(h, "0").serveHandler())
+	defer ts.Close()
+	for query, expected := range map[string]int{"&keyword=beach": 1, "&keyword=pier&person=Sam": 1, "&keyword=snow": -1} {
+		resp, err := http.Get(ts.URL + "/search?q=image" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected < 0 {
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, resp.StatusCode)
+			}
+			continue
+		}
+		if actual := strings.Count(string(body), `src="/image/`); expected != actual {
+			t.Errorf("%s: expected %d results, got %d", query, expected, actual)
+		}
+	}
+
+	// Writing back twice only writes the first time
+	for _, expected := range []writebackResult{{Written: 4}, {Unchanged: 4}} {
+		res, err := runWriteback(t.Context(), h.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := *res; expected != actual {
+			t.Errorf("Expected %+v, got %+v", expected, actual)
+		}
+	}
+
+	images, err := h.DB.DescribedImages(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := map[string]string{}
+	for _, img := range images {
+		desc[img.RelPath] = img.Description
+	}
+	for _, tc := range []struct {
+		name     string
+		expected fileMetadata
+		keep     string
+	}{
+		{"a.jpg.xmp", fileMetadata{caption: "Sunset at the beach", keywords: []string{"beach", "summer", "sunset"}, henri: desc["a.jpg"]}, ""},
+		{"b.jpg.xmp", fileMetadata{caption: "Sam on the pier", keywords: []string{"pier"}, people: []string{"Sam"}, henri: desc["b.jpg"]}, `<xmp:Label>Red</xmp:Label>`},
+		{"c.xmp", fileMetadata{henri: desc["c.jpg"]}, `xmp:Rating="3"`},
+		{"d.jpg.xmp", fileMetadata{henri: desc["d.jpg"]}, ""},
+	} {
+		data, err := os.ReadFile(filepath.Join(library, tc.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := parseXMP(data)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if expected, actual := tc.expected, *m; expected.caption != actual.caption || expected.henri != actual.henri ||
+			!slices.Equal(expected.keywords, actual.keywords) || !slices.Equal(expected.people, actual.people) {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, expected, actual)
+		}
+		if !strings.Contains(string(data), tc.keep) {
+			t.Errorf("%s: expected %s to be kept, got %s", tc.name, tc.keep, data)
+		}
+	}
+
+	// Scanning again reads the same metadata back, so nothing needs to be
+	// embedded again
+	if _, err := findAndInsertImageFiles(t.Context(), library, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	checkMetadata()
+	missing, err := h.DB.DescribedImagesMissingEmbeddings(t.Context(), h.Embedder.Model())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, len(missing); expected != actual {
+		t.Errorf("Expected %d images to embed, got %d", expected, actual)
+	}
+
+	// A new keyword does
+	sidecar := filepath.Join(library, "d.jpg.xmp")
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = updateXMP(data, []xmpProperty{{name: xmpKeywords, prefix: "dc", values: []string{"snow"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sidecar, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findAndInsertImageFiles(t.Context(), library, h.DB); err != nil {
+		t.Fatal(err)
+	}
+	expected["d.jpg"] = metadata{keywords: []string{"snow"}}
+	checkMetadata()
+	if missing, err = h.DB.DescribedImagesMissingEmbeddings(t.Context(), h.Embedder.Model()); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 1, len(missing); expected != actual {
+		t.Errorf("Expected %d images to embed, got %d", expected, actual)
+	}
+}
+
+func TestUpdateXMP(t *testing.T) {
+	props := []xmpProperty{
+		{name: xmpCaption, prefix: "dc", values: []string{"A <red> square"}, alt: true},
+		{name: xmpKeywords, prefix: "dc", values: []string{"red", "square"}},
+	}
+	tests := []struct {
+		name string
+		xmp  string
+	}{
+		{"empty", xmpTemplate},
+		{"self closing", testXMPHeader + `<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="3"/>` + testXMPFooter},
+		{"replaced", testXMPHeader + `<rdf:Description rdf:about="" xmlns:d="http://purl.org/dc/elements/1.1/">
+   <d:subject><rdf:Bag><rdf:li>blue</rdf:li></rdf:Bag></d:subject>
+   <d:title><rdf:Alt><rdf:li xml:lang="x-default">Square</rdf:li></rdf:Alt></d:title>
+  </rdf:Description>` + testXMPFooter},
+		{"exiftool", testXMPHeader + `<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+   <xmp:Rating>3</xmp:Rating>
+  </rdf:Description>
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">Old</rdf:li></rdf:Alt></dc:description>
+  </rdf:Description>` + testXMPFooter},
+	}
+	for _, tc := range tests {
+		data, err := updateXMP([]byte(tc.xmp), props)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		m, err := parseXMP(data)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", tc.name, err, data)
+		}
+		if expected, actual := "A <red> square", m.caption; expected != actual {
+			t.Errorf("%s: expected caption %q, got %q\n%s", tc.name, expected, actual, data)
+		}
+		if expected, actual := []string{"red", "square"}, m.keywords; !slices.Equal(expected, actual) {
+			t.Errorf("%s: expected keywords %v, got %v\n%s", tc.name, expected, actual, data)
+		}
+		if strings.Contains(tc.xmp, "Rating") && !strings.Contains(string(data), "Rating") {
+			t.Errorf("%s: expected the rating to be kept, got %s", tc.name, data)
+		}
+		if strings.Contains(tc.xmp, "d:title") && !strings.Contains(string(data), "<d:title>") {
+			t.Errorf("%s: expected the title to be kept, got %s", tc.name, data)
+		}
+
+		// Updating again changes nothing
+		again, err := updateXMP(data, props)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Errorf("%s: expected an update to be stable, got\n%s\nthen\n%s", tc.name, data, again)
+		}
+	}
+}
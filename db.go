@@ -3,21 +3,38 @@ package henri
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
 	_ "embed"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/tailscale/squibble"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
 )
 
 //go:embed db/latest_schema.sql
 var dbSchema string
 
+func init() {
+	// contains_words(text, phrase) is 1 if the words of phrase appear in
+	// order in text, for keyword filters
+	sqlite.MustRegisterDeterministicScalarFunction("contains_words", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		text, _ := args[0].(string)
+		phrase, _ := args[1].(string)
+		return containsWords(text, phrase), nil
+	})
+}
+
 var schema = &squibble.Schema{
 	Current: dbSchema,
 
@@ -108,6 +125,264 @@ var schema = &squibble.Schema{
 				`ALTER TABLE images ADD COLUMN image_height INTEGER;`,
 			),
 		},
+
+		{
+			Source: "201fecb0f6becf7a2afb22abb0b470b514de6b820ea7a4920812172a67f1e0e9",
+			Target: "e41b10d8e248134e8187d0b15552de9cbd9af447ea47f1072f646355f44d4c1a",
+			Apply: squibble.Exec(
+				`CREATE TABLE jobs (
+					id INTEGER NOT NULL PRIMARY KEY,
+					kind VARCHAR NOT NULL,
+					arg TEXT NOT NULL DEFAULT '',
+					state VARCHAR NOT NULL,
+					total INTEGER NOT NULL DEFAULT 0,
+					done INTEGER NOT NULL DEFAULT 0,
+					errors INTEGER NOT NULL DEFAULT 0,
+					message TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP NOT NULL,
+					started_at TIMESTAMP,
+					finished_at TIMESTAMP
+				)`,
+			),
+		},
+
+		{
+			Source: "e41b10d8e248134e8187d0b15552de9cbd9af447ea47f1072f646355f44d4c1a",
+			Target: "4ebbb0929e8ffc5f40e5c4005d50f04d9431c6ab8b23e402b3ba9974155014c9",
+			Apply: squibble.Exec(
+				`ALTER TABLE images ADD COLUMN describe_ms INTEGER;`,
+			),
+		},
+
+		{
+			Source: "4ebbb0929e8ffc5f40e5c4005d50f04d9431c6ab8b23e402b3ba9974155014c9",
+			Target: "19a89bec9496472fd32905834082ba481bd341e954f0f96da2d39ebb7a247452",
+			Apply: squibble.Exec(
+				`ALTER TABLE images ADD COLUMN content_hash VARCHAR;`,
+				`CREATE INDEX images_content_hash_index
+				 ON images(content_hash);`,
+			),
+		},
+
+		// Image paths become relative to a library root, so image_path is
+		// only unique within a root. SQLite can't drop the UNIQUE constraint
+		// so the table is rebuilt. Existing absolute paths are kept with a
+		// NULL root_id until a scan adopts them into a root.
+		{
+			Source: "19a89bec9496472fd32905834082ba481bd341e954f0f96da2d39ebb7a247452",
+			Target: "92f9e3b9cf6ae64617ad91e8f38d9965df014b539c2611725bb3ffd9096e876c",
+			Apply: squibble.Exec(
+				`CREATE TABLE roots (
+					id INTEGER NOT NULL PRIMARY KEY,
+					name VARCHAR UNIQUE NOT NULL,
+					path TEXT NOT NULL
+				)`,
+				`CREATE TABLE images_new (
+					id INTEGER NOT NULL PRIMARY KEY,
+					image_path TEXT NOT NULL,
+					image_mtime TIMESTAMP NOT NULL,
+					image_description TEXT,
+					processed_at TIMESTAMP,
+					attempted_at TIMESTAMP,
+					describer VARCHAR,
+					model VARCHAR,
+					image_width INTEGER,
+					image_height INTEGER,
+					describe_ms INTEGER,
+					content_hash VARCHAR,
+					root_id INTEGER
+				)`,
+				`INSERT INTO images_new (id, image_path, image_mtime,
+					image_description, processed_at, attempted_at, describer,
+					model, image_width, image_height, describe_ms, content_hash)
+				 SELECT id, image_path, image_mtime, image_description,
+					processed_at, attempted_at, describer, model, image_width,
+					image_height, describe_ms, content_hash
+				 FROM images`,
+				`DROP TABLE images`,
+				`ALTER TABLE images_new RENAME TO images`,
+				`CREATE UNIQUE INDEX images_root_id_image_path_index
+				 ON images(root_id,image_path)`,
+				`CREATE INDEX images_content_hash_index
+				 ON images(content_hash)`,
+			),
+		},
+
+		{
+			Source: "92f9e3b9cf6ae64617ad91e8f38d9965df014b539c2611725bb3ffd9096e876c",
+			Target: "5eaf34328e8c934d7ee064bc2daf420d4aa50fc85c45d7d8c7ef3c9f1f4b22c0",
+			Apply: squibble.Exec(
+				`CREATE TABLE collections (
+					id INTEGER NOT NULL PRIMARY KEY,
+					name VARCHAR UNIQUE NOT NULL,
+					describer TEXT NOT NULL DEFAULT '',
+					prompt TEXT NOT NULL DEFAULT '',
+					include TEXT NOT NULL DEFAULT '',
+					exclude TEXT NOT NULL DEFAULT ''
+				)`,
+				`ALTER TABLE roots ADD COLUMN collection_id INTEGER REFERENCES collections(id);`,
+				`ALTER TABLE images ADD COLUMN collection_id INTEGER REFERENCES collections(id);`,
+				`CREATE INDEX images_collection_id_index
+				 ON images(collection_id);`,
+			),
+		},
+
+		{
+			Source: "5eaf34328e8c934d7ee064bc2daf420d4aa50fc85c45d7d8c7ef3c9f1f4b22c0",
+			Target: "9c2f8e74c9b35a02ffcc0f49becd6b8180e494527124b51cf311cdb4d3746d2c",
+			Apply: squibble.Exec(
+				`ALTER TABLE images ADD COLUMN taken_at TIMESTAMP;`,
+				`ALTER TABLE images ADD COLUMN favorite INTEGER NOT NULL DEFAULT 0;`,
+				`CREATE TABLE labels (
+					id INTEGER NOT NULL PRIMARY KEY,
+					kind VARCHAR NOT NULL,
+					name VARCHAR NOT NULL
+				)`,
+				`CREATE UNIQUE INDEX labels_kind_name_index
+				 ON labels(kind,name);`,
+				`CREATE TABLE image_labels (
+					image_id INTEGER NOT NULL REFERENCES images(id),
+					label_id INTEGER NOT NULL REFERENCES labels(id),
+					PRIMARY KEY (image_id,label_id)
+				)`,
+			),
+		},
+
+		{
+			Source: "9c2f8e74c9b35a02ffcc0f49becd6b8180e494527124b51cf311cdb4d3746d2c",
+			Target: "146f7e2bbff8d2acce2dc915acc18c3b771a8bafbcc17554c364ccb863e5f6d3",
+			Apply: squibble.Exec(
+				`ALTER TABLE images ADD COLUMN caption TEXT NOT NULL DEFAULT '';`,
+			),
+		},
+
+		// Existing descriptions become the first version in their history.
+		{
+			Source: "146f7e2bbff8d2acce2dc915acc18c3b771a8bafbcc17554c364ccb863e5f6d3",
+			Target: "68698ff37f4df6b0e95b9b3c0761a3c71f94602e059f7cb3001429780004e064",
+			Apply: squibble.Exec(
+				`ALTER TABLE images ADD COLUMN edited_at TIMESTAMP;`,
+				`CREATE TABLE description_history (
+					id INTEGER NOT NULL PRIMARY KEY,
+					image_id INTEGER NOT NULL REFERENCES images(id),
+					description TEXT NOT NULL,
+					author VARCHAR NOT NULL,
+					human INTEGER NOT NULL DEFAULT 0,
+					created_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE INDEX description_history_image_id_index
+				 ON description_history(image_id);`,
+				`INSERT INTO description_history (image_id, description, author, created_at)
+				 SELECT id, image_description,
+					TRIM(COALESCE(describer, '') || ' ' || COALESCE(model, '')),
+					COALESCE(processed_at, image_mtime)
+				 FROM images
+				 WHERE image_description IS NOT NULL`,
+			),
+		},
+
+		// Each image's description becomes the first in the descriptions
+		// table, recorded with the default prompt as the prompt isn't known.
+		// Edited descriptions are recorded as the edited model's, the model's
+		// original is in the history. Embeddings are linked to the
+		// description they were computed from.
+		{
+			Source: "68698ff37f4df6b0e95b9b3c0761a3c71f94602e059f7cb3001429780004e064",
+			Target: "6ea7064d57ac97da019308a08f61806e3ebe275b2c13369a1eb51888b1d593e9",
+			Apply: squibble.Exec(
+				`CREATE TABLE descriptions (
+					id INTEGER NOT NULL PRIMARY KEY,
+					image_id INTEGER NOT NULL REFERENCES images(id),
+					model VARCHAR NOT NULL,
+					prompt_version VARCHAR NOT NULL DEFAULT '',
+					describer VARCHAR NOT NULL DEFAULT '',
+					description TEXT NOT NULL,
+					processed_at TIMESTAMP NOT NULL,
+					describe_ms INTEGER
+				)`,
+				`CREATE UNIQUE INDEX descriptions_image_id_model_prompt_version_index
+				 ON descriptions(image_id,model,prompt_version);`,
+				`INSERT INTO descriptions (image_id, model, describer, description, processed_at, describe_ms)
+				 SELECT id,
+					CASE WHEN edited_at IS NULL THEN COALESCE(model, '') ELSE 'edited' END,
+					CASE WHEN edited_at IS NULL THEN COALESCE(describer, '') ELSE '' END,
+					image_description, COALESCE(edited_at, processed_at, image_mtime), describe_ms
+				 FROM images
+				 WHERE image_description IS NOT NULL`,
+				`ALTER TABLE images ADD COLUMN description_id INTEGER REFERENCES descriptions(id);`,
+				`UPDATE images
+				 SET description_id=(SELECT d.id FROM descriptions d WHERE d.image_id=images.id)`,
+				`ALTER TABLE embeddings ADD COLUMN description_id INTEGER REFERENCES descriptions(id);`,
+				`UPDATE embeddings
+				 SET description_id=(SELECT i.description_id FROM images i WHERE i.id=embeddings.image_id)`,
+				`DROP INDEX embeddings_image_id_model_index;`,
+				`CREATE UNIQUE INDEX embeddings_description_id_model_index
+				 ON embeddings(description_id,model);`,
+				`CREATE INDEX embeddings_image_id_index
+				 ON embeddings(image_id);`,
+			),
+		},
+		{
+			Source: "6ea7064d57ac97da019308a08f61806e3ebe275b2c13369a1eb51888b1d593e9",
+			Target: "91ba9a4c925a7a22a038b4c958f0c31b312038540a957917d403858b95b80533",
+			Apply: squibble.Exec(
+				`CREATE TABLE feedback (
+					id INTEGER NOT NULL PRIMARY KEY,
+					query TEXT NOT NULL,
+					image_id INTEGER NOT NULL REFERENCES images(id),
+					model VARCHAR NOT NULL,
+					relevant INTEGER NOT NULL,
+					created_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE UNIQUE INDEX feedback_query_image_id_model_index
+				 ON feedback(query,image_id,model);`,
+			),
+		},
+		// Relevance scores of descriptions to queries by a re-ranking model,
+		// keyed by a hash of the description so a changed description is
+		// scored again.
+		{
+			Source: "91ba9a4c925a7a22a038b4c958f0c31b312038540a957917d403858b95b80533",
+			Target: "8f784bc7a4c47e6f32c84b486841f4a3b612901e7e3bb619c57bedd2a9274aa7",
+			Apply: squibble.Exec(
+				`CREATE TABLE relevance (
+					id INTEGER NOT NULL PRIMARY KEY,
+					query TEXT NOT NULL,
+					description_hash VARCHAR NOT NULL,
+					model VARCHAR NOT NULL,
+					score REAL NOT NULL,
+					created_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE UNIQUE INDEX relevance_query_description_hash_model_index
+				 ON relevance(query,description_hash,model);`,
+			),
+		},
+		// Saved searches, and the images matching them when they were last
+		// evaluated.
+		{
+			Source: "8f784bc7a4c47e6f32c84b486841f4a3b612901e7e3bb619c57bedd2a9274aa7",
+			Target: "4a24e4cd3feef42ecd66e867a31a17c9bab3cb4b43c7ca02a12172a9910625af",
+			Apply: squibble.Exec(
+				`CREATE TABLE saved_searches (
+					id INTEGER NOT NULL PRIMARY KEY,
+					name VARCHAR NOT NULL,
+					query TEXT NOT NULL,
+					filter TEXT NOT NULL DEFAULT '',
+					threshold REAL NOT NULL,
+					model VARCHAR NOT NULL DEFAULT '',
+					evaluated_at TIMESTAMP,
+					created_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE UNIQUE INDEX saved_searches_name_index
+				 ON saved_searches(name);`,
+				`CREATE TABLE saved_search_images (
+					search_id INTEGER NOT NULL REFERENCES saved_searches(id),
+					image_id INTEGER NOT NULL REFERENCES images(id),
+					score REAL NOT NULL,
+					PRIMARY KEY (search_id, image_id)
+				)`,
+			),
+		},
 	},
 }
 
@@ -119,17 +394,28 @@ type DB struct {
 	filepath string
 }
 
-// Image is an in-memory representation of a row in the images table.
+// Image is an in-memory representation of a row in the images table. The
+// description fields are those of the image's current description, which
+// search uses unless it is given a description set.
 type Image struct {
 	Id            int
-	Path          string
+	Path          string // absolute, resolved through the library root
+	RelPath       string // relative to the library root, empty if not in one
 	PathMTime     time.Time
 	Description   string
+	DescriptionId int // row in the descriptions table, 0 if not described
 	ProcessedAt   sql.NullTime
 	AttemptedAt   sql.NullTime
 	Model         string
 	Describer     string
 	Width, Height sql.NullInt16
+	DescribeTime  time.Duration // time taken to describe, zero if unknown
+	Hash          string        // hex SHA-256 of the file, empty if unknown
+	CollectionId  int           // 0 if not in a collection
+	TakenAt       time.Time     // when the photo was taken, zero if unknown
+	Favorite      bool
+	Caption       string    // from the image file or its XMP sidecar
+	EditedAt      time.Time // when a person last edited the description, zero if never
 
 	Embedding *Embedding // optional reference
 }
@@ -145,14 +431,140 @@ type Embedding struct {
 	Image *Image // parent image
 }
 
+// EditedModel is the model of descriptions written by a person.
+const EditedModel = "edited"
+
+// DescriptionSet identifies the descriptions by a model with a prompt. An
+// image has at most one description in each set.
+type DescriptionSet struct {
+	Model         string
+	PromptVersion string // empty for the default prompt, see describer.PromptVersion
+
+	Images int // number of images described, set by DescriptionSets
+}
+
+// String returns the set's model, followed by @ and the prompt version unless
+// it is the default prompt.
+func (ds DescriptionSet) String() string {
+	if ds.PromptVersion == "" {
+		return ds.Model
+	}
+	return ds.Model + "@" + ds.PromptVersion
+}
+
+// DescriptionVersion is an in-memory representation of a row in the
+// description_history table, one version of an image's description.
+type DescriptionVersion struct {
+	Id          int
+	ImageId     int
+	Description string
+	Author      string // describer and model, or the person who wrote it
+	Human       bool
+	CreatedAt   time.Time
+}
+
+// Feedback is an in-memory representation of a row in the feedback table, a
+// judgement of whether an image is a good result for a query searched with an
+// embedding model.
+type Feedback struct {
+	Id        int
+	Query     string
+	ImageId   int
+	Model     string
+	Relevant  bool
+	CreatedAt time.Time
+}
+
+// SavedSearch is an in-memory representation of a row in the saved_searches
+// table, a search kept to be shown as a smart album. Its images are those
+// scoring at least Threshold when it was last evaluated.
+type SavedSearch struct {
+	Id        int
+	Name      string
+	Query     string
+	Filter    string // search params, e.g. album=Holidays&favorites=true
+	Threshold float32
+	Model     string       // embedding model it was evaluated with
+	Evaluated sql.NullTime // not valid until evaluated
+	CreatedAt time.Time
+	Images    int // number of images matching
+}
+
 // ImagePath collects together all the info to be inserted into the images table
 // by InsertImagePaths().
 type ImagePath struct {
-	Path          string
+	RootId        int    // library root, 0 if Path is absolute
+	CollectionId  int    // 0 if not in a collection
+	Path          string // relative to the library root
 	Modtime       time.Time
 	Width, Height int
+	Hash          string // hex SHA-256 of the file contents
+}
+
+// Root is a library root, a directory that image paths are relative to.
+type Root struct {
+	Id           int
+	Name         string
+	Path         string // absolute
+	CollectionId int    // 0 if not in a collection
+}
+
+// Collection is a named group of library roots sharing the settings used to
+// scan and describe them. Empty settings fall back to the command line.
+type Collection struct {
+	Id        int
+	Name      string
+	Describer string   // backend URI to describe images with
+	Prompt    string   // prompt to describe images with
+	Include   []string // glob patterns of the image paths to scan
+	Exclude   []string // glob patterns of the image paths to skip
+
+	Images int // number of images, set by Collections
+}
+
+// Label kinds. Labels are imported from other photo apps, or from keywords in
+// the image files.
+const (
+	LabelAlbum   = "album"
+	LabelPerson  = "person"
+	LabelKeyword = "keyword"
+)
+
+// Label is a name given to a set of images, such as an album or a person in
+// them.
+type Label struct {
+	Id     int
+	Kind   string
+	Name   string
+	Images int // number of images with the label
+}
+
+// ImageFilter limits the images searched. The zero value matches every image.
+type ImageFilter struct {
+	Collections []int // in any of these collections
+	Labels      []int // with all of these labels
+	Favorites   bool  // only favourites
+
+	TakenAfter, TakenBefore time.Time // zero for no limit
+
+	// Words or phrases the description must contain, ignoring case and
+	// punctuation. Words only match whole words.
+	Keywords []string
+
+	// Descriptions searched, each image's current description if Model is
+	// empty.
+	Descriptions DescriptionSet
 }
 
+// SQL to select the absolute path of an image, resolved through its library
+// root, and the path relative to the root. Queries using them alias images as
+// i and join roots with rootsJoin.
+const (
+	imagePathSQL    = `COALESCE(r.path || '/' || i.image_path, i.image_path)`
+	imageRelPathSQL = `CASE WHEN r.id IS NULL THEN '' ELSE i.image_path END`
+	rootsJoin       = `LEFT JOIN roots r ON i.root_id=r.id`
+)
+
 func (db *DB) Close() {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -162,10 +574,13 @@ func (db *DB) Close() {
 
 // NewDB creates a new instance of DB and connects to the specified SQLite
 // database. It configures the connection to use SQLite "time format" for all
-// TIMESTAMP columns.
+// TIMESTAMP columns, and to wait for locks held by other writers, e.g. the
+// describe and embed stages of the pipeline, rather than fail immediately.
+// Transactions take the write lock when they begin, as one that read first
+// could not wait for it without deadlocking.
 func NewDB(ctx context.Context, fname string) (*DB, error) {
 	// Open the DB but flip on the cleaner timestamps from Go
-	sqldb, err := sql.Open("sqlite", fname+"?_time_format=sqlite")
+	sqldb, err := sql.Open("sqlite", fname+"?_time_format=sqlite&_txlock=immediate&_pragma=busy_timeout(10000)")
 	if err != nil {
 		return nil, err
 	}
@@ -208,19 +623,26 @@ func (db *DB) InsertImagePaths(ctx context.Context, imagepaths []ImagePath, batc
 
 func buildInsertImagePathsBatchQuery(batch []ImagePath) (string, []any) {
 	var sb strings.Builder
-	sb.WriteString("INSERT OR IGNORE INTO images (image_path, image_mtime, image_width, image_height) VALUES")
-	values := make([]any, 0, len(batch)*4)
+	sb.WriteString("INSERT OR IGNORE INTO images (root_id, collection_id, image_path, image_mtime, image_width, image_height, content_hash) VALUES")
+	values := make([]any, 0, len(batch)*7)
 	placeholders := make([]string, len(batch))
 
 	for i, img := range batch {
-		start := i*4 + 1
-		placeholders[i] = fmt.Sprintf("($%d,$%d,$%d,$%d)", start, start+1, start+2, start+3)
+		start := i*7 + 1
+		placeholders[i] = fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d,$%d)", start, start+1, start+2, start+3, start+4, start+5, start+6)
 
+		var hash sql.NullString
+		if img.Hash != "" {
+			hash.String, hash.Valid = img.Hash, true
+		}
 		values = append(values,
+			nullId(img.RootId),
+			nullId(img.CollectionId),
 			img.Path,
 			img.Modtime,
 			img.Width,
-			img.Height)
+			img.Height,
+			hash)
 	}
 	sb.WriteString(" ")
 	sb.WriteString(strings.Join(placeholders, ","))
@@ -228,191 +650,1044 @@ func buildInsertImagePathsBatchQuery(batch []ImagePath) (string, []any) {
 	return sb.String(), values
 }
 
-// ImagesToDescribe returns Image models for all the images in the DB that lack
-// a description.
-func (db *DB) ImagesToDescribe(ctx context.Context) ([]*Image, error) {
-	rows, err := db.db.QueryContext(ctx, `
-		SELECT id, image_path, image_mtime, image_description
-		FROM images
-		WHERE processed_at IS NULL AND attempted_at IS NULL`)
+// nullId returns id as a nullable column value, where 0 is NULL.
+func nullId(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// EnsureRoot returns the library root called name, creating it at path if it
+// does not exist. It is an error for the root to exist at a different path,
+// use RelocateRoot to move it. Images inside path from before roots existed
+// are moved into the root. Their paths were stored as scanned, relative ones
+// are taken to be relative to the working directory.
+func (db *DB) EnsureRoot(ctx context.Context, name, path string) (*Root, error) {
+	root := &Root{Name: name, Path: path}
+	var collectionId sql.NullInt64
+	err := db.db.QueryRowContext(ctx, `
+		INSERT INTO roots (name, path)
+		VALUES ($1,$2)
+		ON CONFLICT (name) DO UPDATE SET name=excluded.name
+		RETURNING id, path, collection_id`,
+		name, path,
+	).Scan(&root.Id, &root.Path, &collectionId)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var images []*Image
-	for rows.Next() {
-		img := &Image{}
+	root.CollectionId = int(collectionId.Int64)
+	if root.Path != path {
+		return nil, fmt.Errorf("library root %q is at %s, not %s", name, root.Path, path)
+	}
 
-		var desc sql.NullString
-		err = rows.Scan(&img.Id, &img.Path, &img.PathMTime, &desc)
-		if err != nil {
-			return nil, err
-		}
-		if desc.Valid {
-			img.Description = desc.String
-		}
-		images = append(images, img)
+	// OR IGNORE leaves images that were also scanned into the root
+	// since where they are. Paths are compared as blobs as substr counts
+	// the characters of text, not the bytes.
+	prefix := path + "/"
+	_, err = db.db.ExecContext(ctx, `
+		UPDATE OR IGNORE images
+		SET root_id=$1, image_path=CAST(substr(CAST(image_path AS BLOB), $2) AS TEXT), collection_id=$5
+		WHERE root_id IS NULL AND substr(CAST(image_path AS BLOB), 1, $3)=CAST($4 AS BLOB)`,
+		root.Id, len(prefix)+1, len(prefix), prefix, collectionId)
+	if err != nil {
+		return nil, err
 	}
-	if err := rows.Err(); err != nil {
+	if err := db.adoptRelativePaths(ctx, root.Id, prefix, collectionId); err != nil {
 		return nil, err
 	}
 
-	return images, nil
-}
-
-// UpdateImage updates the associated row in the images table from the Image
-// model. Only the description, describer and processed_at columns are updated,
-// hence this function should be called after a successful image description has
-// been generated.
-func (db *DB) UpdateImage(ctx context.Context, img *Image, model, describer string) error {
-	_, err := db.db.ExecContext(ctx, `
-		UPDATE images SET image_description=$1,model=$2,describer=$3,
-				  processed_at=$4
-		WHERE id=$5`,
-		img.Description,
-		model,
-		describer,
-		img.ProcessedAt,
-		img.Id)
-	return err
-}
-
-// UpdateImageAttempted updates the attempted_at timestamp for an images row.
-func (db *DB) UpdateImageAttempted(ctx context.Context, id int, model, describer string, at time.Time) error {
-	_, err := db.db.ExecContext(ctx, `
-		UPDATE images SET attempted_at=$1,model=$2,describer=$3
-		WHERE id=$4`,
-		at,
-		model,
-		describer,
-		id)
-	return err
+	return root, nil
 }
 
-func (db *DB) GetImage(ctx context.Context, id int) (*Image, error) {
-	row := db.db.QueryRowContext(ctx, `
-		SELECT image_path, image_mtime, image_description, processed_at,
-		       attempted_at, describer, model, image_width, image_height
+// adoptRelativePaths moves the images with relative paths from before roots
+// existed that resolve to inside prefix into the root rootId.
+func (db *DB) adoptRelativePaths(ctx context.Context, rootId int, prefix string, collectionId sql.NullInt64) error {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, image_path
 		FROM images
-		WHERE id=$1`, id)
-
-	if row.Err() != nil {
-		return nil, row.Err()
+		WHERE root_id IS NULL AND image_path NOT LIKE '/%'`)
+	if err != nil {
+		return err
 	}
-
-	img := &Image{
-		Id: id,
+	adopt := map[int]string{}
+	for rows.Next() {
+		var (
+			id   int
+			path string
+		)
+		if err := rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			return err
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if rel, ok := strings.CutPrefix(abs, prefix); ok {
+			adopt[id] = rel
+		}
 	}
-	err := row.Scan(
-		&img.Path,
-		&img.PathMTime,
-		&img.Description,
-		&img.ProcessedAt,
-		&img.AttemptedAt,
-		&img.Describer,
-		&img.Model,
-		&img.Width,
-		&img.Height,
-	)
-	if err != nil {
-		return nil, err
+	rows.Close()
+	if err := rows.Err(); err != nil || len(adopt) == 0 {
+		return err
 	}
 
-	return img, nil
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	for id, rel := range adopt {
+		_, err := txn.ExecContext(ctx, `
+			UPDATE OR IGNORE images SET root_id=$1, image_path=$2, collection_id=$3
+			WHERE id=$4`,
+			rootId, rel, collectionId, id)
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
 }
 
-// DescribedImagesMissingEmbeddings finds all described images that do not have
-// a description embedding generated by the specified model. It returns the
-// images as Image models, with their Embedding associations blank.
-func (db *DB) DescribedImagesMissingEmbeddings(ctx context.Context, model string) ([]*Image, error) {
-	query := `
-		SELECT i.id, i.image_path, i.image_mtime, i.image_description,
-		       i.processed_at, i.attempted_at, i.model, i.describer,
-		       i.image_width, i.image_height
-		FROM images i
-		LEFT JOIN embeddings e ON i.id=e.image_id AND e.model=$1
-		WHERE i.image_description IS NOT NULL AND e.id IS NULL`
-
-	rows, err := db.db.QueryContext(ctx, query, model)
+// Roots returns all the library roots ordered by name.
+func (db *DB) Roots(ctx context.Context) ([]*Root, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, name, path, collection_id
+		FROM roots
+		ORDER BY name`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var images []*Image
+	var roots []*Root
 	for rows.Next() {
-		img := &Image{}
-
-		var desc sql.NullString
-		err := rows.Scan(
-			&img.Id,
-			&img.Path,
-			&img.PathMTime,
-			&desc,
-			&img.ProcessedAt,
-			&img.AttemptedAt,
-			&img.Model,
-			&img.Describer,
-			&img.Width,
-			&img.Height,
-		)
-		if err != nil {
+		root := &Root{}
+		var collectionId sql.NullInt64
+		if err := rows.Scan(&root.Id, &root.Name, &root.Path, &collectionId); err != nil {
 			return nil, err
 		}
-		if desc.Valid {
-			img.Description = desc.String
-		}
-
-		images = append(images, img)
+		root.CollectionId = int(collectionId.Int64)
+		roots = append(roots, root)
 	}
-	if err = rows.Err(); err != nil {
-		return nil, err
+	if rows.Err() != nil {
+		return nil, rows.Err()
 	}
 
-	return images, nil
+	return roots, nil
 }
 
-// CreateEmbedding inserts a new row into the embedding table and returns an
-// Embedding model
-func (db *DB) CreateEmbedding(ctx context.Context, vector []float32, model string, img *Image, at time.Time) (*Embedding, error) {
-	embed := &Embedding{
-		ImageId:     img.Id,
-		Vector:      vector,
-		Model:       model,
-		ProcessedAt: at,
-		Image:       img,
-	}
-	buf := &bytes.Buffer{}
-	buf.Grow(len(vector) * 4)
-	if err := binary.Write(buf, binary.BigEndian, vector); err != nil {
-		return nil, err
-	}
-
-	// Insert the embedding
+// RelocateRoot changes the path of the library root called name. The paths of
+// the images in it are relative, so they move with it.
+func (db *DB) RelocateRoot(ctx context.Context, name, path string) error {
 	res, err := db.db.ExecContext(ctx, `
-		INSERT INTO embeddings (image_id, vector, model, processed_at)
-		VALUES ($1,$2,$3,$4)`,
-		img.Id, buf.Bytes(), model, at,
-	)
+		UPDATE roots SET path=$1
+		WHERE name=$2`,
+		path, name)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	// Update the model's id
-	id, err := res.LastInsertId()
+	n, err := res.RowsAffected()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	embed.Id = int(id)
-
-	// Update the Image's association to this embedding
-	img.Embedding = embed
-	return embed, nil
+	if n == 0 {
+		return fmt.Errorf("no library root %q", name)
+	}
+	return nil
 }
 
-// GetEmbedding retrieves an Embedding model for the given embedding ID.
-// Currently this does not set up the Image association on the returned
-// Embedding.
+// SetRootCollection moves the library root rootId, and its images, into the
+// collection collectionId. A collectionId of 0 removes them from any
+// collection.
+func (db *DB) SetRootCollection(ctx context.Context, rootId, collectionId int) error {
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	if _, err := txn.ExecContext(ctx, `UPDATE roots SET collection_id=$1 WHERE id=$2`, nullId(collectionId), rootId); err != nil {
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, `UPDATE images SET collection_id=$1 WHERE root_id=$2`, nullId(collectionId), rootId); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// EnsureCollection returns the collection called name, creating it with no
+// settings if it does not exist.
+func (db *DB) EnsureCollection(ctx context.Context, name string) (*Collection, error) {
+	var id int
+	err := db.db.QueryRowContext(ctx, `
+		INSERT INTO collections (name)
+		VALUES ($1)
+		ON CONFLICT (name) DO UPDATE SET name=excluded.name
+		RETURNING id`,
+		name,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	collections, err := db.Collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range collections {
+		if c.Id == id {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("collection %q disappeared", name)
+}
+
+// UpdateCollection writes the settings of c.
+func (db *DB) UpdateCollection(ctx context.Context, c *Collection) error {
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE collections
+		SET describer=$1, prompt=$2, include=$3, exclude=$4
+		WHERE id=$5`,
+		c.Describer, c.Prompt, strings.Join(c.Include, "\n"), strings.Join(c.Exclude, "\n"), c.Id)
+	return err
+}
+
+// Collections returns all the collections ordered by name, with the number of
+// images in each.
+func (db *DB) Collections(ctx context.Context) ([]*Collection, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT c.id, c.name, c.describer, c.prompt, c.include, c.exclude,
+		       (SELECT COUNT(*) FROM images i WHERE i.collection_id=c.id)
+		FROM collections c
+		ORDER BY c.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []*Collection
+	for rows.Next() {
+		c := &Collection{}
+		var include, exclude string
+		if err := rows.Scan(&c.Id, &c.Name, &c.Describer, &c.Prompt, &include, &exclude, &c.Images); err != nil {
+			return nil, err
+		}
+		c.Include = splitPatterns(include)
+		c.Exclude = splitPatterns(exclude)
+		collections = append(collections, c)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return collections, nil
+}
+
+// splitPatterns splits the newline separated glob patterns stored in a
+// collection.
+func splitPatterns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// SetImageMetadata records when the image id was taken, zero if unknown, and
+// whether it is a favourite.
+func (db *DB) SetImageMetadata(ctx context.Context, id int, takenAt time.Time, favorite bool) error {
+	var taken sql.NullTime
+	if !takenAt.IsZero() {
+		taken.Time, taken.Valid = takenAt.UTC(), true
+	}
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE images SET taken_at=$1, favorite=$2
+		WHERE id=$3`,
+		taken, favorite, id)
+	return err
+}
+
+// SetImageLabels replaces the labels of kind on the image id with names,
+// creating labels as needed.
+func (db *DB) SetImageLabels(ctx context.Context, id int, kind string, names []string) error {
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	_, err = txn.ExecContext(ctx, `
+		DELETE FROM image_labels
+		WHERE image_id=$1 AND label_id IN (SELECT id FROM labels WHERE kind=$2)`,
+		id, kind)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		var labelId int
+		err := txn.QueryRowContext(ctx, `
+			INSERT INTO labels (kind, name)
+			VALUES ($1,$2)
+			ON CONFLICT (kind, name) DO UPDATE SET name=excluded.name
+			RETURNING id`,
+			kind, name,
+		).Scan(&labelId)
+		if err != nil {
+			return err
+		}
+		_, err = txn.ExecContext(ctx, `
+			INSERT OR IGNORE INTO image_labels (image_id, label_id)
+			VALUES ($1,$2)`,
+			id, labelId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// SetImageCaption records the caption found in the image file id.
+func (db *DB) SetImageCaption(ctx context.Context, id int, caption string) error {
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE images SET caption=$1
+		WHERE id=$2`,
+		caption, id)
+	return err
+}
+
+// ImageLabels returns the labels on the image id, ordered by kind and name.
+// Images is not set.
+func (db *DB) ImageLabels(ctx context.Context, id int) ([]*Label, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT l.id, l.kind, l.name
+		FROM labels l
+		INNER JOIN image_labels il ON il.label_id=l.id
+		WHERE il.image_id=$1
+		ORDER BY l.kind, l.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var labels []*Label
+	for rows.Next() {
+		l := &Label{}
+		if err := rows.Scan(&l.Id, &l.Kind, &l.Name); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return labels, nil
+}
+
+// Labels returns the labels of kind that are on at least one image, ordered by
+// name, with the number of images they are on.
+func (db *DB) Labels(ctx context.Context, kind string) ([]*Label, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT l.id, l.kind, l.name, COUNT(*)
+		FROM labels l
+		INNER JOIN image_labels il ON il.label_id=l.id
+		WHERE l.kind=$1
+		GROUP BY l.id
+		ORDER BY l.name`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var labels []*Label
+	for rows.Next() {
+		l := &Label{}
+		if err := rows.Scan(&l.Id, &l.Kind, &l.Name, &l.Images); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return labels, nil
+}
+
+// ImageIdsByPath returns the ids of the images in the library root rootId,
+// keyed by their path relative to the root.
+func (db *DB) ImageIdsByPath(ctx context.Context, rootId int) (map[string]int, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, image_path
+		FROM images
+		WHERE root_id=$1`, rootId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int)
+	for rows.Next() {
+		var (
+			id   int
+			path string
+		)
+		if err := rows.Scan(&id, &path); err != nil {
+			return nil, err
+		}
+		ids[path] = id
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return ids, nil
+}
+
+// ImagePaths returns the absolute paths of all the images in the DB.
+func (db *DB) ImagePaths(ctx context.Context) ([]string, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT `+imagePathSQL+`
+		FROM images i
+		`+rootsJoin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return paths, nil
+}
+
+// ImagesToDescribe returns Image models for all the images in the DB that lack
+// a description, and that no one has written one for.
+func (db *DB) ImagesToDescribe(ctx context.Context) ([]*Image, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT i.id, `+imagePathSQL+`, i.image_mtime, i.image_description,
+		       COALESCE(i.collection_id, 0)
+		FROM images i
+		`+rootsJoin+`
+		WHERE i.processed_at IS NULL AND i.attempted_at IS NULL
+		      AND i.edited_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*Image
+	for rows.Next() {
+		img := &Image{}
+
+		var desc sql.NullString
+		err = rows.Scan(&img.Id, &img.Path, &img.PathMTime, &desc, &img.CollectionId)
+		if err != nil {
+			return nil, err
+		}
+		if desc.Valid {
+			img.Description = desc.String
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// UpdateImage records the description in img by model with the default
+// prompt, see AddDescription.
+func (db *DB) UpdateImage(ctx context.Context, img *Image, model, describer string) error {
+	return db.AddDescription(ctx, img, DescriptionSet{Model: model}, describer)
+}
+
+// AddDescription records the description in img, and its processed_at and
+// describe time, as the image's description in set. It replaces an earlier
+// description in set, whose embeddings are deleted. The description becomes
+// the image's current description, and is added to its history, if the image
+// has no other description. img.DescriptionId is set to the description.
+func (db *DB) AddDescription(ctx context.Context, img *Image, set DescriptionSet, describer string) error {
+	var describeMs sql.NullInt64
+	if img.DescribeTime > 0 {
+		describeMs.Int64, describeMs.Valid = img.DescribeTime.Milliseconds(), true
+	}
+	at := img.ProcessedAt.Time
+	if !img.ProcessedAt.Valid {
+		at = time.Now()
+	}
+
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	var (
+		id      int
+		old     string
+		changed = true
+	)
+	err = txn.QueryRowContext(ctx, `
+		SELECT id, description
+		FROM descriptions
+		WHERE image_id=$1 AND model=$2 AND prompt_version=$3`,
+		img.Id, set.Model, set.PromptVersion).Scan(&id, &old)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = txn.QueryRowContext(ctx, `
+			INSERT INTO descriptions (image_id, model, prompt_version, describer, description, processed_at, describe_ms)
+			VALUES ($1,$2,$3,$4,$5,$6,$7)
+			RETURNING id`,
+			img.Id, set.Model, set.PromptVersion, describer, img.Description, at, describeMs).Scan(&id)
+	case err == nil:
+		changed = old != img.Description
+		_, err = txn.ExecContext(ctx, `
+			UPDATE descriptions SET describer=$1,description=$2,processed_at=$3,describe_ms=$4
+			WHERE id=$5`,
+			describer, img.Description, at, describeMs, id)
+		if err == nil && changed {
+			_, err = txn.ExecContext(ctx, `DELETE FROM embeddings WHERE description_id=$1`, id)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.ExecContext(ctx, `
+		UPDATE images SET image_description=$1,model=$2,describer=$3,
+				  processed_at=$4,describe_ms=$5,description_id=$6
+		WHERE id=$7 AND edited_at IS NULL
+		      AND (description_id IS NULL OR description_id=$6)`,
+		img.Description,
+		set.Model,
+		describer,
+		at,
+		describeMs,
+		id,
+		img.Id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 && changed {
+		author := strings.TrimSpace(describer + " " + set.Model)
+		if err := addDescriptionVersion(ctx, txn, img.Id, img.Description, author, false, at); err != nil {
+			return err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+
+	img.DescriptionId = id
+	return nil
+}
+
+// EditImageDescription replaces the current description of the image id with
+// one written by author, recorded as the description by EditedModel. The
+// image won't be described again, and its new description has no embeddings.
+func (db *DB) EditImageDescription(ctx context.Context, id int, description, author string, at time.Time) error {
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	var descId int
+	err = txn.QueryRowContext(ctx, `
+		INSERT INTO descriptions (image_id, model, description, processed_at)
+		SELECT id, $1, $2, $3 FROM images WHERE id=$4
+		ON CONFLICT (image_id, model, prompt_version) DO UPDATE
+		SET description=excluded.description, processed_at=excluded.processed_at
+		RETURNING id`,
+		EditedModel, description, at, id).Scan(&descId)
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.ExecContext(ctx, `
+		UPDATE images SET image_description=$1,edited_at=$2,description_id=$3
+		WHERE id=$4`,
+		description,
+		at,
+		descId,
+		id)
+	if err != nil {
+		return err
+	}
+
+	if err := addDescriptionVersion(ctx, txn, id, description, author, true, at); err != nil {
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, `DELETE FROM embeddings WHERE description_id=$1`, descId); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// addDescriptionVersion adds a version to the description history of the
+// image id.
+func addDescriptionVersion(ctx context.Context, txn *sql.Tx, id int, description, author string, human bool, at time.Time) error {
+	_, err := txn.ExecContext(ctx, `
+		INSERT INTO description_history (image_id, description, author, human, created_at)
+		VALUES ($1,$2,$3,$4,$5)`,
+		id, description, author, human, at)
+	return err
+}
+
+// DescriptionHistory returns the versions of the description of the image id,
+// newest first.
+func (db *DB) DescriptionHistory(ctx context.Context, id int) ([]*DescriptionVersion, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, description, author, human, created_at
+		FROM description_history
+		WHERE image_id=$1
+		ORDER BY created_at DESC, id DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*DescriptionVersion
+	for rows.Next() {
+		v := &DescriptionVersion{ImageId: id}
+		if err := rows.Scan(&v.Id, &v.Description, &v.Author, &v.Human, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return versions, nil
+}
+
+// UpdateImageAttempted updates the attempted_at timestamp for an images row
+// that has not been described.
+func (db *DB) UpdateImageAttempted(ctx context.Context, id int, model, describer string, at time.Time) error {
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE images SET attempted_at=$1,model=$2,describer=$3
+		WHERE id=$4 AND processed_at IS NULL`,
+		at,
+		model,
+		describer,
+		id)
+	return err
+}
+
+// GetImage retrieves an Image model by id. Images that have not been
+// described have an empty Description, Describer and Model.
+func (db *DB) GetImage(ctx context.Context, id int) (*Image, error) {
+	row := db.db.QueryRowContext(ctx, `
+		SELECT `+imagePathSQL+`, `+imageRelPathSQL+`,
+		       i.image_mtime, i.image_description, i.processed_at,
+		       i.attempted_at, i.describer, i.model, i.image_width,
+		       i.image_height, i.describe_ms, i.content_hash,
+		       COALESCE(i.collection_id, 0), i.taken_at, i.favorite,
+		       i.caption, i.edited_at, COALESCE(i.description_id, 0)
+		FROM images i
+		`+rootsJoin+`
+		WHERE i.id=$1`, id)
+
+	if row.Err() != nil {
+		return nil, row.Err()
+	}
+
+	img := &Image{
+		Id: id,
+	}
+	var (
+		desc, describer, model, hash sql.NullString
+		describeMs                   sql.NullInt64
+		takenAt, editedAt            sql.NullTime
+	)
+	err := row.Scan(
+		&img.Path,
+		&img.RelPath,
+		&img.PathMTime,
+		&desc,
+		&img.ProcessedAt,
+		&img.AttemptedAt,
+		&describer,
+		&model,
+		&img.Width,
+		&img.Height,
+		&describeMs,
+		&hash,
+		&img.CollectionId,
+		&takenAt,
+		&img.Favorite,
+		&img.Caption,
+		&editedAt,
+		&img.DescriptionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	img.TakenAt = takenAt.Time
+	img.EditedAt = editedAt.Time
+	img.Description = desc.String
+	img.Describer = describer.String
+	img.Model = model.String
+	img.DescribeTime = time.Duration(describeMs.Int64) * time.Millisecond
+	img.Hash = hash.String
+
+	return img, nil
+}
+
+// SetImageHash records the content hash of an image.
+func (db *DB) SetImageHash(ctx context.Context, id int, hash string) error {
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE images SET content_hash=$1
+		WHERE id=$2`,
+		hash,
+		id)
+	return err
+}
+
+// ImagesWithoutHash returns the ids and paths of images lacking a content
+// hash. Only the Id and Path fields are set.
+func (db *DB) ImagesWithoutHash(ctx context.Context) ([]*Image, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT i.id, `+imagePathSQL+`
+		FROM images i
+		`+rootsJoin+`
+		WHERE i.content_hash IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*Image
+	for rows.Next() {
+		img := &Image{}
+		if err := rows.Scan(&img.Id, &img.Path); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return images, nil
+}
+
+// ImageIdsByHash returns the ids of all images with a content hash, grouped by
+// hash. The same photo may be in the library more than once.
+func (db *DB) ImageIdsByHash(ctx context.Context) (map[string][]int, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, content_hash
+		FROM images
+		WHERE content_hash IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string][]int)
+	for rows.Next() {
+		var (
+			id   int
+			hash string
+		)
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		ids[hash] = append(ids[hash], id)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return ids, nil
+}
+
+// DescribedImagesMissingEmbeddings finds all described images that do not have
+// a description embedding generated by the specified model. It returns the
+// images as Image models, with their Embedding associations blank.
+func (db *DB) DescribedImagesMissingEmbeddings(ctx context.Context, model string) ([]*Image, error) {
+	query := `
+		SELECT i.id, ` + imagePathSQL + `, ` + imageRelPathSQL + `,
+		       i.image_mtime, i.image_description, i.processed_at,
+		       i.attempted_at, COALESCE(i.model, ''), COALESCE(i.describer, ''),
+		       i.image_width, i.image_height, i.describe_ms, i.content_hash,
+		       COALESCE(i.collection_id, 0), i.caption,
+		       COALESCE(i.description_id, 0)
+		FROM images i
+		` + rootsJoin + `
+		LEFT JOIN embeddings e ON i.description_id=e.description_id AND e.model=$1
+		WHERE i.image_description IS NOT NULL AND e.id IS NULL`
+
+	return db.queryImages(ctx, query, model)
+}
+
+// DescribedImages returns all the images that have a description, in the
+// order they were added.
+func (db *DB) DescribedImages(ctx context.Context) ([]*Image, error) {
+	query := `
+		SELECT i.id, ` + imagePathSQL + `, ` + imageRelPathSQL + `,
+		       i.image_mtime, i.image_description, i.processed_at,
+		       i.attempted_at, COALESCE(i.model, ''), COALESCE(i.describer, ''),
+		       i.image_width, i.image_height, i.describe_ms, i.content_hash,
+		       COALESCE(i.collection_id, 0), i.caption,
+		       COALESCE(i.description_id, 0)
+		FROM images i
+		` + rootsJoin + `
+		WHERE i.image_description IS NOT NULL
+		ORDER BY i.id`
+
+	return db.queryImages(ctx, query)
+}
+
+// Images returns all the images, described or not, in the order they were
+// added.
+func (db *DB) Images(ctx context.Context) ([]*Image, error) {
+	query := `
+		SELECT i.id, ` + imagePathSQL + `, ` + imageRelPathSQL + `,
+		       i.image_mtime, i.image_description, i.processed_at,
+		       i.attempted_at, COALESCE(i.model, ''), COALESCE(i.describer, ''),
+		       i.image_width, i.image_height, i.describe_ms, i.content_hash,
+		       COALESCE(i.collection_id, 0), i.caption,
+		       COALESCE(i.description_id, 0)
+		FROM images i
+		` + rootsJoin + `
+		ORDER BY i.id`
+
+	return db.queryImages(ctx, query)
+}
+
+// DescriptionsMissingEmbeddings finds the descriptions in set that do not
+// have an embedding generated by model. It returns them as Image models whose
+// description fields are those of the description in set, with their
+// Embedding associations blank.
+func (db *DB) DescriptionsMissingEmbeddings(ctx context.Context, model string, set DescriptionSet) ([]*Image, error) {
+	query := `
+		SELECT i.id, ` + imagePathSQL + `, ` + imageRelPathSQL + `,
+		       i.image_mtime, d.description, d.processed_at,
+		       i.attempted_at, d.model, d.describer, i.image_width,
+		       i.image_height, d.describe_ms, i.content_hash,
+		       COALESCE(i.collection_id, 0), i.caption, d.id
+		FROM descriptions d
+		INNER JOIN images i ON d.image_id=i.id
+		` + rootsJoin + `
+		LEFT JOIN embeddings e ON d.id=e.description_id AND e.model=$1
+		WHERE d.model=$2 AND d.prompt_version=$3 AND e.id IS NULL
+		ORDER BY i.id`
+
+	return db.queryImages(ctx, query, model, set.Model, set.PromptVersion)
+}
+
+// ImageDescriptionSets returns the description sets each image has a
+// description in, by image id.
+func (db *DB) ImageDescriptionSets(ctx context.Context) (map[int][]DescriptionSet, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT image_id, model, prompt_version
+		FROM descriptions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sets := make(map[int][]DescriptionSet)
+	for rows.Next() {
+		var (
+			id  int
+			set DescriptionSet
+		)
+		if err := rows.Scan(&id, &set.Model, &set.PromptVersion); err != nil {
+			return nil, err
+		}
+		sets[id] = append(sets[id], set)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return sets, nil
+}
+
+// DescriptionSets returns the description sets with the number of images
+// described in each, ordered by model and prompt version.
+func (db *DB) DescriptionSets(ctx context.Context) ([]*DescriptionSet, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT model, prompt_version, COUNT(*)
+		FROM descriptions
+		GROUP BY model, prompt_version
+		ORDER BY model, prompt_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sets []*DescriptionSet
+	for rows.Next() {
+		set := &DescriptionSet{}
+		if err := rows.Scan(&set.Model, &set.PromptVersion, &set.Images); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return sets, nil
+}
+
+// queryImages runs query, which must select the columns scanned below, and
+// returns the images.
+func (db *DB) queryImages(ctx context.Context, query string, args ...any) ([]*Image, error) {
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*Image
+	for rows.Next() {
+		img := &Image{}
+
+		var (
+			desc, hash sql.NullString
+			describeMs sql.NullInt64
+		)
+		err := rows.Scan(
+			&img.Id,
+			&img.Path,
+			&img.RelPath,
+			&img.PathMTime,
+			&desc,
+			&img.ProcessedAt,
+			&img.AttemptedAt,
+			&img.Model,
+			&img.Describer,
+			&img.Width,
+			&img.Height,
+			&describeMs,
+			&hash,
+			&img.CollectionId,
+			&img.Caption,
+			&img.DescriptionId,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if desc.Valid {
+			img.Description = desc.String
+		}
+		img.DescribeTime = time.Duration(describeMs.Int64) * time.Millisecond
+		img.Hash = hash.String
+
+		images = append(images, img)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// CreateEmbedding inserts a new row into the embedding table and returns an
+// Embedding model. The embedding is of the description img.DescriptionId, or
+// the image's current description if it is 0. An existing embedding of the
+// description by the same model is replaced.
+func (db *DB) CreateEmbedding(ctx context.Context, vector []float32, model string, img *Image, at time.Time) (*Embedding, error) {
+	embed := &Embedding{
+		ImageId:     img.Id,
+		Vector:      vector,
+		Model:       model,
+		ProcessedAt: at,
+		Image:       img,
+	}
+	buf := &bytes.Buffer{}
+	buf.Grow(len(vector) * 4)
+	if err := binary.Write(buf, binary.BigEndian, vector); err != nil {
+		return nil, err
+	}
+
+	// Insert the embedding and update the model's id
+	err := db.db.QueryRowContext(ctx, `
+		INSERT INTO embeddings (image_id, vector, model, processed_at, description_id)
+		VALUES ($1,$2,$3,$4,COALESCE($5,(SELECT description_id FROM images WHERE id=$1)))
+		ON CONFLICT (description_id, model) DO UPDATE
+		SET vector=excluded.vector, processed_at=excluded.processed_at
+		RETURNING id`,
+		img.Id, buf.Bytes(), model, at, nullId(img.DescriptionId),
+	).Scan(&embed.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update the Image's association to this embedding
+	img.Embedding = embed
+	return embed, nil
+}
+
+// DeleteImageEmbeddings deletes the embeddings of the image id, so they are
+// computed again.
+func (db *DB) DeleteImageEmbeddings(ctx context.Context, id int) error {
+	_, err := db.db.ExecContext(ctx, `
+		DELETE FROM embeddings
+		WHERE image_id=$1`,
+		id)
+	return err
+}
+
+// ImageEmbeddings returns all the embeddings of an image's current
+// description, one per model. The Image association is not set.
+func (db *DB) ImageEmbeddings(ctx context.Context, imageID int) ([]*Embedding, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT e.id, e.vector, COALESCE(e.model,''), e.processed_at
+		FROM embeddings e
+		INNER JOIN images i ON e.image_id=i.id AND e.description_id=i.description_id
+		WHERE e.image_id=$1
+		ORDER BY e.model`, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var embeds []*Embedding
+	for rows.Next() {
+		emb := &Embedding{ImageId: imageID}
+
+		var blobData []byte
+		if err := rows.Scan(&emb.Id, &blobData, &emb.Model, &emb.ProcessedAt); err != nil {
+			return nil, err
+		}
+		emb.Vector = make([]float32, len(blobData)/4)
+		err = binary.Read(bytes.NewReader(blobData), binary.BigEndian, &emb.Vector)
+		if err != nil {
+			return nil, fmt.Errorf("reading vector data - %w", err)
+		}
+		embeds = append(embeds, emb)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return embeds, nil
+}
+
+// GetEmbedding retrieves an Embedding model for the given embedding ID.
+// Currently this does not set up the Image association on the returned
+// Embedding.
 func (db *DB) GetEmbedding(ctx context.Context, id int) (*Embedding, error) {
 	row := db.db.QueryRowContext(ctx, `
 		SELECT id, image_id, vector, model, processed_at
@@ -453,8 +1728,9 @@ type EmbeddingBatch struct {
 // EmbeddingsForModel returns Embedding for model. It is a batching API so it
 // returns a channel that will receive batches of Embeddings. The last batch
 // will set Done to true and the channel will be closed. Cancel the supplied
-// context to terminate the batching process.
-func (db *DB) EmbeddingsForModel(ctx context.Context, model string, batchSize int) (<-chan EmbeddingBatch, <-chan error) {
+// context to terminate the batching process. Only the embeddings of images
+// matched by filter are returned.
+func (db *DB) EmbeddingsForModel(ctx context.Context, model string, batchSize int, filter ImageFilter) (<-chan EmbeddingBatch, <-chan error) {
 	if batchSize == 0 {
 		batchSize = 1000
 	}
@@ -473,7 +1749,7 @@ func (db *DB) EmbeddingsForModel(ctx context.Context, model string, batchSize in
 				return
 			}
 
-			batch, err := db.loadEmbeddingsForBatch(ctx, model, batchSize, lastID)
+			batch, err := db.loadEmbeddingsForBatch(ctx, model, batchSize, lastID, filter)
 			if err != nil {
 				errChan <- fmt.Errorf("loading embedding batch - %w", err)
 				return
@@ -497,16 +1773,19 @@ func (db *DB) EmbeddingsForModel(ctx context.Context, model string, batchSize in
 	return batchChan, errChan
 }
 
-func (db *DB) loadEmbeddingsForBatch(ctx context.Context, model string, batchSize, lastID int) (EmbeddingBatch, error) {
+func (db *DB) loadEmbeddingsForBatch(ctx context.Context, model string, batchSize, lastID int, filter ImageFilter) (EmbeddingBatch, error) {
+	where, args := filter.where(model, lastID, batchSize)
 	rows, err := db.db.QueryContext(ctx, `
 		SELECT e.id, e.image_id, e.vector, e.processed_at,
-		       i.id, i.image_path, i.image_mtime, i.image_description, i.processed_at, i.attempted_at,
-		       i.describer, i.model, i.image_width, i.image_height
+		       i.id, `+imagePathSQL+`, i.image_mtime, d.description, d.processed_at, i.attempted_at,
+		       d.describer, d.model, i.image_width, i.image_height, d.id
 		FROM embeddings e
 		INNER JOIN images i ON e.image_id=i.id
-		WHERE e.model=$1 AND e.id > $2
+		INNER JOIN descriptions d ON e.description_id=d.id
+		`+rootsJoin+`
+		WHERE e.model=$1 AND e.id > $2`+where+`
 		ORDER BY e.id
-		LIMIT $3`, model, lastID, batchSize)
+		LIMIT $3`, args...)
 	if err != nil {
 		return EmbeddingBatch{}, fmt.Errorf("querying embeddings - %w", err)
 	}
@@ -533,6 +1812,7 @@ func (db *DB) loadEmbeddingsForBatch(ctx context.Context, model string, batchSiz
 			&img.Model,
 			&img.Width,
 			&img.Height,
+			&img.DescriptionId,
 		)
 		if err != nil {
 			return EmbeddingBatch{}, fmt.Errorf("scanning rows - %w", err)
@@ -555,12 +1835,16 @@ func (db *DB) loadEmbeddingsForBatch(ctx context.Context, model string, batchSiz
 	return batch, nil
 }
 
-// EmbeddingIdsForModel returns the the ids of all embeddings that match a model
-func (db *DB) EmbeddingIdsForModel(ctx context.Context, model string) ([]int, error) {
+// EmbeddingIdsForModel returns the the ids of all embeddings that match a
+// model, for the images matched by filter.
+func (db *DB) EmbeddingIdsForModel(ctx context.Context, model string, filter ImageFilter) ([]int, error) {
+	where, args := filter.where(model)
 	rows, err := db.db.QueryContext(ctx, `
-		SELECT id
-		FROM embeddings
-		WHERE model=$1`, model)
+		SELECT e.id
+		FROM embeddings e
+		INNER JOIN images i ON e.image_id=i.id
+		INNER JOIN descriptions d ON e.description_id=d.id
+		WHERE e.model=$1`+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -581,6 +1865,66 @@ func (db *DB) EmbeddingIdsForModel(ctx context.Context, model string) ([]int, er
 	return eids, nil
 }
 
+// where returns the conditions limiting images i to those matched by f, and
+// their descriptions d to those in f's description set, and args with the
+// condition's values appended. The condition's placeholders follow those for
+// args.
+func (f ImageFilter) where(args ...any) (string, []any) {
+	var sb strings.Builder
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Descriptions.Model == "" {
+		sb.WriteString(" AND d.id=i.description_id")
+	} else {
+		sb.WriteString(" AND d.model=" + arg(f.Descriptions.Model) + " AND d.prompt_version=" + arg(f.Descriptions.PromptVersion))
+	}
+
+	if len(f.Collections) > 0 {
+		placeholders := make([]string, len(f.Collections))
+		for i, id := range f.Collections {
+			placeholders[i] = arg(id)
+		}
+		sb.WriteString(" AND i.collection_id IN (" + strings.Join(placeholders, ",") + ")")
+	}
+	for _, id := range f.Labels {
+		sb.WriteString(" AND EXISTS (SELECT 1 FROM image_labels il WHERE il.image_id=i.id AND il.label_id=" + arg(id) + ")")
+	}
+	if f.Favorites {
+		sb.WriteString(" AND i.favorite=1")
+	}
+	if !f.TakenAfter.IsZero() {
+		sb.WriteString(" AND i.taken_at>=" + arg(f.TakenAfter.UTC()))
+	}
+	if !f.TakenBefore.IsZero() {
+		sb.WriteString(" AND i.taken_at<" + arg(f.TakenBefore.UTC()))
+	}
+	for _, kw := range f.Keywords {
+		sb.WriteString(" AND contains_words(d.description," + arg(kw) + ")")
+	}
+
+	return sb.String(), args
+}
+
+// containsWords returns whether the words of phrase appear in order in text,
+// ignoring case and punctuation. A phrase without words is in every text.
+func containsWords(text, phrase string) bool {
+	fields := func(s string) []string {
+		return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+	}
+	words, want := fields(text), fields(phrase)
+	for i := 0; i+len(want) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetEmbeddingsWithImages looks up embeddings by id and returns both the embed
 // (without vector data) and the associated Image.
 func (db *DB) GetEmbeddingsWithImages(ctx context.Context, ids ...int) (map[int]*Embedding, error) {
@@ -593,11 +1937,13 @@ func (db *DB) GetEmbeddingsWithImages(ctx context.Context, ids ...int) (map[int]
 
 	query := fmt.Sprintf(`
 		SELECT e.id,e.image_id,e.model,e.processed_at,
-		       i.id,i.image_path,i.image_mtime,i.image_description,
-		       i.processed_at,i.attempted_at,i.model,i.describer,
-		       i.image_width,i.image_height
-		FROM embeds e
+		       i.id,`+imagePathSQL+`,i.image_mtime,d.description,
+		       d.processed_at,i.attempted_at,d.model,d.describer,
+		       i.image_width,i.image_height,d.id
+		FROM embeddings e
 		INNER JOIN images i ON e.image_id=i.id
+		INNER JOIN descriptions d ON e.description_id=d.id
+		`+rootsJoin+`
 		WHERE e.id IN (%s)`,
 		strings.Join(placeholders, ","))
 
@@ -627,6 +1973,7 @@ func (db *DB) GetEmbeddingsWithImages(ctx context.Context, ids ...int) (map[int]
 			&img.Describer,
 			&img.Width,
 			&img.Height,
+			&img.DescriptionId,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning embeddings and images: %w", err)
@@ -642,3 +1989,518 @@ func (db *DB) GetEmbeddingsWithImages(ctx context.Context, ids ...int) (map[int]
 
 	return embeddings, nil
 }
+
+// Stats summarizes how far the library has got through the pipeline.
+type Stats struct {
+	Images    int // total number of images
+	Described int // images with a description
+	Failed    int // images whose description failed
+	Pending   int // images waiting to be described
+
+	Embeddings        map[string]int // number of embeddings by model
+	MissingEmbeddings map[string]int // described images lacking an embedding by model
+
+	AvgDescribeTime time.Duration // zero if no describe times are recorded
+	DBSize          int64         // in bytes
+}
+
+// Stats computes the pipeline coverage of the DB using aggregate queries.
+func (db *DB) Stats(ctx context.Context) (*Stats, error) {
+	stats := &Stats{
+		Embeddings:        map[string]int{},
+		MissingEmbeddings: map[string]int{},
+	}
+
+	var avgMs sql.NullFloat64
+	err := db.db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       COUNT(processed_at),
+		       COALESCE(SUM(processed_at IS NULL AND attempted_at IS NOT NULL),0),
+		       COALESCE(SUM(processed_at IS NULL AND attempted_at IS NULL),0),
+		       AVG(describe_ms)
+		FROM images`).Scan(
+		&stats.Images,
+		&stats.Described,
+		&stats.Failed,
+		&stats.Pending,
+		&avgMs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if avgMs.Valid {
+		stats.AvgDescribeTime = time.Duration(avgMs.Float64 * float64(time.Millisecond))
+	}
+
+	// Only embeddings of the current descriptions count towards coverage
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT COALESCE(e.model,''), COUNT(*), COUNT(i.image_description)
+		FROM embeddings e
+		LEFT JOIN images i ON e.image_id=i.id AND e.description_id=i.description_id
+		GROUP BY e.model`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			model          string
+			total, covered int
+		)
+		if err := rows.Scan(&model, &total, &covered); err != nil {
+			return nil, err
+		}
+		stats.Embeddings[model] = total
+		stats.MissingEmbeddings[model] = stats.Described - covered
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var pageCount, pageSize int64
+	if err := db.db.QueryRowContext(ctx, `PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return nil, err
+	}
+	if err := db.db.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return nil, err
+	}
+	stats.DBSize = pageCount * pageSize
+
+	return stats, nil
+}
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobPaused    = "paused"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+	JobDone      = "done"
+)
+
+// Job is an in-memory representation of a row in the jobs table. Jobs are
+// long running pieces of work, like describing images, run by the server.
+type Job struct {
+	Id         int
+	Kind       string // e.g. scan, describe
+	Arg        string // kind specific, e.g. the library path to scan
+	State      string // one of the Job* states
+	Total      int    // number of items to process, if known
+	Done       int    // number of items processed
+	Errors     int    // number of items that failed
+	Message    string // result or error message once finished
+	CreatedAt  time.Time
+	StartedAt  sql.NullTime
+	FinishedAt sql.NullTime
+}
+
+const jobColumns = `id, kind, arg, state, total, done, errors, message,
+		       created_at, started_at, finished_at`
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	job := &Job{}
+	err := row.Scan(
+		&job.Id,
+		&job.Kind,
+		&job.Arg,
+		&job.State,
+		&job.Total,
+		&job.Done,
+		&job.Errors,
+		&job.Message,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CreateJob inserts a new queued job and returns its model.
+func (db *DB) CreateJob(ctx context.Context, kind, arg string, at time.Time) (*Job, error) {
+	job := &Job{Kind: kind, Arg: arg, State: JobQueued, CreatedAt: at}
+	err := db.db.QueryRowContext(ctx, `
+		INSERT INTO jobs (kind, arg, state, created_at)
+		VALUES ($1,$2,$3,$4)
+		RETURNING id`,
+		kind, arg, job.State, at,
+	).Scan(&job.Id)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// UpdateJob writes the state, progress and timestamps of job to its row.
+func (db *DB) UpdateJob(ctx context.Context, job *Job) error {
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE jobs SET state=$1,total=$2,done=$3,errors=$4,message=$5,
+				started_at=$6,finished_at=$7
+		WHERE id=$8`,
+		job.State,
+		job.Total,
+		job.Done,
+		job.Errors,
+		job.Message,
+		job.StartedAt,
+		job.FinishedAt,
+		job.Id)
+	return err
+}
+
+// GetJob retrieves a Job model by id.
+func (db *DB) GetJob(ctx context.Context, id int) (*Job, error) {
+	row := db.db.QueryRowContext(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE id=$1`, id)
+	return scanJob(row)
+}
+
+// Jobs returns the most recent jobs, newest first.
+func (db *DB) Jobs(ctx context.Context, limit int) ([]*Job, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		ORDER BY id DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return jobs, nil
+}
+
+// NextQueuedJob returns the oldest queued job, or nil if there are none.
+func (db *DB) NextQueuedJob(ctx context.Context) (*Job, error) {
+	row := db.db.QueryRowContext(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE state=$1
+		ORDER BY id
+		LIMIT 1`, JobQueued)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// RequeueJobs puts jobs that were running or paused when the server stopped
+// back in the queue. It returns the number of jobs requeued.
+func (db *DB) RequeueJobs(ctx context.Context) (int, error) {
+	res, err := db.db.ExecContext(ctx, `
+		UPDATE jobs SET state=$1
+		WHERE state IN ($2,$3)`,
+		JobQueued, JobRunning, JobPaused)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// SetFeedback records whether the image id is relevant to query searched with
+// model, replacing an earlier judgement.
+func (db *DB) SetFeedback(ctx context.Context, query string, id int, model string, relevant bool, at time.Time) error {
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO feedback (query, image_id, model, relevant, created_at)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (query, image_id, model) DO UPDATE
+		SET relevant=excluded.relevant, created_at=excluded.created_at`,
+		query, id, model, relevant, at)
+	return err
+}
+
+// DeleteFeedback removes the judgement of the image id for query searched with
+// model.
+func (db *DB) DeleteFeedback(ctx context.Context, query string, id int, model string) error {
+	_, err := db.db.ExecContext(ctx, `
+		DELETE FROM feedback
+		WHERE query=$1 AND image_id=$2 AND model=$3`,
+		query, id, model)
+	return err
+}
+
+// QueryFeedback returns the judgements of the results of query searched with
+// model.
+func (db *DB) QueryFeedback(ctx context.Context, query, model string) ([]*Feedback, error) {
+	return db.queryFeedback(ctx, `WHERE query=$1 AND model=$2 ORDER BY id`, query, model)
+}
+
+// AllFeedback returns every judgement, oldest first.
+func (db *DB) AllFeedback(ctx context.Context) ([]*Feedback, error) {
+	return db.queryFeedback(ctx, `ORDER BY created_at, id`)
+}
+
+func (db *DB) queryFeedback(ctx context.Context, where string, args ...any) ([]*Feedback, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, query, image_id, model, relevant, created_at
+		FROM feedback
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feedback []*Feedback
+	for rows.Next() {
+		fb := &Feedback{}
+		if err := rows.Scan(&fb.Id, &fb.Query, &fb.ImageId, &fb.Model, &fb.Relevant, &fb.CreatedAt); err != nil {
+			return nil, err
+		}
+		feedback = append(feedback, fb)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return feedback, nil
+}
+
+// descriptionHash identifies the text of a description in the relevance
+// table.
+func descriptionHash(description string) string {
+	sum := sha256.Sum256([]byte(description))
+	return hex.EncodeToString(sum[:16])
+}
+
+// CachedRelevance returns the relevance scores by model of descriptions to
+// query, by description. Descriptions that have not been scored are missing.
+func (db *DB) CachedRelevance(ctx context.Context, query, model string, descriptions []string) (map[string]float32, error) {
+	byHash := map[string]string{}
+	for _, desc := range descriptions {
+		byHash[descriptionHash(desc)] = desc
+	}
+
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT description_hash, score
+		FROM relevance
+		WHERE query=$1 AND model=$2`, query, model)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := map[string]float32{}
+	for rows.Next() {
+		var (
+			hash  string
+			score float32
+		)
+		if err := rows.Scan(&hash, &score); err != nil {
+			return nil, err
+		}
+		if desc, ok := byHash[hash]; ok {
+			scores[desc] = score
+		}
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return scores, nil
+}
+
+// CacheRelevance records the relevance score by model of description to
+// query.
+func (db *DB) CacheRelevance(ctx context.Context, query, model, description string, score float32, at time.Time) error {
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO relevance (query, description_hash, model, score, created_at)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (query, description_hash, model) DO UPDATE
+		SET score=excluded.score, created_at=excluded.created_at`,
+		query, descriptionHash(description), model, score, at)
+	return err
+}
+
+// SaveSearch creates the saved search ss, or replaces the one with its name,
+// setting ss.Id. The search is left to be evaluated.
+func (db *DB) SaveSearch(ctx context.Context, ss *SavedSearch) error {
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	err = txn.QueryRowContext(ctx, `
+		INSERT INTO saved_searches (name, query, filter, threshold, created_at)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (name) DO UPDATE
+		SET query=excluded.query, filter=excluded.filter, threshold=excluded.threshold,
+		    model='', evaluated_at=NULL
+		RETURNING id`,
+		ss.Name, ss.Query, ss.Filter, ss.Threshold, ss.CreatedAt,
+	).Scan(&ss.Id)
+	if err != nil {
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, `DELETE FROM saved_search_images WHERE search_id=$1`, ss.Id); err != nil {
+		return err
+	}
+	ss.Model, ss.Evaluated, ss.Images = "", sql.NullTime{}, 0
+
+	return txn.Commit()
+}
+
+// DeleteSavedSearch removes the saved search named name. It returns
+// sql.ErrNoRows if there is none.
+func (db *DB) DeleteSavedSearch(ctx context.Context, name string) error {
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	var id int
+	if err := txn.QueryRowContext(ctx, `SELECT id FROM saved_searches WHERE name=$1`, name).Scan(&id); err != nil {
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, `DELETE FROM saved_search_images WHERE search_id=$1`, id); err != nil {
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, `DELETE FROM saved_searches WHERE id=$1`, id); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// SavedSearches returns the saved searches, ordered by name, with the number
+// of images matching each.
+func (db *DB) SavedSearches(ctx context.Context) ([]*SavedSearch, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT s.id, s.name, s.query, s.filter, s.threshold, s.model,
+		       s.evaluated_at, s.created_at, COUNT(si.image_id)
+		FROM saved_searches s
+		LEFT JOIN saved_search_images si ON si.search_id=s.id
+		GROUP BY s.id
+		ORDER BY s.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []*SavedSearch
+	for rows.Next() {
+		ss := &SavedSearch{}
+		err := rows.Scan(&ss.Id, &ss.Name, &ss.Query, &ss.Filter, &ss.Threshold, &ss.Model,
+			&ss.Evaluated, &ss.CreatedAt, &ss.Images)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, ss)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return searches, nil
+}
+
+// SetSavedSearchImages records the images matching the saved search id, with
+// their scores, as evaluated with model at the time at.
+func (db *DB) SetSavedSearchImages(ctx context.Context, id int, model string, scores map[int]float32, at time.Time) error {
+	txn, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	if _, err := txn.ExecContext(ctx, `DELETE FROM saved_search_images WHERE search_id=$1`, id); err != nil {
+		return err
+	}
+	for imageId, score := range scores {
+		_, err := txn.ExecContext(ctx, `
+			INSERT INTO saved_search_images (search_id, image_id, score)
+			VALUES ($1,$2,$3)`,
+			id, imageId, score)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = txn.ExecContext(ctx, `
+		UPDATE saved_searches SET model=$1, evaluated_at=$2
+		WHERE id=$3`,
+		model, at, id)
+	if err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// SavedSearchImages returns the images matching the saved search id, best
+// first, and their scores keyed by image id.
+func (db *DB) SavedSearchImages(ctx context.Context, id int) ([]*Image, map[int]float32, error) {
+	images, err := db.queryImages(ctx, `
+		SELECT i.id, `+imagePathSQL+`, `+imageRelPathSQL+`,
+		       i.image_mtime, i.image_description, i.processed_at,
+		       i.attempted_at, COALESCE(i.model, ''), COALESCE(i.describer, ''),
+		       i.image_width, i.image_height, i.describe_ms, i.content_hash,
+		       COALESCE(i.collection_id, 0), i.caption,
+		       COALESCE(i.description_id, 0)
+		FROM saved_search_images si
+		INNER JOIN images i ON si.image_id=i.id
+		`+rootsJoin+`
+		WHERE si.search_id=$1
+		ORDER BY si.score DESC, i.id`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT image_id, score
+		FROM saved_search_images
+		WHERE search_id=$1`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	scores := map[int]float32{}
+	for rows.Next() {
+		var (
+			imageId int
+			score   float32
+		)
+		if err := rows.Scan(&imageId, &score); err != nil {
+			return nil, nil, err
+		}
+		scores[imageId] = score
+	}
+	if rows.Err() != nil {
+		return nil, nil, rows.Err()
+	}
+
+	return images, scores, nil
+}
+
+// EmbeddedSince returns whether any embedding has been computed with model
+// after the time at.
+func (db *DB) EmbeddedSince(ctx context.Context, model string, at time.Time) (bool, error) {
+	var embedded bool
+	err := db.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM embeddings
+			WHERE model=$1 AND julianday(processed_at)>julianday($2)
+		)`, model, at).Scan(&embedded)
+	return embedded, err
+}
@@ -1,6 +1,22 @@
+CREATE TABLE collections (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR UNIQUE NOT NULL,
+    describer TEXT NOT NULL DEFAULT '',
+    prompt TEXT NOT NULL DEFAULT '',
+    include TEXT NOT NULL DEFAULT '',
+    exclude TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE roots (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR UNIQUE NOT NULL,
+    path TEXT NOT NULL,
+    collection_id INTEGER REFERENCES collections(id)
+);
+
 CREATE TABLE images (
     id INTEGER NOT NULL PRIMARY KEY,
-    image_path TEXT UNIQUE NOT NULL,
+    image_path TEXT NOT NULL,
     image_mtime TIMESTAMP NOT NULL,
     image_description TEXT,
     processed_at TIMESTAMP,
@@ -8,22 +24,82 @@ CREATE TABLE images (
     describer VARCHAR,
     model VARCHAR,
     image_width INTEGER,
-    image_height INTEGER
+    image_height INTEGER,
+    describe_ms INTEGER,
+    content_hash VARCHAR,
+    root_id INTEGER,
+    collection_id INTEGER REFERENCES collections(id),
+    taken_at TIMESTAMP,
+    favorite INTEGER NOT NULL DEFAULT 0,
+    caption TEXT NOT NULL DEFAULT '',
+    edited_at TIMESTAMP,
+    description_id INTEGER REFERENCES descriptions(id)
 );
 
-CREATE UNIQUE INDEX images_image_path_model_index
-ON images(image_path,model);
+CREATE UNIQUE INDEX images_root_id_image_path_index
+ON images(root_id,image_path);
+
+CREATE INDEX images_content_hash_index
+ON images(content_hash);
+
+CREATE INDEX images_collection_id_index
+ON images(collection_id);
+
+CREATE TABLE descriptions (
+    id INTEGER NOT NULL PRIMARY KEY,
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    model VARCHAR NOT NULL,
+    prompt_version VARCHAR NOT NULL DEFAULT '',
+    describer VARCHAR NOT NULL DEFAULT '',
+    description TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL,
+    describe_ms INTEGER
+);
+
+CREATE UNIQUE INDEX descriptions_image_id_model_prompt_version_index
+ON descriptions(image_id,model,prompt_version);
+
+CREATE TABLE description_history (
+    id INTEGER NOT NULL PRIMARY KEY,
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    description TEXT NOT NULL,
+    author VARCHAR NOT NULL,
+    human INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX description_history_image_id_index
+ON description_history(image_id);
+
+CREATE TABLE labels (
+    id INTEGER NOT NULL PRIMARY KEY,
+    kind VARCHAR NOT NULL,
+    name VARCHAR NOT NULL
+);
+
+CREATE UNIQUE INDEX labels_kind_name_index
+ON labels(kind,name);
+
+CREATE TABLE image_labels (
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    label_id INTEGER NOT NULL REFERENCES labels(id),
+    PRIMARY KEY (image_id,label_id)
+);
 
 CREATE TABLE embeddings (
     id INTEGER NOT NULL PRIMARY KEY,
     image_id INTEGER NOT NULL,
     vector BLOB,
     processed_at TIMESTAMP,
-    model VARCHAR
+    model VARCHAR,
+    description_id INTEGER REFERENCES descriptions(id)
 );
 
-CREATE UNIQUE INDEX embeddings_image_id_model_index
-ON embeddings(image_id,model);
+CREATE UNIQUE INDEX embeddings_description_id_model_index
+ON embeddings(description_id,model);
+
+CREATE INDEX embeddings_image_id_index
+ON embeddings(image_id);
 
 CREATE VIEW embeds AS
 SELECT
@@ -34,3 +110,62 @@ SELECT
     processed_at
 FROM
     embeddings;
+
+CREATE TABLE jobs (
+    id INTEGER NOT NULL PRIMARY KEY,
+    kind VARCHAR NOT NULL,
+    arg TEXT NOT NULL DEFAULT '',
+    state VARCHAR NOT NULL,
+    total INTEGER NOT NULL DEFAULT 0,
+    done INTEGER NOT NULL DEFAULT 0,
+    errors INTEGER NOT NULL DEFAULT 0,
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE feedback (
+    id INTEGER NOT NULL PRIMARY KEY,
+    query TEXT NOT NULL,
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    model VARCHAR NOT NULL,
+    relevant INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX feedback_query_image_id_model_index
+ON feedback(query,image_id,model);
+
+CREATE TABLE relevance (
+    id INTEGER NOT NULL PRIMARY KEY,
+    query TEXT NOT NULL,
+    description_hash VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    score REAL NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX relevance_query_description_hash_model_index
+ON relevance(query,description_hash,model);
+
+CREATE TABLE saved_searches (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    query TEXT NOT NULL,
+    filter TEXT NOT NULL DEFAULT '',
+    threshold REAL NOT NULL,
+    model VARCHAR NOT NULL DEFAULT '',
+    evaluated_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX saved_searches_name_index
+ON saved_searches(name);
+
+CREATE TABLE saved_search_images (
+    search_id INTEGER NOT NULL REFERENCES saved_searches(id),
+    image_id INTEGER NOT NULL REFERENCES images(id),
+    score REAL NOT NULL,
+    PRIMARY KEY (search_id, image_id)
+);
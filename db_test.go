@@ -2,6 +2,9 @@ package henri
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -63,3 +66,299 @@ func TestInsertImagePaths(t *testing.T) {
 		}
 	})
 }
+
+func TestImagePaths(t *testing.T) {
+	db, err := NewDB(t.Context(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	paths, err := db.ImagePaths(t.Context())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if expected, actual := 0, len(paths); expected != actual {
+		t.Errorf("Expected %d paths, got %d", expected, actual)
+	}
+
+	imgs := []ImagePath{
+		{Path: "/path/to/1", Modtime: time.Now(), Width: 640, Height: 480},
+		{Path: "/path/to/2", Modtime: time.Now(), Width: 800, Height: 600},
+	}
+	if _, err := db.InsertImagePaths(t.Context(), imgs, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err = db.ImagePaths(t.Context())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if expected, actual := 2, len(paths); expected != actual {
+		t.Fatalf("Expected %d paths, got %d", expected, actual)
+	}
+	for i, img := range imgs {
+		if expected, actual := img.Path, paths[i]; expected != actual {
+			t.Errorf("Expected path %q, got %q", expected, actual)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, err := NewDB(t.Context(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stats, err := db.Stats(t.Context())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if expected, actual := 0, stats.Images; expected != actual {
+		t.Errorf("Expected %d images, got %d", expected, actual)
+	}
+
+	paths := make([]ImagePath, 5)
+	for i := range paths {
+		paths[i] = ImagePath{Path: fmt.Sprintf("/path/to/%d.jpg", i+1), Modtime: time.Now()}
+	}
+	if _, err := db.InsertImagePaths(t.Context(), paths, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Images 1 and 2 are described, 3 failed, 4 and 5 are pending. Only
+	// image 1 has an embedding.
+	for i, d := range []time.Duration{2 * time.Second, 4 * time.Second} {
+		img := &Image{Id: i + 1, Description: "a description", DescribeTime: d}
+		img.ProcessedAt.Time, img.ProcessedAt.Valid = time.Now(), true
+		if err := db.UpdateImage(t.Context(), img, "llava", "ollama"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.UpdateImageAttempted(t.Context(), 3, "llava", "ollama", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateEmbedding(t.Context(), []float32{1, 0}, "embedder", &Image{Id: 1}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = db.Stats(t.Context())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	tests := []struct {
+		name             string
+		expected, actual int
+	}{
+		{"images", 5, stats.Images},
+		{"described", 2, stats.Described},
+		{"failed", 1, stats.Failed},
+		{"pending", 2, stats.Pending},
+		{"embeddings", 1, stats.Embeddings["embedder"]},
+		{"missing embeddings", 1, stats.MissingEmbeddings["embedder"]},
+	}
+	for _, tc := range tests {
+		if tc.expected != tc.actual {
+			t.Errorf("Expected %d %s, got %d", tc.expected, tc.name, tc.actual)
+		}
+	}
+	if expected, actual := 3*time.Second, stats.AvgDescribeTime; expected != actual {
+		t.Errorf("Expected average describe time %s, got %s", expected, actual)
+	}
+	if stats.DBSize <= 0 {
+		t.Errorf("Expected a positive DB size, got %d", stats.DBSize)
+	}
+}
+
+func TestRoots(t *testing.T) {
+	db, err := NewDB(t.Context(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Images scanned before roots existed have the paths they were scanned
+	// with, relative ones to the working directory
+	legacy := []ImagePath{
+		{Path: "/photos/1.jpg", Modtime: time.Now()},
+		{Path: "/photos/2024/2.jpg", Modtime: time.Now()},
+		{Path: "/elsewhere/3.jpg", Modtime: time.Now()},
+		{Path: "/Users/José/Pictures/día/4.jpg", Modtime: time.Now()},
+		{Path: "library/5.jpg", Modtime: time.Now()},
+		{Path: "./library/2024/6.jpg", Modtime: time.Now()},
+		{Path: "libraryish/7.jpg", Modtime: time.Now()},
+	}
+	if _, err := db.InsertImagePaths(t.Context(), legacy, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := db.EnsureRoot(t.Context(), "photos", "/photos")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if again, err := db.EnsureRoot(t.Context(), "photos", "/photos"); err != nil || again.Id != root.Id {
+		t.Errorf("Expected the existing root %d, got %v %v", root.Id, again, err)
+	}
+	if _, err := db.EnsureRoot(t.Context(), "photos", "/mnt/photos"); err == nil {
+		t.Errorf("Expected an error for a root at a different path")
+	}
+	if _, err := db.EnsureRoot(t.Context(), "pictures", "/Users/José/Pictures"); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.EnsureRoot(t.Context(), "library", filepath.Join(wd, "library")); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// Scanning the root again finds nothing new
+	n, err := db.InsertImagePaths(t.Context(), []ImagePath{
+		{RootId: root.Id, Path: "1.jpg", Modtime: time.Now()},
+		{RootId: root.Id, Path: "2024/2.jpg", Modtime: time.Now()},
+	}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, n; expected != actual {
+		t.Errorf("Expected %d new images, got %d", expected, actual)
+	}
+
+	if err := db.RelocateRoot(t.Context(), "photos", "/mnt/photos"); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if err := db.RelocateRoot(t.Context(), "videos", "/mnt/videos"); err == nil {
+		t.Errorf("Expected an error relocating a missing root")
+	}
+
+	tests := []struct {
+		id            int
+		path, relPath string
+	}{
+		{1, "/mnt/photos/1.jpg", "1.jpg"},
+		{2, "/mnt/photos/2024/2.jpg", "2024/2.jpg"},
+		{3, "/elsewhere/3.jpg", ""},
+		{4, "/Users/José/Pictures/día/4.jpg", "día/4.jpg"},
+		{5, filepath.Join(wd, "library/5.jpg"), "5.jpg"},
+		{6, filepath.Join(wd, "library/2024/6.jpg"), "2024/6.jpg"},
+		{7, "libraryish/7.jpg", ""},
+	}
+	for _, tc := range tests {
+		img, err := db.GetImage(t.Context(), tc.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := tc.path, img.Path; expected != actual {
+			t.Errorf("Expected image %d path %q, got %q", tc.id, expected, actual)
+		}
+		if expected, actual := tc.relPath, img.RelPath; expected != actual {
+			t.Errorf("Expected image %d relative path %q, got %q", tc.id, expected, actual)
+		}
+	}
+}
+
+func TestCollections(t *testing.T) {
+	db, err := NewDB(t.Context(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	family, err := db.EnsureCollection(t.Context(), "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	family.Describer = "ollama://localhost:11434?model=llava"
+	family.Include = []string{"*.jpg", "2024/*"}
+	if err := db.UpdateCollection(t.Context(), family); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := db.EnsureCollection(t.Context(), "family"); err != nil {
+		t.Fatal(err)
+	} else if expected, actual := family.Include, again.Include; !slices.Equal(expected, actual) {
+		t.Errorf("Expected include patterns %v, got %v", expected, actual)
+	}
+
+	root, err := db.EnsureRoot(t.Context(), "photos", "/photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []ImagePath{
+		{RootId: root.Id, Path: "1.jpg", Modtime: time.Now()},
+		{RootId: root.Id, Path: "2.jpg", Modtime: time.Now()},
+	}
+	if _, err := db.InsertImagePaths(t.Context(), images, 10); err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 2; id++ {
+		img, err := db.GetImage(t.Context(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img.Description = "a photo"
+		if err := db.UpdateImage(t.Context(), img, "llava", "ollama"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.CreateEmbedding(t.Context(), []float32{1, 0}, "m", img, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Moving the root into the collection moves its images too
+	if err := db.SetRootCollection(t.Context(), root.Id, family.Id); err != nil {
+		t.Fatal(err)
+	}
+	collections, err := db.Collections(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, collections[0].Images; expected != actual {
+		t.Errorf("Expected %d images in the collection, got %d", expected, actual)
+	}
+
+	tests := []struct {
+		collections []int
+		expected    int
+	}{
+		{nil, 2},
+		{[]int{family.Id}, 2},
+		{[]int{family.Id + 1}, 0},
+	}
+	for _, tc := range tests {
+		ids, err := db.EmbeddingIdsForModel(t.Context(), "m", ImageFilter{Collections: tc.collections})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := len(ids); tc.expected != actual {
+			t.Errorf("Expected %d embeddings in collections %v, got %d", tc.expected, tc.collections, actual)
+		}
+	}
+}
+
+func TestContainsWords(t *testing.T) {
+	tests := []struct {
+		text, phrase string
+		expected     bool
+	}{
+		{"A cat on a mat.", "cat", true},
+		{"A cat on a mat.", "CAT", true},
+		{"Cats, in a category of their own.", "cat", false},
+		{"The concatenated image", "cat", false},
+		{"A sand castle on the beach.", "sand castle", true},
+		{"A sand-castle on the beach.", "sand castle", true},
+		{"Sand and a castle", "sand castle", false},
+		{"A dog's bowl", "dog", true},
+		{"Voilà la plage", "VOILÀ", true},
+		{"Voilà la plage", "voil", false},
+		{"Рыба и хлеб", "хлеб", true},
+		{"Anything", "", true},
+		{"", "cat", false},
+	}
+	for _, tc := range tests {
+		if expected, actual := tc.expected, containsWords(tc.text, tc.phrase); expected != actual {
+			t.Errorf("%q in %q: expected %t, got %t", tc.phrase, tc.text, expected, actual)
+		}
+	}
+}
@@ -1,24 +1,128 @@
 package describer
 
-import "context"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
 
-// Describer describes an image used a specific LLM.
-type Describer interface {
+// Backend holds the methods common to every LLM backend, regardless of what
+// it is capable of.
+type Backend interface {
 	// Name returns the name of the backing LLM, e.g. "llama" or "ollama"
 	Name() string
 
 	// Returns the model identifier, e.g. llava-7b, llama-13b, gpt-4o-mini
 	Model() string
 
+	// IsHealthy returns whether the LLM server is healthy.
+	IsHealthy() bool
+}
+
+// DefaultPrompt is the prompt backends describe images with unless they are
+// given another with the prompt param.
+const DefaultPrompt = "please describe this image in detail"
+
+// PromptVersion identifies prompt among the prompts images are described with,
+// so descriptions from different prompts can be told apart. The default
+// prompt's version is empty, others are a short hash of the prompt.
+func PromptVersion(prompt string) string {
+	if prompt == DefaultPrompt {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:4])
+}
+
+// ImageDescriber describes an image using a specific LLM.
+type ImageDescriber interface {
+	Backend
+
 	// DescribeImage returns a string contains an English description of the
 	// provided image. The image data should be the full contents of a JPEG file
 	// including the header. The provided ctx is used as a parent context for
 	// the request to the LLM server.
 	DescribeImage(ctx context.Context, image []byte) (string, error)
 
+	// Prompt returns the prompt images are described with.
+	Prompt() string
+}
+
+// TextEmbedder computes embedding vectors for text using a specific LLM.
+type TextEmbedder interface {
+	Backend
+
 	// Embeddings returns the embeddings vector for the given text.
 	Embeddings(ctx context.Context, description string) ([]float32, error)
+}
 
-	// IsHealthy returns whether the LLM server is healthy.
-	IsHealthy() bool
+// Reranker rates how relevant a description is to a search query with a text
+// model, for re-ordering the best matches of a search.
+type Reranker interface {
+	Backend
+
+	// Relevance returns the relevance of description to query, from 0 for
+	// unrelated to 1 for a perfect match.
+	Relevance(ctx context.Context, query, description string) (float32, error)
+}
+
+// RelevancePrompt asks a text model to rate the relevance of description to
+// query, replying with a whole number from 0 to 10.
+func RelevancePrompt(query, description string) string {
+	return fmt.Sprintf(`Rate how well this photo description matches the search query, from 0 (not at all) to 10 (perfectly). Reply with the number only.
+
+Search query: %s
+
+Photo description: %s`, query, description)
+}
+
+var ratingRE = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// ParseRelevance reads the rating at the start of a reply to RelevancePrompt
+// as a relevance from 0 to 1. It returns false if the reply has no rating.
+func ParseRelevance(reply string) (float32, bool) {
+	m := ratingRE.FindString(reply)
+	if m == "" {
+		return 0, false
+	}
+	rating, err := strconv.ParseFloat(m, 32)
+	if err != nil {
+		return 0, false
+	}
+	return float32(min(rating, 10) / 10), true
+}
+
+// Describer is a backend that can both describe images and embed text.
+type Describer interface {
+	ImageDescriber
+	TextEmbedder
+}
+
+// Progress is reported by a StreamingDescriber as a description is generated.
+type Progress struct {
+	Tokens  int           // tokens generated so far
+	Elapsed time.Duration // time since the request was sent
+	Done    bool          // set on the final report
+}
+
+// TokensPerSecond returns the generation rate so far.
+func (p Progress) TokensPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Tokens) / p.Elapsed.Seconds()
+}
+
+// StreamingDescriber is implemented by backends that stream descriptions as
+// they are generated.
+type StreamingDescriber interface {
+	ImageDescriber
+
+	// DescribeImageStream is DescribeImage that calls progress, if not nil,
+	// as each token arrives and once more when the description is complete.
+	DescribeImageStream(ctx context.Context, image []byte, progress func(Progress)) (string, error)
 }
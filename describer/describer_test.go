@@ -0,0 +1,24 @@
+package describer
+
+import "testing"
+
+func TestParseRelevance(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  float32
+		ok    bool
+	}{
+		{"7", 0.7, true},
+		{" 10\n", 1, true},
+		{"Rating: 3/10", 0.3, true},
+		{"8.5", 0.85, true},
+		{"42", 1, true},
+		{"none of it matches", 0, false},
+	}
+	for _, tc := range tests {
+		score, ok := ParseRelevance(tc.reply)
+		if score != tc.want || ok != tc.ok {
+			t.Errorf("%q: expected %v %t, got %v %t", tc.reply, tc.want, tc.ok, score, ok)
+		}
+	}
+}
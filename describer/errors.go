@@ -0,0 +1,106 @@
+package describer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The kinds of backend failure. Backends return an *Error wrapping one of
+// these, test for them with errors.Is.
+var (
+	ErrModelNotFound = errors.New("model not found")
+	ErrOverloaded    = errors.New("server overloaded")
+	ErrContextLength = errors.New("context length exceeded")
+	ErrTimeout       = errors.New("request timed out")
+	ErrBadResponse   = errors.New("bad response")
+)
+
+// Error is a failed request to a backend.
+type Error struct {
+	Backend string // backend name, e.g. "ollama"
+	Kind    error  // one of the Err* values above
+	Status  int    // HTTP status code, 0 if there was no response
+	Msg     string // detail, e.g. the error message from the server
+	Err     error  // underlying error, may be nil
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s", e.Backend, e.Kind)
+	if e.Status != 0 {
+		fmt.Fprintf(&sb, " (status %d)", e.Status)
+	}
+	if e.Msg != "" {
+		fmt.Fprintf(&sb, " - %s", e.Msg)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&sb, " - %s", e.Err)
+	}
+	return sb.String()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// IsRetryable reports whether err is a transient backend failure that may
+// succeed if the request is repeated.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrOverloaded) || errors.Is(err, ErrTimeout)
+}
+
+// StatusError classifies a non-2xx HTTP response from a backend server. msg
+// is the error message from the response body, if there was one.
+func StatusError(backend string, status int, msg string) *Error {
+	kind := ErrBadResponse
+	switch lmsg := strings.ToLower(msg); {
+	case strings.Contains(lmsg, "context length"),
+		strings.Contains(lmsg, "context size"),
+		strings.Contains(lmsg, "context_length_exceeded"):
+		kind = ErrContextLength
+	case status == http.StatusNotFound,
+		strings.Contains(lmsg, "model") && strings.Contains(lmsg, "not found"):
+		kind = ErrModelNotFound
+	case status == http.StatusTooManyRequests, status == http.StatusServiceUnavailable:
+		kind = ErrOverloaded
+	case status == http.StatusRequestTimeout, status == http.StatusGatewayTimeout:
+		kind = ErrTimeout
+	}
+
+	return &Error{Backend: backend, Kind: kind, Status: status, Msg: msg}
+}
+
+// BadResponse returns an error for a response that could not be understood.
+func BadResponse(backend string, format string, args ...any) *Error {
+	return &Error{Backend: backend, Kind: ErrBadResponse, Msg: fmt.Sprintf(format, args...)}
+}
+
+// RequestError classifies an error from sending a request or reading its
+// response. Cancellation by the caller is not a backend failure and is
+// returned unchanged, as are errors that do not fit a kind such as a refused
+// connection.
+func RequestError(backend string, err error) error {
+	var (
+		ne  net.Error
+		se  *json.SyntaxError
+		ute *json.UnmarshalTypeError
+	)
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return &Error{Backend: backend, Kind: ErrTimeout, Err: err}
+	case errors.As(err, &se), errors.As(err, &ute), errors.Is(err, io.ErrUnexpectedEOF):
+		return &Error{Backend: backend, Kind: ErrBadResponse, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package describer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		status int
+		msg    string
+		want   error
+	}{
+		{404, `model "llava" not found, try pulling it first`, ErrModelNotFound},
+		{400, `model 'llava:13b' not found`, ErrModelNotFound},
+		{503, "server busy, please try again", ErrOverloaded},
+		{429, "rate limit reached", ErrOverloaded},
+		{500, "the request exceeds the available context size", ErrContextLength},
+		{400, `{"code":"context_length_exceeded"}`, ErrContextLength},
+		{504, "", ErrTimeout},
+		{500, "something broke", ErrBadResponse},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%d %s", tc.status, tc.msg), func(t *testing.T) {
+			err := StatusError("test", tc.status, tc.msg)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("Expected %q, got %q", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	if err := RequestError("test", context.Canceled); err != context.Canceled {
+		t.Errorf("Expected cancellation to be returned unchanged, got %v", err)
+	}
+	if err := RequestError("test", fmt.Errorf("wrapped - %w", context.DeadlineExceeded)); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+	if err := RequestError("test", io.ErrUnexpectedEOF); !errors.Is(err, ErrBadResponse) {
+		t.Errorf("Expected bad response, got %v", err)
+	}
+
+	var v []float32
+	jerr := json.Unmarshal([]byte(`{"x":`), &v)
+	if err := RequestError("test", jerr); !errors.Is(err, ErrBadResponse) || !errors.Is(err, jerr) {
+		t.Errorf("Expected bad response wrapping the json error, got %v", err)
+	}
+
+	if !IsRetryable(RequestError("test", context.DeadlineExceeded)) {
+		t.Errorf("Expected timeouts to be retryable")
+	}
+}
@@ -0,0 +1,143 @@
+package describer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Param describes a configuration parameter accepted by a backend. Parameters
+// are supplied as URI query values, e.g. the model in
+// ollama://localhost:11434?model=llava.
+type Param struct {
+	Name        string
+	Default     string // used when the parameter is absent from the URI
+	Description string
+}
+
+// Config is the configuration handed to a backend constructor. It is parsed
+// from a backend URI of the form name://host:port?param=value.
+type Config struct {
+	Name   string     // URI scheme, the registered backend name
+	Host   string     // host:port, may be empty for hosted APIs
+	Path   string     // base path of the server's API, e.g. behind a proxy
+	Params url.Values // validated against the registered params, defaults applied
+
+	HttpClient *http.Client
+}
+
+// Get returns the value of the named parameter.
+func (c Config) Get(name string) string { return c.Params.Get(name) }
+
+// ServerAddr returns the HTTP address of the backend server, e.g.
+// http://localhost:11434 or http://proxy/ollama. Setting the tls param
+// switches to https.
+func (c Config) ServerAddr() string {
+	scheme := "http"
+	if c.Get("tls") == "true" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Host + strings.TrimSuffix(c.Path, "/")
+}
+
+// Registration describes a backend to the registry.
+type Registration struct {
+	Name        string // URI scheme that selects the backend
+	Description string
+	NeedsHost   bool // whether the URI must include host:port
+	Params      []Param
+
+	// New constructs the backend. The returned value must implement at least
+	// one of ImageDescriber or TextEmbedder.
+	New func(cfg Config) (Backend, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Registration{}
+)
+
+// Register makes a backend available by name. It is intended to be called
+// from the init function of the backend's package. Register panics if a
+// backend with the same name is already registered.
+func Register(r Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if r.New == nil {
+		panic("describer: Register constructor is nil for " + r.Name)
+	}
+	if _, dup := registry[r.Name]; dup {
+		panic("describer: Register called twice for " + r.Name)
+	}
+	registry[r.Name] = r
+}
+
+// Registered returns all registered backends sorted by name.
+func Registered() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	regs := make([]Registration, 0, len(registry))
+	for _, r := range registry {
+		regs = append(regs, r)
+	}
+	slices.SortFunc(regs, func(a, b Registration) int { return strings.Compare(a.Name, b.Name) })
+	return regs
+}
+
+// ParseConfig parses a backend URI and validates it against the registered
+// backend's params. Missing params are filled in with their defaults.
+func ParseConfig(uri string) (Registration, Config, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return Registration{}, Config{}, fmt.Errorf("invalid backend %q - %w", uri, err)
+	}
+	if u.Scheme == "" {
+		return Registration{}, Config{}, fmt.Errorf("invalid backend %q, expected name://host:port", uri)
+	}
+
+	registryMu.RLock()
+	reg, ok := registry[u.Scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return Registration{}, Config{}, fmt.Errorf("unknown backend %q", u.Scheme)
+	}
+
+	if reg.NeedsHost && u.Host == "" {
+		return Registration{}, Config{}, fmt.Errorf("backend %s requires a host, e.g. %s://localhost:8080", reg.Name, reg.Name)
+	}
+
+	params := u.Query()
+	for name := range params {
+		if !slices.ContainsFunc(reg.Params, func(p Param) bool { return p.Name == name }) {
+			return Registration{}, Config{}, fmt.Errorf("backend %s does not accept param %q", reg.Name, name)
+		}
+	}
+	for _, p := range reg.Params {
+		if !params.Has(p.Name) && p.Default != "" {
+			params.Set(p.Name, p.Default)
+		}
+	}
+
+	return reg, Config{Name: reg.Name, Host: u.Host, Path: u.Path, Params: params}, nil
+}
+
+// Open constructs the backend described by uri, e.g.
+// ollama://localhost:11434?model=llava.
+func Open(uri string, httpClient *http.Client) (Backend, error) {
+	reg, cfg, err := ParseConfig(uri)
+	if err != nil {
+		return nil, err
+	}
+	cfg.HttpClient = httpClient
+
+	b, err := reg.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s - %w", reg.Name, err)
+	}
+	return b, nil
+}
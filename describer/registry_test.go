@@ -0,0 +1,87 @@
+package describer
+
+import (
+	"context"
+	"testing"
+)
+
+type testBackend struct{ cfg Config }
+
+func (t *testBackend) Name() string    { return "test" }
+func (t *testBackend) Model() string   { return t.cfg.Get("model") }
+func (t *testBackend) IsHealthy() bool { return true }
+
+func (t *testBackend) Embeddings(ctx context.Context, description string) ([]float32, error) {
+	return []float32{1}, nil
+}
+
+func init() {
+	Register(Registration{
+		Name:      "test",
+		NeedsHost: true,
+		Params: []Param{
+			{Name: "model", Default: "tiny"},
+			{Name: "tls"},
+		},
+		New: func(cfg Config) (Backend, error) { return &testBackend{cfg}, nil },
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+		host    string
+		model   string
+		addr    string
+	}{
+		{uri: "test://localhost:1234", host: "localhost:1234", model: "tiny", addr: "http://localhost:1234"},
+		{uri: "test://localhost:1234?model=big", host: "localhost:1234", model: "big", addr: "http://localhost:1234"},
+		{uri: "test://example.com?tls=true", host: "example.com", model: "tiny", addr: "https://example.com"},
+		{uri: "test://proxy/ollama/", host: "proxy", model: "tiny", addr: "http://proxy/ollama"},
+		{uri: "test://localhost:1234?color=red", wantErr: true},
+		{uri: "test://", wantErr: true},
+		{uri: "missing://localhost", wantErr: true},
+		{uri: "localhost:1234", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.uri, func(t *testing.T) {
+			_, cfg, err := ParseConfig(tc.uri)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			if expected, actual := tc.host, cfg.Host; expected != actual {
+				t.Errorf("Expected host %q, got %q", expected, actual)
+			}
+			if expected, actual := tc.model, cfg.Get("model"); expected != actual {
+				t.Errorf("Expected model %q, got %q", expected, actual)
+			}
+			if expected, actual := tc.addr, cfg.ServerAddr(); expected != actual {
+				t.Errorf("Expected server address %q, got %q", expected, actual)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	b, err := Open("test://localhost:1234?model=big", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if _, ok := b.(TextEmbedder); !ok {
+		t.Errorf("Expected backend to be a TextEmbedder")
+	}
+	if _, ok := b.(ImageDescriber); ok {
+		t.Errorf("Expected backend to not be an ImageDescriber")
+	}
+	if expected, actual := "big", b.Model(); expected != actual {
+		t.Errorf("Expected model %q, got %q", expected, actual)
+	}
+}
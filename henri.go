@@ -3,23 +3,40 @@ package henri
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/chriskillpack/henri/describer"
-	"github.com/chriskillpack/henri/internal/llama"
-	"github.com/chriskillpack/henri/internal/ollama"
-	"github.com/chriskillpack/henri/internal/openai"
+
+	// Register the built-in backends
+	_ "github.com/chriskillpack/henri/internal/fake"
+	_ "github.com/chriskillpack/henri/internal/llama"
+	_ "github.com/chriskillpack/henri/internal/ollama"
+	_ "github.com/chriskillpack/henri/internal/openai"
 )
 
 type InitOptions struct {
-	LlamaServer  string // Address of Llama server
-	LlamaSeed    int    // Seed to use with LLama (legacy behavior)
-	OllamaServer string // Address of Ollama Server
-	OpenAI       bool   // Should use OpenAI API platform
+	// Backend URIs used for image description and text embedding, e.g.
+	// ollama://localhost:11434?model=llava or openai://. If only one is given
+	// it is used for both roles, provided the backend is capable of them.
+	// See describer.Registered for the available backends.
+	Describe string
+	Embed    string
+
+	// Backend URI of a text model re-ranking search results, may be empty.
+	Rerank string
+
+	// The capabilities required by the app mode. Init will fail if a required
+	// capability cannot be satisfied by the configured backends. Certain app
+	// modes may not require a backend at all.
+	NeedDescriber bool
+	NeedEmbedder  bool
 
-	// Setting this to true removes the requirement that at least one backend
-	// is specified. Certain app modes may not require a backend.
-	NoSpecifiedBackendsOK bool
+	// The capabilities the app mode can use but can do without. They are
+	// opened if the configured backends are capable of them, and left nil
+	// otherwise.
+	WantDescriber bool
+	WantEmbedder  bool
 
 	HttpClient *http.Client // if nil uses http.DefaultClient
 	DbPath     string       // if present, initialize the database
@@ -27,7 +44,14 @@ type InitOptions struct {
 
 type Henri struct {
 	DB *DB
-	describer.Describer
+
+	Describer describer.ImageDescriber // nil unless InitOptions.NeedDescriber or WantDescriber
+	Embedder  describer.TextEmbedder   // nil unless InitOptions.NeedEmbedder or WantEmbedder
+	Reranker  describer.Reranker       // nil unless InitOptions.Rerank
+
+	DescribeURI string // backend URI for describing images, may be empty
+
+	httpClient *http.Client
 }
 
 func Init(ctx context.Context, hio InitOptions) (*Henri, error) {
@@ -35,46 +59,107 @@ func Init(ctx context.Context, hio InitOptions) (*Henri, error) {
 		return nil, errors.New("no database specified")
 	}
 
-	h := &Henri{}
-
-	var err error
-	if h.DB, err = NewDB(ctx, hio.DbPath); err != nil {
-		return nil, err
-	}
-
 	httpClient := hio.HttpClient
 	if httpClient == nil {
 		httpClient = http.DefaultClient
 	}
 
-	var n int
-	if hio.OpenAI {
-		n++
-	}
-	if hio.LlamaServer != "" {
-		n++
+	describeURI, embedURI := hio.Describe, hio.Embed
+	if describeURI == "" {
+		describeURI = embedURI
 	}
-	if hio.OllamaServer != "" {
-		n++
+	if embedURI == "" {
+		embedURI = describeURI
 	}
-	switch n {
-	case 0:
-		if !hio.NoSpecifiedBackendsOK {
+
+	// Backends are opened once, even when they serve both roles
+	opened := map[string]describer.Backend{}
+	open := func(uri string) (describer.Backend, error) {
+		if uri == "" {
 			return nil, errors.New("no backend selected")
 		}
-	case 1:
-		// no-op
-	default:
-		return nil, errors.New("multiple backends selected, only one allowed")
+		if b, ok := opened[uri]; ok {
+			return b, nil
+		}
+		b, err := describer.Open(uri, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		opened[uri] = b
+		return b, nil
 	}
 
-	if hio.OpenAI {
-		h.Describer = openai.Init(httpClient)
-	} else if hio.LlamaServer != "" {
-		h.Describer = llama.Init(hio.LlamaServer, hio.LlamaSeed, httpClient)
-	} else if hio.OllamaServer != "" {
-		h.Describer = ollama.Init("llava", hio.OllamaServer, httpClient)
+	h := &Henri{DescribeURI: describeURI, httpClient: httpClient}
+
+	if hio.NeedDescriber || (hio.WantDescriber && describeURI != "") {
+		b, err := open(describeURI)
+		if err != nil {
+			return nil, err
+		}
+		if h.Describer, err = imageDescriber(b); err != nil && hio.NeedDescriber {
+			return nil, err
+		}
+	}
+	if hio.NeedEmbedder || (hio.WantEmbedder && embedURI != "") {
+		b, err := open(embedURI)
+		if err != nil {
+			return nil, err
+		}
+		e, ok := b.(describer.TextEmbedder)
+		if !ok && hio.NeedEmbedder {
+			return nil, fmt.Errorf("backend %s cannot be used for computing embeddings", b.Name())
+		}
+		h.Embedder = e
+	}
+
+	if hio.Rerank != "" {
+		b, err := open(hio.Rerank)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := b.(describer.Reranker)
+		if !ok {
+			return nil, fmt.Errorf("backend %s cannot be used for re-ranking", b.Name())
+		}
+		h.Reranker = r
+	}
+
+	var err error
+	if h.DB, err = NewDB(ctx, hio.DbPath); err != nil {
+		return nil, err
 	}
 
 	return h, nil
 }
+
+// OpenDescriber opens the backend at uri for describing images, for example
+// one configured for a collection.
+func (h *Henri) OpenDescriber(uri string) (describer.ImageDescriber, error) {
+	b, err := describer.Open(uri, h.httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return imageDescriber(b)
+}
+
+// OpenEmbedder opens the backend at uri for computing embeddings, for example
+// another one to compare with the embedder.
+func (h *Henri) OpenEmbedder(uri string) (describer.TextEmbedder, error) {
+	b, err := describer.Open(uri, h.httpClient)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := b.(describer.TextEmbedder)
+	if !ok {
+		return nil, fmt.Errorf("backend %s cannot be used for computing embeddings", b.Name())
+	}
+	return e, nil
+}
+
+func imageDescriber(b describer.Backend) (describer.ImageDescriber, error) {
+	d, ok := b.(describer.ImageDescriber)
+	if !ok {
+		return nil, fmt.Errorf("backend %s cannot be used for describing images", b.Name())
+	}
+	return d, nil
+}
@@ -0,0 +1,194 @@
+// Package fake implements a deterministic backend that needs no LLM server. It
+// is intended for tests and demos.
+//
+// Image descriptions are generated from the SHA-256 hash of the image data, or
+// taken from a caption file named <hash>.txt when a captions directory is
+// configured. A prompt other than the default is prepended to generated
+// descriptions, so tests can tell which prompt was used. Embeddings are hashed
+// bag-of-words vectors, so texts that share words have a positive cosine
+// similarity. Relevance is the fraction of the query's words in the
+// description.
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/chriskillpack/henri/describer"
+)
+
+type fake struct {
+	dim         int
+	model       string
+	captionsDir string
+	prompt      string
+}
+
+var (
+	_ describer.Describer          = &fake{}
+	_ describer.StreamingDescriber = &fake{}
+	_ describer.Reranker           = &fake{}
+
+	adjectives = []string{"red", "blue", "green", "sunny", "snowy", "dark", "bright", "old"}
+	subjects   = []string{"dog", "cat", "car", "house", "tree", "boat", "bicycle", "person"}
+	places     = []string{"beach", "city", "forest", "kitchen", "garden", "mountain", "street", "park"}
+)
+
+func init() {
+	describer.Register(describer.Registration{
+		Name:        "fake",
+		Description: "deterministic offline backend for tests and demos, e.g. fake://",
+		Params: []describer.Param{
+			{Name: "dim", Default: "64", Description: "length of the embedding vectors"},
+			{Name: "captions", Description: "directory of <sha256>.txt caption files"},
+			{Name: "prompt", Default: describer.DefaultPrompt, Description: "prompt prepended to generated descriptions, unless the default"},
+			{Name: "model", Description: "model name to report, default is fake-<dim>"},
+		},
+		New: func(cfg describer.Config) (describer.Backend, error) {
+			dim, err := strconv.Atoi(cfg.Get("dim"))
+			if err != nil || dim <= 0 {
+				return nil, fmt.Errorf("invalid dim param %q", cfg.Get("dim"))
+			}
+			f := Init(dim, cfg.Get("captions"))
+			f.prompt = cfg.Get("prompt")
+			f.model = cfg.Get("model")
+			return f, nil
+		},
+	})
+}
+
+// Init returns a fake backend producing embeddings of length dim. If
+// captionsDir is not empty it is searched for caption files.
+func Init(dim int, captionsDir string) *fake {
+	return &fake{dim: dim, captionsDir: captionsDir, prompt: describer.DefaultPrompt}
+}
+
+func (f *fake) Name() string { return "fake" }
+
+func (f *fake) Model() string {
+	if f.model != "" {
+		return f.model
+	}
+	return fmt.Sprintf("fake-%d", f.dim)
+}
+
+func (f *fake) Prompt() string { return f.prompt }
+
+func (f *fake) IsHealthy() bool { return true }
+
+func (f *fake) DescribeImage(ctx context.Context, image []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(image)
+	if f.captionsDir != "" {
+		caption, err := os.ReadFile(filepath.Join(f.captionsDir, hex.EncodeToString(sum[:])+".txt"))
+		if err == nil {
+			return strings.TrimSpace(string(caption)), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	desc := fmt.Sprintf("The image shows a %s %s in a %s.",
+		adjectives[int(sum[0])%len(adjectives)],
+		subjects[int(sum[1])%len(subjects)],
+		places[int(sum[2])%len(places)])
+	if f.prompt != describer.DefaultPrompt {
+		desc = f.prompt + ": " + desc
+	}
+	return desc, nil
+}
+
+// DescribeImageStream reports each word of the description as a token.
+func (f *fake) DescribeImageStream(ctx context.Context, image []byte, progress func(describer.Progress)) (string, error) {
+	start := time.Now()
+	desc, err := f.DescribeImage(ctx, image)
+	if err != nil || progress == nil {
+		return desc, err
+	}
+
+	words := strings.Fields(desc)
+	for i := range words {
+		progress(describer.Progress{Tokens: i + 1, Elapsed: time.Since(start), Done: i == len(words)-1})
+	}
+	return desc, nil
+}
+
+// Relevance returns the fraction of the words of query that are in
+// description.
+func (f *fake) Relevance(ctx context.Context, query, description string) (float32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	qwords := words(query)
+	if len(qwords) == 0 {
+		return 0, nil
+	}
+	dwords := words(description)
+	var found int
+	for _, w := range qwords {
+		if slices.Contains(dwords, w) {
+			found++
+		}
+	}
+	return float32(found) / float32(len(qwords)), nil
+}
+
+// Embeddings returns a unit length hashed bag-of-words vector for text. Each
+// lowercased word is hashed to a bucket and a sign, and the signed counts are
+// accumulated per bucket.
+func (f *fake) Embeddings(ctx context.Context, text string) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	vec := make([]float32, f.dim)
+	for _, w := range words(text) {
+		h := fnv.New64a()
+		h.Write([]byte(w))
+		sum := h.Sum64()
+
+		sign := float32(1)
+		if sum&(1<<63) != 0 {
+			sign = -1
+		}
+		vec[sum%uint64(f.dim)] += sign
+	}
+
+	var mag float64
+	for _, v := range vec {
+		mag += float64(v) * float64(v)
+	}
+	if mag > 0 {
+		inv := float32(1 / math.Sqrt(mag))
+		for i := range vec {
+			vec[i] *= inv
+		}
+	}
+
+	return vec, nil
+}
+
+// words splits text into lowercased words of letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,131 @@
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func cosine(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func TestDescribeImage(t *testing.T) {
+	f := Init(64, "")
+
+	d1, err := f.DescribeImage(t.Context(), []byte("image one"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	d2, err := f.DescribeImage(t.Context(), []byte("image one"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if d1 != d2 {
+		t.Errorf("Expected identical descriptions, got %q and %q", d1, d2)
+	}
+	if d1 == "" {
+		t.Errorf("Expected a description")
+	}
+
+	t.Run("captions", func(t *testing.T) {
+		dir := t.TempDir()
+		img := []byte("captioned image")
+		sum := sha256.Sum256(img)
+		err := os.WriteFile(filepath.Join(dir, hex.EncodeToString(sum[:])+".txt"), []byte("A receipt on a desk\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f := Init(64, dir)
+		desc, err := f.DescribeImage(t.Context(), img)
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+		if expected, actual := "A receipt on a desk", desc; expected != actual {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+
+		// Images without a caption file fall back to the generated description
+		desc, err = f.DescribeImage(t.Context(), []byte("image one"))
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+		if expected, actual := d1, desc; expected != actual {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		f := Init(64, "")
+		f.prompt = "list the people"
+		desc, err := f.DescribeImage(t.Context(), []byte("image one"))
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+		if expected, actual := "list the people: "+d1, desc; expected != actual {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+	})
+}
+
+func TestEmbeddings(t *testing.T) {
+	f := Init(64, "")
+
+	dog, _ := f.Embeddings(t.Context(), "A brown dog sitting in the sun")
+	dog2, _ := f.Embeddings(t.Context(), "A brown dog sitting in the sun")
+	query, _ := f.Embeddings(t.Context(), "dog")
+	car, _ := f.Embeddings(t.Context(), "Red car parked on a street")
+
+	if expected, actual := 64, len(dog); expected != actual {
+		t.Fatalf("Expected vector length %d, got %d", expected, actual)
+	}
+	for i := range dog {
+		if dog[i] != dog2[i] {
+			t.Fatalf("Expected identical embeddings, differ at %d", i)
+		}
+	}
+	if sim := cosine(dog, dog); sim < 0.999 || sim > 1.001 {
+		t.Errorf("Expected unit length vector, got squared magnitude %f", sim)
+	}
+	if cosine(query, dog) <= cosine(query, car) {
+		t.Errorf("Expected query to be closer to dog (%f) than car (%f)", cosine(query, dog), cosine(query, car))
+	}
+
+	empty, err := f.Embeddings(t.Context(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if cosine(empty, empty) != 0 {
+		t.Errorf("Expected zero vector for empty text")
+	}
+}
+
+func TestRelevance(t *testing.T) {
+	f := Init(64, "")
+
+	tests := []struct {
+		query string
+		want  float32
+	}{
+		{"brown dog", 1},
+		{"Brown cat", 0.5},
+		{"red car", 0},
+		{"", 0},
+	}
+	for _, tc := range tests {
+		score, err := f.Relevance(t.Context(), tc.query, "A brown dog sitting in the sun")
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+		if expected, actual := tc.want, score; expected != actual {
+			t.Errorf("%q: expected %v, got %v", tc.query, expected, actual)
+		}
+	}
+}
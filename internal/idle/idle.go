@@ -0,0 +1,85 @@
+// Package idle cancels requests that stop making progress. Unlike a total
+// timeout, a slow but steady streaming response can run for as long as it
+// needs.
+package idle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/chriskillpack/henri/describer"
+)
+
+// Timer cancels a context when it has not been kicked for its timeout.
+type Timer struct {
+	d      time.Duration
+	t      *time.Timer
+	cancel context.CancelFunc
+	fired  atomic.Bool
+}
+
+// WithTimeout returns a copy of ctx that is cancelled if the returned Timer is
+// not kicked for d. A zero d disables the timer. Call Stop to release
+// resources once the request has finished.
+func WithTimeout(ctx context.Context, d time.Duration) (context.Context, *Timer) {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &Timer{d: d, cancel: cancel}
+	if d > 0 {
+		it.t = time.AfterFunc(d, func() {
+			it.fired.Store(true)
+			cancel()
+		})
+	}
+	return ctx, it
+}
+
+// Kick restarts the timeout.
+func (it *Timer) Kick() {
+	if it.t != nil && !it.fired.Load() {
+		it.t.Reset(it.d)
+	}
+}
+
+// Stop stops the timer and cancels the context.
+func (it *Timer) Stop() {
+	if it.t != nil {
+		it.t.Stop()
+	}
+	it.cancel()
+}
+
+// Reader returns a reader that kicks the timer whenever data is read from r.
+func (it *Timer) Reader(r io.Reader) io.Reader {
+	return &reader{r, it}
+}
+
+// Err converts an error from a request made with the timer's context into a
+// describer timeout error if the timer fired. Other errors are classified with
+// describer.RequestError.
+func (it *Timer) Err(backend string, err error) error {
+	if err != nil && it.fired.Load() {
+		return &describer.Error{
+			Backend: backend,
+			Kind:    describer.ErrTimeout,
+			Msg:     fmt.Sprintf("no response for %s", it.d),
+			Err:     err,
+		}
+	}
+	return describer.RequestError(backend, err)
+}
+
+type reader struct {
+	r  io.Reader
+	it *Timer
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.it.Kick()
+	}
+	return n, err
+}
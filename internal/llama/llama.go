@@ -7,11 +7,15 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"maps"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chriskillpack/henri/describer"
+	"github.com/chriskillpack/henri/internal/idle"
 )
 
 const (
@@ -54,24 +58,63 @@ var defaultparams = jsonmap{
 type llama struct {
 	srvAddr string
 	seed    int
+	prompt  string
 
-	client *http.Client
+	client      *http.Client
+	idleTimeout time.Duration
 }
 
-var _ describer.Describer = &llama{}
+var (
+	_ describer.StreamingDescriber = &llama{}
+	_ describer.Reranker           = &llama{}
+)
 
-func Init(srvAddr string, seed int, httpClient *http.Client) *llama {
+func init() {
+	describer.Register(describer.Registration{
+		Name:        "llama",
+		Description: "llama.cpp server such as llamafile, e.g. llama://localhost:8080",
+		NeedsHost:   true,
+		Params: []describer.Param{
+			{Name: "seed", Default: "385480504", Description: "random seed sent with each request (legacy)"},
+			{Name: "tls", Default: "false", Description: "connect to the server over https"},
+			{Name: "idle_timeout", Default: "60s", Description: "give up when the server sends nothing for this long"},
+			{Name: "prompt", Default: describer.DefaultPrompt, Description: "prompt to describe images with"},
+		},
+		New: func(cfg describer.Config) (describer.Backend, error) {
+			seed, err := strconv.Atoi(cfg.Get("seed"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid seed param - %w", err)
+			}
+			if _, err := strconv.ParseBool(cfg.Get("tls")); err != nil {
+				return nil, fmt.Errorf("invalid tls param - %w", err)
+			}
+			idleTimeout, err := time.ParseDuration(cfg.Get("idle_timeout"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid idle_timeout param - %w", err)
+			}
+			l := Init(cfg.ServerAddr(), seed, cfg.HttpClient, idleTimeout)
+			l.prompt = cfg.Get("prompt")
+			return l, nil
+		},
+	})
+}
+
+// Init returns a llama backend. Requests are abandoned if the server sends
+// nothing for idleTimeout, zero disables this.
+func Init(srvAddr string, seed int, httpClient *http.Client, idleTimeout time.Duration) *llama {
 	return &llama{
-		srvAddr: srvAddr,
-		seed:    seed,
-		client:  httpClient,
+		srvAddr:     srvAddr,
+		seed:        seed,
+		prompt:      describer.DefaultPrompt,
+		client:      httpClient,
+		idleTimeout: idleTimeout,
 	}
 }
 
 /*
 func prompt(ctx context.Context, query string) (string, error) {
 	// Prompt doesn't have to be a streaming request, just kicking the tires of that code path
-	return l.sendRequest(ctx, queryPrompt(query), true, map[string]any{})
+	return l.sendRequest(ctx, queryPrompt(query), true, map[string]any{}, nil)
 }
 */
 
@@ -79,6 +122,8 @@ func (l *llama) Name() string { return "llama" }
 
 func (l *llama) Model() string { return "llava-7b" }
 
+func (l *llama) Prompt() string { return l.prompt }
+
 func (l *llama) IsHealthy() bool {
 	resp, err := http.Get(l.srvAddr)
 	if err != nil {
@@ -89,18 +134,36 @@ func (l *llama) IsHealthy() bool {
 }
 
 func (l *llama) DescribeImage(ctx context.Context, image []byte) (string, error) {
+	return l.DescribeImageStream(ctx, image, nil)
+}
+
+func (l *llama) DescribeImageStream(ctx context.Context, image []byte, progress func(describer.Progress)) (string, error) {
 	imb64 := base64.StdEncoding.EncodeToString(image)
-	return l.sendRequest(ctx, imagePreamble+"[img-10]please describe this image in detail"+imageSuffix, false, jsonmap{
+	return l.sendRequest(ctx, imagePreamble+"[img-10]"+l.prompt+imageSuffix, true, jsonmap{
 		"image_data": []jsonmap{
 			{
 				"data": imb64, "id": 10,
 			},
 		},
-	})
+	}, progress)
 }
 
-func (l *llama) Embeddings(ctx context.Context, description string) ([]float32, error) {
-	panic("Not implemented for llama")
+// Relevance asks the model to rate description against query. The reply is
+// generated without sampling so ratings are repeatable.
+func (l *llama) Relevance(ctx context.Context, query, description string) (float32, error) {
+	reply, err := l.sendRequest(ctx, queryPrompt(describer.RelevancePrompt(query, description)), false, jsonmap{
+		"n_predict":   8,
+		"temperature": 0,
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	score, ok := describer.ParseRelevance(reply)
+	if !ok {
+		return 0, describer.BadResponse(l.Name(), "no rating in %q", reply)
+	}
+	return score, nil
 }
 
 // Use this with a text prompt
@@ -108,7 +171,9 @@ func queryPrompt(prompt string) string {
 	return promptPreamble + prompt + promptSuffix
 }
 
-func (l *llama) sendRequest(ctx context.Context, prompt string, stream bool, keys jsonmap) (string, error) {
+// sendRequest sends a completion request. When streaming, progress (if not
+// nil) is called for each token received.
+func (l *llama) sendRequest(ctx context.Context, prompt string, stream bool, keys jsonmap, progress func(describer.Progress)) (string, error) {
 	data := maps.Clone(defaultparams)
 	maps.Copy(data, keys)
 	data["prompt"] = prompt
@@ -124,29 +189,44 @@ func (l *llama) sendRequest(ctx context.Context, prompt string, stream bool, key
 	}
 	br := bytes.NewReader(buf.Bytes())
 
+	ctx, it := idle.WithTimeout(ctx, l.idleTimeout)
+	defer it.Stop()
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.srvAddr+"/completion", br)
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	start := time.Now()
 	resp, err := l.client.Do(req)
 	if err != nil {
-		return "", err
+		return "", it.Err(l.Name(), err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", describer.StatusError(l.Name(), resp.StatusCode, errorMessage(body))
+	}
+
 	content := new(bytes.Buffer)
 	respbody := struct {
-		Content string
-		Stop    bool
+		Content         string
+		Stop            bool
+		TokensPredicted int `json:"tokens_predicted"`
 	}{}
 
-	lr := bufio.NewScanner(resp.Body)
+	var tokens int
+	lr := bufio.NewScanner(it.Reader(resp.Body))
 	for !respbody.Stop {
 		// Read in one line
 		if !lr.Scan() {
-			return "", lr.Err()
+			if err := lr.Err(); err != nil {
+				return "", it.Err(l.Name(), err)
+			}
+			// The body ended before the server signalled a stop
+			return "", describer.RequestError(l.Name(), io.ErrUnexpectedEOF)
 		}
 		line := lr.Text()
 		// TODO: Is there a way to eliminate this check? The empty line appears after a JSON body
@@ -157,16 +237,40 @@ func (l *llama) sendRequest(ctx context.Context, prompt string, stream bool, key
 			var found bool
 			line, found = strings.CutPrefix(line, "data: ")
 			if !found {
-				return "", fmt.Errorf("missing `data: ` prefix")
+				return "", describer.BadResponse(l.Name(), "missing `data: ` prefix")
 			}
 		}
 
 		dec := json.NewDecoder(bytes.NewBufferString(line))
 		if err := dec.Decode(&respbody); err != nil {
-			return "", err
+			return "", describer.RequestError(l.Name(), err)
 		}
 		content.WriteString(respbody.Content)
+
+		if stream && progress != nil {
+			if respbody.Content != "" {
+				tokens++
+			}
+			if respbody.TokensPredicted > 0 {
+				tokens = respbody.TokensPredicted
+			}
+			progress(describer.Progress{Tokens: tokens, Elapsed: time.Since(start), Done: respbody.Stop})
+		}
 	}
 
 	return strings.TrimLeft(content.String(), " "), nil
 }
+
+// errorMessage extracts the message from a llama.cpp server error response
+// body, which looks like {"error":{"code":500,"message":"...","type":"..."}}.
+func errorMessage(body []byte) string {
+	errData := struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &errData); err != nil || errData.Error.Message == "" {
+		return string(bytes.TrimSpace(body))
+	}
+	return errData.Error.Message
+}
@@ -0,0 +1,95 @@
+package llama
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/henri/describer"
+	"github.com/chriskillpack/henri/internal/replay"
+)
+
+const recordEnv = "HENRI_RECORD_LLAMA"
+
+func newTestClient(t *testing.T, golden string) *llama {
+	addr := replay.Open(t, filepath.Join("testdata", golden), recordEnv)
+	return Init(addr, 385480504, http.DefaultClient, 500*time.Millisecond)
+}
+
+func TestSendRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		golden  string
+		stream  bool
+		want    string
+		wantErr error
+	}{
+		{"success", "completion_success.json", false, "The image shows a red bicycle leaning against a brick wall.", nil},
+		{"malformed json", "completion_malformed.json", false, "", describer.ErrBadResponse},
+		{"truncated", "completion_truncated.json", false, "", describer.ErrBadResponse},
+		{"server error", "completion_error.json", false, "", describer.ErrContextLength},
+		{"timeout", "completion_slow.json", false, "", describer.ErrTimeout},
+		{"stream success", "stream_success.json", true, "The image shows a red bicycle.", nil},
+		{"stream slow but steady", "stream_steady.json", true, "The image shows a red bicycle.", nil},
+		{"stream stalled", "stream_stalled.json", true, "", describer.ErrTimeout},
+		{"stream truncated", "stream_truncated.json", true, "", describer.ErrBadResponse},
+		{"stream missing data prefix", "stream_noprefix.json", true, "", describer.ErrBadResponse},
+		{"stream malformed json", "stream_malformed.json", true, "", describer.ErrBadResponse},
+		{"stream timeout", "stream_slow.json", true, "", describer.ErrTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newTestClient(t, tc.golden)
+
+			content, err := l.sendRequest(t.Context(), queryPrompt("describe a bicycle"), tc.stream, jsonmap{}, nil)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected error %q, got %v and content %q", tc.wantErr, err, content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			if expected, actual := tc.want, content; expected != actual {
+				t.Errorf("Expected %q, got %q", expected, actual)
+			}
+		})
+	}
+}
+
+func TestDescribeImageStream(t *testing.T) {
+	l := newTestClient(t, "stream_success.json")
+
+	var last describer.Progress
+	desc, err := l.DescribeImageStream(t.Context(), []byte("jpeg data"), func(p describer.Progress) {
+		last = p
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if expected, actual := "The image shows a red bicycle.", desc; expected != actual {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+	if !last.Done {
+		t.Errorf("Expected final report to be done")
+	}
+	if expected, actual := 6, last.Tokens; expected != actual {
+		t.Errorf("Expected %d tokens, got %d", expected, actual)
+	}
+}
+
+func TestRelevance(t *testing.T) {
+	l := newTestClient(t, "completion_relevance.json")
+
+	score, err := l.Relevance(t.Context(), "bicycle", "The image shows a red bicycle leaning against a brick wall.")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if expected, actual := float32(0.6), score; expected != actual {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
@@ -17,6 +17,7 @@ We should make sure the prompt matches llama "please describe this image in deta
 */
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
@@ -25,19 +26,30 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chriskillpack/henri/describer"
+	"github.com/chriskillpack/henri/internal/idle"
 )
 
 type ollama struct {
-	srvAddr string
-	client  *http.Client
-	model   string
+	srvAddr     string
+	client      *http.Client
+	model       string
+	prompt      string
+	idleTimeout time.Duration
 }
 
+// The final line of a streamed response carries the prompt context, which can
+// be thousands of token ids.
+const maxLineSize = 16 << 20
+
 var (
-	_ describer.Describer = &ollama{}
+	_ describer.Describer          = &ollama{}
+	_ describer.StreamingDescriber = &ollama{}
+	_ describer.Reranker           = &ollama{}
 
 	// Describes models in a consist way that includes parameter count
 	modelMap = map[string]string{
@@ -45,45 +57,127 @@ var (
 	}
 )
 
-func Init(model string, srvAddr string, httpClient *http.Client) *ollama {
-	if _, ok := modelMap[model]; !ok {
-		panic(fmt.Sprintf("unrecognized model %q", model))
-	}
+func init() {
+	describer.Register(describer.Registration{
+		Name:        "ollama",
+		Description: "ollama server, e.g. ollama://localhost:11434?model=llava",
+		NeedsHost:   true,
+		Params: []describer.Param{
+			{Name: "model", Default: "llava", Description: "ollama model to request"},
+			{Name: "tls", Default: "false", Description: "connect to the server over https"},
+			{Name: "idle_timeout", Default: "60s", Description: "give up when the server sends nothing for this long"},
+			{Name: "prompt", Default: describer.DefaultPrompt, Description: "prompt to describe images with"},
+		},
+		New: func(cfg describer.Config) (describer.Backend, error) {
+			if _, err := strconv.ParseBool(cfg.Get("tls")); err != nil {
+				return nil, fmt.Errorf("invalid tls param - %w", err)
+			}
+			idleTimeout, err := time.ParseDuration(cfg.Get("idle_timeout"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid idle_timeout param - %w", err)
+			}
+			o := Init(cfg.Get("model"), cfg.ServerAddr(), cfg.HttpClient, idleTimeout)
+			o.prompt = cfg.Get("prompt")
+			return o, nil
+		},
+	})
+}
 
-	return &ollama{srvAddr, httpClient, model}
+// Init returns an ollama backend. Requests are abandoned if the server sends
+// nothing for idleTimeout, zero disables this.
+func Init(model string, srvAddr string, httpClient *http.Client, idleTimeout time.Duration) *ollama {
+	return &ollama{srvAddr, httpClient, model, describer.DefaultPrompt, idleTimeout}
 }
 
 func (o *ollama) Name() string { return "ollama" }
 
-func (o *ollama) Model() string { return modelMap[o.model] }
+// Model returns the consistent name for the model from modelMap, or the ollama
+// model name if it is not in the map.
+func (o *ollama) Model() string {
+	if m, ok := modelMap[o.model]; ok {
+		return m
+	}
+	return o.model
+}
+
+func (o *ollama) Prompt() string { return o.prompt }
 
 func (o *ollama) DescribeImage(ctx context.Context, image []byte) (string, error) {
+	return o.DescribeImageStream(ctx, image, nil)
+}
+
+func (o *ollama) DescribeImageStream(ctx context.Context, image []byte, progress func(describer.Progress)) (string, error) {
 	imb64 := base64.StdEncoding.EncodeToString(image)
 
 	// Request reqData
 	reqData := map[string]any{
 		"model":  o.model,
-		"prompt": "please describe this image in detail",
-		"stream": false,
+		"prompt": o.prompt,
+		"stream": true,
 		"images": []string{imb64},
 	}
 
-	// Response data
-	respData := struct {
-		Response   string `json:"response"`
-		Done       bool   `json:"done"`
-		DoneReason string `json:"done_reason"`
-	}{}
+	ctx, it := idle.WithTimeout(ctx, o.idleTimeout)
+	defer it.Stop()
 
-	if err := o.sendRequest(ctx, http.MethodPost, "/api/generate", reqData, &respData); err != nil {
-		return "", err
+	start := time.Now()
+	resp, err := o.do(ctx, http.MethodPost, "/api/generate", reqData)
+	if err != nil {
+		return "", it.Err(o.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	var (
+		content    strings.Builder
+		tokens     int
+		done       bool
+		doneReason string
+	)
+
+	// The response is a JSON object per line, each carrying the next token.
+	// The final object has done set and the server's token count.
+	sc := bufio.NewScanner(it.Reader(resp.Body))
+	sc.Buffer(nil, maxLineSize)
+	for !done {
+		if !sc.Scan() {
+			if err := sc.Err(); err != nil {
+				return "", it.Err(o.Name(), err)
+			}
+			// The body ended before the server signalled done
+			return "", describer.RequestError(o.Name(), io.ErrUnexpectedEOF)
+		}
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		respData := struct {
+			Response   string `json:"response"`
+			Done       bool   `json:"done"`
+			DoneReason string `json:"done_reason"`
+			EvalCount  int    `json:"eval_count"`
+		}{}
+		if err := json.Unmarshal(sc.Bytes(), &respData); err != nil {
+			return "", describer.RequestError(o.Name(), err)
+		}
+		content.WriteString(respData.Response)
+		done, doneReason = respData.Done, respData.DoneReason
+
+		if respData.Response != "" {
+			tokens++
+		}
+		if respData.EvalCount > 0 {
+			tokens = respData.EvalCount
+		}
+		if progress != nil {
+			progress(describer.Progress{Tokens: tokens, Elapsed: time.Since(start), Done: done})
+		}
 	}
 
-	if !respData.Done || respData.DoneReason != "stop" {
-		return "", fmt.Errorf("unexpected done and done_reason: %t, %s", respData.Done, respData.DoneReason)
+	if doneReason != "stop" {
+		return "", describer.BadResponse(o.Name(), "unexpected done_reason: %s", doneReason)
 	}
 
-	return strings.TrimLeft(respData.Response, " "), nil
+	return strings.TrimLeft(content.String(), " "), nil
 }
 
 func (o *ollama) IsHealthy() bool {
@@ -108,13 +202,65 @@ func (o *ollama) Embeddings(ctx context.Context, description string) ([]float32,
 	}
 
 	if len(respData.Embeddings) != 1 {
-		panic(fmt.Sprintf("Unexpected number of embeddings back %d", len(respData.Embeddings)))
+		return nil, describer.BadResponse(o.Name(), "unexpected number of embeddings back %d", len(respData.Embeddings))
 	}
 
 	return respData.Embeddings[0], nil
 }
 
+// Relevance asks the model to rate description against query. The reply is
+// generated without sampling so ratings are repeatable.
+func (o *ollama) Relevance(ctx context.Context, query, description string) (float32, error) {
+	reqData := map[string]any{
+		"model":   o.model,
+		"prompt":  describer.RelevancePrompt(query, description),
+		"stream":  false,
+		"options": map[string]any{"temperature": 0, "num_predict": 8},
+	}
+
+	respData := struct {
+		Response string `json:"response"`
+	}{}
+
+	if err := o.sendRequest(ctx, http.MethodPost, "/api/generate", reqData, &respData); err != nil {
+		return 0, err
+	}
+
+	score, ok := describer.ParseRelevance(respData.Response)
+	if !ok {
+		return 0, describer.BadResponse(o.Name(), "no rating in %q", respData.Response)
+	}
+	return score, nil
+}
+
+// sendRequest sends a request and decodes the JSON response into respData.
 func (o *ollama) sendRequest(ctx context.Context, method, path string, reqData, respData any) error {
+	ctx, it := idle.WithTimeout(ctx, o.idleTimeout)
+	defer it.Stop()
+
+	resp, err := o.do(ctx, method, path, reqData)
+	if err != nil {
+		return it.Err(o.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(it.Reader(resp.Body))
+	if err != nil {
+		return it.Err(o.Name(), err)
+	}
+
+	if len(respBody) > 0 && respData != nil {
+		if err := json.Unmarshal(respBody, respData); err != nil {
+			return describer.RequestError(o.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// do sends a request with reqData as the JSON body. Responses with a non-2xx
+// status are returned as a describer.Error.
+func (o *ollama) do(ctx context.Context, method, path string, reqData any) (*http.Response, error) {
 	var reqBody io.Reader
 
 	switch reqData.(type) {
@@ -123,37 +269,43 @@ func (o *ollama) sendRequest(ctx context.Context, method, path string, reqData,
 	default:
 		data, err := json.Marshal(reqData)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		reqBody = bytes.NewReader(data)
 	}
 
 	reqPath, err := url.JoinPath(o.srvAddr, path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, reqPath, reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	resp, err := o.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, describer.StatusError(o.Name(), resp.StatusCode, errorMessage(respBody))
 	}
 
-	if len(respBody) > 0 && respData != nil {
-		if err := json.Unmarshal(respBody, respData); err != nil {
-			return err
-		}
-	}
+	return resp, nil
+}
 
-	return nil
+// errorMessage extracts the message from an ollama error response body, which
+// looks like {"error":"model \"llava\" not found, try pulling it first"}.
+func errorMessage(body []byte) string {
+	errData := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &errData); err != nil || errData.Error == "" {
+		return string(bytes.TrimSpace(body))
+	}
+	return errData.Error
 }
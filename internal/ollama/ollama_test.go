@@ -0,0 +1,159 @@
+package ollama
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/henri/describer"
+	"github.com/chriskillpack/henri/internal/replay"
+)
+
+const recordEnv = "HENRI_RECORD_OLLAMA"
+
+func newTestClient(t *testing.T, golden string) *ollama {
+	addr := replay.Open(t, filepath.Join("testdata", golden), recordEnv)
+	return Init("llava", addr, http.DefaultClient, 500*time.Millisecond)
+}
+
+func TestDescribeImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		golden  string
+		want    string
+		wantErr error
+	}{
+		{"success", "generate_success.json", "A brown dog lying on a sunny porch.", nil},
+		{"slow but steady", "generate_steady.json", "A brown dog lying on a sunny porch.", nil},
+		{"stalled", "generate_stalled.json", "", describer.ErrTimeout},
+		{"done_reason length", "generate_length.json", "", describer.ErrBadResponse},
+		{"malformed json", "generate_malformed.json", "", describer.ErrBadResponse},
+		{"truncated", "generate_truncated.json", "", describer.ErrBadResponse},
+		{"model not found", "generate_notfound.json", "", describer.ErrModelNotFound},
+		{"timeout", "generate_slow.json", "", describer.ErrTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := newTestClient(t, tc.golden)
+
+			desc, err := o.DescribeImage(t.Context(), []byte("jpeg data"))
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected error %q, got %v and description %q", tc.wantErr, err, desc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			if expected, actual := tc.want, desc; expected != actual {
+				t.Errorf("Expected %q, got %q", expected, actual)
+			}
+		})
+	}
+}
+
+func TestDescribeImageStreamProgress(t *testing.T) {
+	o := newTestClient(t, "generate_success.json")
+
+	var reports []describer.Progress
+	_, err := o.DescribeImageStream(t.Context(), []byte("jpeg data"), func(p describer.Progress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if expected, actual := 9, len(reports); expected != actual {
+		t.Fatalf("Expected %d progress reports, got %d", expected, actual)
+	}
+	if expected, actual := 3, reports[2].Tokens; expected != actual {
+		t.Errorf("Expected %d tokens in third report, got %d", expected, actual)
+	}
+	last := reports[len(reports)-1]
+	if !last.Done {
+		t.Errorf("Expected final report to be done")
+	}
+	// The final count comes from the server's eval_count
+	if expected, actual := 9, last.Tokens; expected != actual {
+		t.Errorf("Expected %d tokens in final report, got %d", expected, actual)
+	}
+}
+
+func TestEmbeddings(t *testing.T) {
+	tests := []struct {
+		name    string
+		golden  string
+		want    []float32
+		wantErr error
+	}{
+		{"success", "embed_success.json", []float32{0.25, -0.5, 0.125, 1.0}, nil},
+		{"malformed json", "embed_malformed.json", nil, describer.ErrBadResponse},
+		{"truncated", "embed_truncated.json", nil, describer.ErrBadResponse},
+		{"no embeddings", "embed_empty.json", nil, describer.ErrBadResponse},
+		{"server overloaded", "embed_overloaded.json", nil, describer.ErrOverloaded},
+		{"timeout", "embed_slow.json", nil, describer.ErrTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := newTestClient(t, tc.golden)
+
+			vec, err := o.Embeddings(t.Context(), "A brown dog lying on a sunny porch.")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected error %q, got %v and vector %v", tc.wantErr, err, vec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			if !slices.Equal(tc.want, vec) {
+				t.Errorf("Expected %v, got %v", tc.want, vec)
+			}
+		})
+	}
+}
+
+func TestIsHealthy(t *testing.T) {
+	if !newTestClient(t, "healthy.json").IsHealthy() {
+		t.Errorf("Expected server to be healthy")
+	}
+}
+
+func TestRelevance(t *testing.T) {
+	tests := []struct {
+		name    string
+		golden  string
+		want    float32
+		wantErr error
+	}{
+		{"success", "relevance_success.json", 0.8, nil},
+		{"no rating", "relevance_unrated.json", 0, describer.ErrBadResponse},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := newTestClient(t, tc.golden)
+
+			score, err := o.Relevance(t.Context(), "dog on a porch", "A brown dog lying on a sunny porch.")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected error %q, got %v and score %v", tc.wantErr, err, score)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			if expected, actual := tc.want, score; expected != actual {
+				t.Errorf("Expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package openai
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -13,15 +14,15 @@ import (
 	"github.com/openai/openai-go/option"
 )
 
-const model = "text-embedding-3-small"
-
+// openai only implements TextEmbedder. For privacy reasons images are never
+// sent to the OpenAI API for description.
 type openai struct {
 	oac   *oagc.Client
 	model string
 }
 
 var (
-	_ describer.Describer = &openai{}
+	_ describer.TextEmbedder = &openai{}
 
 	rl *ratelimiter.Limiter // For requests to the OpenAI API
 
@@ -32,28 +33,56 @@ var (
 	}
 )
 
-func Init(httpClient *http.Client) *openai {
+func init() {
+	describer.Register(describer.Registration{
+		Name:        "openai",
+		Description: "OpenAI API, embeddings only. Reads the key from OPENAI_API_KEY, e.g. openai://",
+		Params: []describer.Param{
+			{Name: "model", Default: "text-embedding-3-small", Description: "embedding model"},
+			{Name: "base_url", Description: "override the API address, e.g. for a proxy"},
+			{Name: "timeout", Default: "30s", Description: "give up on a request attempt after this long"},
+		},
+		New: func(cfg describer.Config) (describer.Backend, error) {
+			model := cfg.Get("model")
+			if _, ok := modelDimensions[model]; !ok {
+				return nil, fmt.Errorf("unrecognized model %q", model)
+			}
+			timeout, err := time.ParseDuration(cfg.Get("timeout"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout param - %w", err)
+			}
+			return Init(model, cfg.Get("base_url"), cfg.HttpClient, timeout), nil
+		},
+	})
+}
+
+// Init returns an OpenAI backend for the embedding model. If baseURL is empty
+// the public OpenAI API is used. Embeddings don't stream, so each request
+// attempt is given up after timeout, zero disables this.
+func Init(model string, baseURL string, httpClient *http.Client, timeout time.Duration) *openai {
 	if _, ok := modelDimensions[model]; !ok {
 		panic("Unrecognized model")
 	}
 
 	rl = ratelimiter.New(200, time.Minute)
 
+	opts := []option.RequestOption{option.WithHTTPClient(httpClient)}
+	if baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+	if timeout > 0 {
+		opts = append(opts, option.WithRequestTimeout(timeout))
+	}
+
 	return &openai{
-		oac: oagc.NewClient(
-			option.WithHTTPClient(httpClient),
-		),
+		oac:   oagc.NewClient(opts...),
 		model: model,
 	}
 }
 
 func (o *openai) Name() string { return "openai" }
 
-func (o *openai) Model() string { return model }
-
-func (o *openai) DescribeImage(ctx context.Context, image []byte) (string, error) {
-	panic("not implemented for privacy reasons")
-}
+func (o *openai) Model() string { return o.model }
 
 func (o *openai) IsHealthy() bool {
 	// TODO
@@ -73,10 +102,25 @@ func (o *openai) Embeddings(ctx context.Context, description string) ([]float32,
 	}
 	resp, err := o.oac.Embeddings.New(ctx, enp)
 	if err != nil {
-		return nil, err
+		var apiErr *oagc.Error
+		if errors.As(err, &apiErr) {
+			// The API nests the details under an "error" key which the SDK
+			// does not unwrap, fall back to the raw body.
+			msg := apiErr.Message
+			if msg == "" {
+				msg = apiErr.JSON.RawJSON()
+			} else if apiErr.Code != "" {
+				msg = apiErr.Code + ": " + msg
+			}
+			return nil, describer.StatusError(o.Name(), apiErr.StatusCode, msg)
+		}
+		return nil, describer.RequestError(o.Name(), err)
+	}
+	if len(resp.Data) != 1 {
+		return nil, describer.BadResponse(o.Name(), "unexpected number of embeddings back %d", len(resp.Data))
 	}
 	if resp.Data[0].Object != oagc.EmbeddingObjectEmbedding {
-		return nil, fmt.Errorf("unexpected object type %q", resp.Data[0].Object)
+		return nil, describer.BadResponse(o.Name(), "unexpected object type %q", resp.Data[0].Object)
 	}
 
 	// Convert the float64 embedding vector to float32
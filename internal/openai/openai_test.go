@@ -0,0 +1,83 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chriskillpack/henri/describer"
+	"github.com/chriskillpack/henri/internal/replay"
+)
+
+// Recording needs OPENAI_API_KEY and HENRI_RECORD_OPENAI=https://api.openai.com/v1
+const recordEnv = "HENRI_RECORD_OPENAI"
+
+func TestEmbeddings(t *testing.T) {
+	tests := []struct {
+		name    string
+		golden  string
+		want    []float32
+		wantErr error
+	}{
+		{"success", "embeddings_success.json", []float32{0.25, -0.5, 0.125, 1.0}, nil},
+		{"malformed json", "embeddings_malformed.json", nil, describer.ErrBadResponse},
+		{"no embeddings", "embeddings_empty.json", nil, describer.ErrBadResponse},
+		{"context length exceeded", "embeddings_badrequest.json", nil, describer.ErrContextLength},
+		{"timeout", "embeddings_slow.json", nil, describer.ErrTimeout},
+	}
+
+	if os.Getenv(recordEnv) == "" {
+		t.Setenv("OPENAI_API_KEY", "test-key")
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := replay.Open(t, filepath.Join("testdata", tc.golden), recordEnv)
+			o := Init("text-embedding-3-small", addr, http.DefaultClient, 0)
+
+			// A context deadline rather than a client timeout, the client
+			// retries requests that time out.
+			ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+			defer cancel()
+
+			vec, err := o.Embeddings(ctx, "A brown dog lying on a sunny porch.")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected error %q, got %v and vector %v", tc.wantErr, err, vec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			if !slices.Equal(tc.want, vec) {
+				t.Errorf("Expected %v, got %v", tc.want, vec)
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	if os.Getenv(recordEnv) == "" {
+		t.Setenv("OPENAI_API_KEY", "test-key")
+	}
+
+	// Without a context deadline the request timeout gives up on the stalled
+	// server
+	addr := replay.Open(t, filepath.Join("testdata", "embeddings_slow.json"), "")
+	o := Init("text-embedding-3-small", addr, http.DefaultClient, 100*time.Millisecond)
+
+	start := time.Now()
+	vec, err := o.Embeddings(t.Context(), "A brown dog lying on a sunny porch.")
+	if !errors.Is(err, describer.ErrTimeout) {
+		t.Errorf("Expected error %q, got %v and vector %v", describer.ErrTimeout, err, vec)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the request to time out, took %s", elapsed)
+	}
+}
@@ -0,0 +1,206 @@
+// Package replay is a record/replay HTTP fixture for testing the backend
+// clients without a running LLM server.
+//
+// A golden file is a JSON array of Exchanges. In replay mode an httptest
+// server answers requests with the exchanges in order, checking that each
+// request matches the recorded method and path. In record mode, enabled by
+// setting the environment variable named by the test to the address of a real
+// server, requests are proxied to that server and the exchanges are written
+// to the golden file when the test finishes. For example
+//
+//	HENRI_RECORD_OLLAMA=http://localhost:11434 go test ./internal/ollama -run TestDescribeImage/success
+//
+// Failure cases such as malformed bodies, error statuses and slow servers are
+// easiest to write by hand.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Exchange is a single recorded request and its response. Request bodies are
+// not recorded, they can contain entire images.
+type Exchange struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   string            `json:"body"`
+
+	// Delay holds back the response, for simulating slow servers. It is a
+	// time.ParseDuration string, e.g. "2s".
+	Delay string `json:"delay,omitempty"`
+
+	// LineDelay sends the body a line at a time with this pause between
+	// lines, for simulating streaming responses.
+	LineDelay string `json:"line_delay,omitempty"`
+}
+
+// Open starts a server for golden and returns its address. If the environment
+// variable recordEnv holds a server address the exchanges are recorded from
+// that server instead of replayed. The server is closed when the test ends.
+func Open(t *testing.T, golden, recordEnv string) string {
+	t.Helper()
+
+	if target := os.Getenv(recordEnv); target != "" {
+		return record(t, golden, target)
+	}
+	return replay(t, golden)
+}
+
+func replay(t *testing.T, golden string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exchanges []Exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		t.Fatalf("parsing %s - %s", golden, err)
+	}
+
+	var (
+		mu   sync.Mutex
+		next int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+
+		mu.Lock()
+		if next >= len(exchanges) {
+			mu.Unlock()
+			t.Errorf("unexpected request %s %s, %s has %d exchanges", req.Method, req.URL.Path, golden, len(exchanges))
+			http.Error(w, "no more exchanges", http.StatusInternalServerError)
+			return
+		}
+		ex := exchanges[next]
+		next++
+		mu.Unlock()
+
+		if ex.Method != req.Method || ex.Path != req.URL.Path {
+			t.Errorf("expected request %s %s, got %s %s", ex.Method, ex.Path, req.Method, req.URL.Path)
+		}
+
+		if !sleep(t, req, ex.Delay) {
+			return
+		}
+
+		for k, v := range ex.Header {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(ex.Status)
+
+		if ex.LineDelay == "" {
+			io.WriteString(w, ex.Body)
+			return
+		}
+		for line := range strings.Lines(ex.Body) {
+			io.WriteString(w, line)
+			w.(http.Flusher).Flush()
+			if !sleep(t, req, ex.LineDelay) {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts.URL
+}
+
+// sleep pauses for the duration d, returning false if the request was
+// cancelled first.
+func sleep(t *testing.T, req *http.Request, d string) bool {
+	if d == "" {
+		return true
+	}
+	delay, err := time.ParseDuration(d)
+	if err != nil {
+		t.Errorf("invalid delay %q - %s", d, err)
+	}
+	select {
+	case <-req.Context().Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// pathKey holds the path of the request made to the recording proxy, before it
+// is rewritten for the target server.
+type pathKey struct{}
+
+func record(t *testing.T, golden, target string) string {
+	t.Helper()
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		exchanges []Exchange
+	)
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = targetURL.Host
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		ex := Exchange{
+			Method: resp.Request.Method,
+			Path:   resp.Request.Context().Value(pathKey{}).(string),
+			Status: resp.StatusCode,
+			Body:   string(body),
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "" {
+			ex.Header = map[string]string{"Content-Type": ct}
+		}
+
+		mu.Lock()
+		exchanges = append(exchanges, ex)
+		mu.Unlock()
+		return nil
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), pathKey{}, req.URL.Path)))
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+		data, err := json.MarshalIndent(exchanges, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, append(data, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("recorded %d exchanges to %s", len(exchanges), golden)
+	})
+
+	return ts.URL
+}